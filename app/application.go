@@ -1,39 +1,216 @@
 package app
 
 import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
 	"github.com/gin-gonic/gin"
+	"github.com/johannes-kuhfuss/c4svc/auth"
 	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/controllers"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/metrics"
+	"github.com/johannes-kuhfuss/c4svc/providers"
+	"github.com/johannes-kuhfuss/c4svc/request"
 	"github.com/johannes-kuhfuss/c4svc/services"
 	"github.com/johannes-kuhfuss/services_utils/logger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	router *gin.Engine
-)
+// App wires one instance of the service: configuration, stores, providers, services,
+// controllers and router. Several instances with different configurations can run in one process.
+type App struct {
+	cfg               *config.AppConfig
+	metrics           *metrics.Metrics
+	router            *gin.Engine
+	server            *http.Server
+	tlsConfig         *tls.Config
+	tracerProvider    trace.TracerProvider
+	stopTracing       func(context.Context) error
+	jobProcService    services.JobProcService
+	jobCleanupService services.JobCleanupService
+	mu                sync.Mutex // guards cancel and stopping
+	cancel            context.CancelFunc
+	stopping          bool
+	workers           sync.WaitGroup
+	stopOnce          sync.Once
+	stopped           chan struct{}
+}
+
+type appControllers struct {
+	ping     controllers.PingController
+	health   controllers.HealthController
+	config   controllers.ConfigController
+	job      controllers.JobController
+	manifest controllers.ManifestController
+	batch    controllers.BatchController
+	c4Index  controllers.C4IndexController
+	quota    controllers.QuotaController
+}
+
+func New(cfg *config.AppConfig) (*App, error) {
+	logger.Debug("Initializing application")
+	authenticator, err := auth.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if !authenticator.Enabled() {
+		logger.Warn("No API keys, JWKS or client CAs configured, the API does not require authentication")
+	}
+	tlsConfig, err := newTlsConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tracerProvider, stopTracing, err := newTracerProvider(cfg)
+	if err != nil {
+		return nil, err
+	}
+	tracer := tracerProvider.Tracer(serviceName)
+	jobDao := domain.NewJobDao()
+	idempotencyDao := domain.NewIdempotencyDao()
+	batchDao := domain.NewBatchDao()
+	c4IndexDao := domain.NewC4IndexDao()
+
+	c4Provider := providers.NewC4Provider(cfg, tracer)
+	manifestProvider := providers.NewManifestProvider(cfg)
+
+	appMetrics := metrics.New(jobDao, services.NewQueueLimits(cfg))
+	quotaService := services.NewQuotaService(cfg, jobDao)
+	jobService := services.NewJobService(cfg, jobDao, idempotencyDao, quotaService, tracer)
+	batchService := services.NewBatchService(cfg, jobDao, batchDao, quotaService, tracer)
+	c4IndexService := services.NewC4IndexService(c4IndexDao)
+	manifestService := services.NewManifestService(cfg, jobService, manifestProvider)
+	healthService := services.NewHealthService(cfg, jobDao, c4Provider)
+
+	a := App{
+		cfg:               cfg,
+		metrics:           appMetrics,
+		tlsConfig:         tlsConfig,
+		tracerProvider:    tracerProvider,
+		stopTracing:       stopTracing,
+		jobProcService:    services.NewJobProcService(cfg, jobService, c4IndexService, healthService, quotaService, c4Provider, appMetrics, tracer),
+		jobCleanupService: services.NewJobCleanupService(cfg, jobDao, idempotencyDao, batchDao, c4IndexDao, healthService, appMetrics),
+		stopped:           make(chan struct{}),
+	}
+	if err := a.initRouter(); err != nil {
+		return nil, err
+	}
+	a.mapUrls(authenticator.Middleware(), appControllers{
+		ping:     controllers.NewPingController(),
+		health:   controllers.NewHealthController(healthService),
+		config:   controllers.NewConfigController(cfg),
+		job:      controllers.NewJobController(jobService),
+		manifest: controllers.NewManifestController(manifestService),
+		batch:    controllers.NewBatchController(batchService),
+		c4Index:  controllers.NewC4IndexController(c4IndexService),
+		quota:    controllers.NewQuotaController(quotaService),
+	})
+	a.server = &http.Server{
+		Addr:      cfg.ListenAddr,
+		Handler:   a.router,
+		TLSConfig: tlsConfig,
+	}
+	logger.Debug("Done initializing application")
+	return &a, nil
+}
 
-func init() {
+func (a *App) initRouter() error {
 	logger.Debug("Initializing router")
-	gin.SetMode(config.GinMode())
-	gin.DefaultWriter = logger.GetLogger()
-	router = gin.New()
-	router.Use(gin.Logger())
-	router.Use(gin.Recovery())
+	gin.SetMode(a.cfg.GinMode)
+	a.router = gin.New()
+	// without trusted proxies, the client IP is the remote address and X-Forwarded-For is ignored
+	if err := a.router.SetTrustedProxies(config.SplitList(a.cfg.TrustedProxies)); err != nil {
+		logger.Error("Cannot set trusted proxies", err)
+		return err
+	}
+	a.router.Use(otelgin.Middleware(serviceName, otelgin.WithTracerProvider(a.tracerProvider), otelgin.WithPropagators(propagator)))
+	a.router.Use(request.AssignId)
+	a.router.Use(gin.LoggerWithConfig(gin.LoggerConfig{
+		Formatter: request.LogFormatter,
+		Output:    logger.GetLogger(),
+	}))
+	a.router.Use(a.metrics.GinMiddleware())
+	a.router.Use(gin.Recovery())
 	logger.Debug("Done initializing router")
+	return nil
 }
 
-func StartApp() {
-	logger.Info("Starting application")
-	mapUrls()
+// Handler returns the router, e.g. to serve the application from a test server
+func (a *App) Handler() http.Handler {
+	return a.router
+}
 
-	logger.Info("Starting job processor")
-	go services.JobProcService.Process()
+// StartWorkers starts the job processors and the job cleanup. They run until Stop is called.
+func (a *App) StartWorkers() {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.stopping {
+		return
+	}
+	var ctx context.Context
+	ctx, a.cancel = context.WithCancel(context.Background())
+	logger.Info(fmt.Sprintf("Starting %d job processor(s)", a.cfg.WorkerCount))
+	for i := 0; i < a.cfg.WorkerCount; i++ {
+		a.workers.Add(1)
+		go func(worker int) {
+			defer a.workers.Done()
+			a.jobProcService.Process(ctx, worker)
+		}(i)
+	}
 	logger.Info("Starting job cleanup")
-	go services.JobCleanupService.Cleanup()
+	a.workers.Add(1)
+	go func() {
+		defer a.workers.Done()
+		a.jobCleanupService.Cleanup(ctx)
+	}()
+}
 
-	if err := router.Run(config.ListenAddr); err != nil {
+// Start starts the workers and serves requests until Stop is called. It serves HTTPS if a certificate
+// is configured. After Stop has been called, Start returns only once Stop has finished.
+func (a *App) Start() error {
+	logger.Info("Starting application")
+	a.StartWorkers()
+	var err error
+	if a.tlsConfig != nil {
+		logger.Info(fmt.Sprintf("Serving HTTPS on %v", a.cfg.ListenAddr))
+		err = a.server.ListenAndServeTLS("", "")
+	} else {
+		err = a.server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Error("Error while starting router", err)
-		panic(err)
+		return err
 	}
-
+	<-a.stopped
 	logger.Info("Application ended")
+	return nil
+}
+
+// Stop stops serving requests, waits for the workers to finish their current job and flushes the recorded spans.
+// Calls after the first one return immediately.
+func (a *App) Stop(ctx context.Context) error {
+	var err error
+	a.stopOnce.Do(func() {
+		defer close(a.stopped)
+		err = a.server.Shutdown(ctx)
+		a.mu.Lock()
+		a.stopping = true
+		if a.cancel != nil {
+			a.cancel()
+		}
+		a.mu.Unlock()
+		a.workers.Wait()
+		if tracingErr := a.stopTracing(ctx); tracingErr != nil {
+			logger.Error("Error while flushing traces", tracingErr)
+			if err == nil {
+				err = tracingErr
+			}
+		}
+	})
+	return err
 }
@@ -0,0 +1,389 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/johannes-kuhfuss/c4svc/auth"
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/request"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestServer(t *testing.T, cfg *config.AppConfig) *httptest.Server {
+	cfg.GinMode = "test"
+	a, err := New(cfg)
+	assert.Nil(t, err)
+	server := httptest.NewServer(a.Handler())
+	t.Cleanup(server.Close)
+	return server
+}
+
+func postJob(t *testing.T, server *httptest.Server, body string, apiKey string) *http.Response {
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/job", strings.NewReader(body))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	if apiKey != "" {
+		req.Header.Set(auth.ApiKeyHeader, apiKey)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func getWithKey(t *testing.T, server *httptest.Server, path string, apiKey string) *http.Response {
+	req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+	assert.Nil(t, err)
+	req.Header.Set(auth.ApiKeyHeader, apiKey)
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+func TestInstancesAreIndependent(t *testing.T) {
+	coalescing := config.New()
+	coalescing.CoalesceJobs = true
+	server1 := newTestServer(t, coalescing)
+	server2 := newTestServer(t, config.New())
+	body := `{"type": "Create", "src_url": "https://server/media/file1.ext"}`
+
+	assert.EqualValues(t, http.StatusCreated, postJob(t, server1, body, "").StatusCode)
+	assert.EqualValues(t, http.StatusOK, postJob(t, server1, body, "").StatusCode)
+	assert.EqualValues(t, http.StatusCreated, postJob(t, server2, body, "").StatusCode)
+	assert.EqualValues(t, http.StatusCreated, postJob(t, server2, body, "").StatusCode)
+
+	resp, err := http.Get(server1.URL + "/jobs/")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	var jobs domain.Jobs
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&jobs))
+	assert.EqualValues(t, 1, len(jobs))
+}
+
+func TestInstancesUseOwnConfig(t *testing.T) {
+	cfg := config.New()
+	cfg.ListenAddr = ":9999"
+	server := newTestServer(t, cfg)
+	resp, err := http.Get(server.URL + "/config")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	var effective map[string]string
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&effective))
+	assert.EqualValues(t, ":9999", effective["server.listen_addr"])
+}
+
+func TestApiKeyRequired(t *testing.T) {
+	cfg := config.New()
+	cfg.ApiKeys = "alice:" + auth.HashKey("alice-key")
+	server := newTestServer(t, cfg)
+	body := `{"type": "Create", "src_url": "https://server/media/file1.ext", "created_by": "mallory"}`
+
+	assert.EqualValues(t, http.StatusUnauthorized, postJob(t, server, body, "").StatusCode)
+	assert.EqualValues(t, http.StatusUnauthorized, postJob(t, server, body, "wrong-key").StatusCode)
+	resp := postJob(t, server, body, "alice-key")
+	assert.EqualValues(t, http.StatusCreated, resp.StatusCode)
+	var job domain.Job
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.EqualValues(t, "key:alice", job.CreatedBy)
+
+	probe, err := http.Get(server.URL + "/health/live")
+	assert.Nil(t, err)
+	defer probe.Body.Close()
+	assert.NotEqualValues(t, http.StatusUnauthorized, probe.StatusCode)
+}
+
+func TestRolesEnforcedPerRoute(t *testing.T) {
+	cfg := config.New()
+	cfg.ApiKeys = "alice:" + auth.HashKey("alice-key") + ",victor:" + auth.HashKey("victor-key") + ":viewer"
+	server := newTestServer(t, cfg)
+	body := `{"type": "Create", "src_url": "https://server/media/file1.ext"}`
+
+	assert.EqualValues(t, http.StatusForbidden, postJob(t, server, body, "victor-key").StatusCode)
+	assert.EqualValues(t, http.StatusCreated, postJob(t, server, body, "alice-key").StatusCode)
+	assert.EqualValues(t, http.StatusForbidden, getWithKey(t, server, "/config", "alice-key").StatusCode)
+}
+
+func TestManifestWrittenByAdminsOnly(t *testing.T) {
+	cfg := config.New()
+	cfg.ApiKeys = "alice:" + auth.HashKey("alice-key") + ",root:" + auth.HashKey("root-key") + ":admin"
+	cfg.ManifestLocation = "file://" + t.TempDir()
+	server := newTestServer(t, cfg)
+	postManifest := func(apiKey string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/jobs/manifest", nil)
+		assert.Nil(t, err)
+		req.Header.Set(auth.ApiKeyHeader, apiKey)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	assert.EqualValues(t, http.StatusForbidden, postManifest("alice-key").StatusCode)
+	resp := postManifest("root-key")
+	assert.EqualValues(t, http.StatusCreated, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Location"), cfg.ManifestLocation+"/manifest-"))
+	assert.EqualValues(t, http.StatusOK, getWithKey(t, server, "/jobs/manifest", "alice-key").StatusCode)
+	assert.EqualValues(t, http.StatusBadRequest, getWithKey(t, server, "/jobs/manifest?write=true", "root-key").StatusCode)
+}
+
+func TestJobsVisibleToOwnerOnly(t *testing.T) {
+	cfg := config.New()
+	cfg.ApiKeys = "alice:" + auth.HashKey("alice-key") + ",bob:" + auth.HashKey("bob-key") + ",root:" + auth.HashKey("root-key") + ":admin"
+	server := newTestServer(t, cfg)
+	var job domain.Job
+	resp := postJob(t, server, `{"type": "Create", "src_url": "https://server/media/file1.ext"}`, "alice-key")
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&job))
+	postJob(t, server, `{"type": "Create", "src_url": "https://server/media/file2.ext"}`, "bob-key")
+
+	assert.EqualValues(t, http.StatusOK, getWithKey(t, server, "/job/"+job.Id, "alice-key").StatusCode)
+	assert.EqualValues(t, http.StatusNotFound, getWithKey(t, server, "/job/"+job.Id, "bob-key").StatusCode)
+	assert.EqualValues(t, http.StatusOK, getWithKey(t, server, "/job/"+job.Id, "root-key").StatusCode)
+
+	var jobs domain.Jobs
+	assert.Nil(t, json.NewDecoder(getWithKey(t, server, "/jobs/", "bob-key").Body).Decode(&jobs))
+	assert.EqualValues(t, 1, len(jobs))
+	assert.EqualValues(t, "key:bob", jobs[0].CreatedBy)
+	assert.Nil(t, json.NewDecoder(getWithKey(t, server, "/jobs/", "root-key").Body).Decode(&jobs))
+	assert.EqualValues(t, 2, len(jobs))
+}
+
+func TestJobCreationRateLimited(t *testing.T) {
+	cfg := config.New()
+	cfg.RateLimit = 1
+	cfg.RateBurst = 2
+	server := newTestServer(t, cfg)
+	body := `{"type": "Create", "src_url": "https://server/media/file1.ext"}`
+
+	assert.EqualValues(t, http.StatusCreated, postJob(t, server, body, "").StatusCode)
+	assert.EqualValues(t, http.StatusCreated, postJob(t, server, body, "").StatusCode)
+	resp := postJob(t, server, body, "")
+	assert.EqualValues(t, http.StatusTooManyRequests, resp.StatusCode)
+	assert.NotEmpty(t, resp.Header.Get("Retry-After"))
+
+	resp, err := http.Get(server.URL + "/quotas")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	var report domain.QuotaReport
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&report))
+	throttled := make(map[string]int64)
+	for _, usage := range report.Clients {
+		throttled[usage.Client] = usage.Throttled
+	}
+	assert.EqualValues(t, 1, throttled["ip:127.0.0.1"])
+}
+
+func TestRateLimitIgnoresForwardedForFromUntrustedClients(t *testing.T) {
+	cfg := config.New()
+	cfg.RateLimit = 1
+	cfg.RateBurst = 1
+	server := newTestServer(t, cfg)
+	post := func(forwardedFor string) int {
+		req, err := http.NewRequest(http.MethodPost, server.URL+"/job", strings.NewReader(`{"type": "Create", "src_url": "https://server/media/file1.ext"}`))
+		assert.Nil(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		resp, err := http.DefaultClient.Do(req)
+		assert.Nil(t, err)
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.EqualValues(t, http.StatusCreated, post("10.0.0.1"))
+	assert.EqualValues(t, http.StatusTooManyRequests, post("10.0.0.2"))
+
+	cfg = config.New()
+	cfg.RateLimit = 1
+	cfg.RateBurst = 1
+	cfg.TrustedProxies = "127.0.0.1"
+	server = newTestServer(t, cfg)
+	assert.EqualValues(t, http.StatusCreated, post("10.0.0.1"))
+	assert.EqualValues(t, http.StatusCreated, post("10.0.0.2"))
+	assert.EqualValues(t, http.StatusTooManyRequests, post("10.0.0.2"))
+}
+
+func TestJobCreationRejectedWhenQueueFull(t *testing.T) {
+	cfg := config.New()
+	cfg.QueueMaxJobs = 1
+	server := newTestServer(t, cfg)
+	body := `{"type": "Create", "src_url": "https://server/media/file1.ext"}`
+
+	assert.EqualValues(t, http.StatusCreated, postJob(t, server, body, "").StatusCode)
+	resp := postJob(t, server, body, "")
+	assert.EqualValues(t, http.StatusServiceUnavailable, resp.StatusCode)
+	assert.EqualValues(t, "30", resp.Header.Get("Retry-After"))
+
+	resp, err := http.Get(server.URL + "/metrics")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	metrics, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+	assert.Contains(t, string(metrics), "c4svc_queue_saturated 1")
+}
+
+func TestErrorsAreProblemDetails(t *testing.T) {
+	server := newTestServer(t, config.New())
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/job", strings.NewReader(`{"type": "Unknown", "digest_types": ["md4"]}`))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(request.IdHeader, "client-request-1")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.EqualValues(t, http.StatusBadRequest, resp.StatusCode)
+	assert.EqualValues(t, request.ProblemContentType, resp.Header.Get("Content-Type"))
+	assert.EqualValues(t, "client-request-1", resp.Header.Get(request.IdHeader))
+	var problem domain.Problem
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.EqualValues(t, domain.CodeInvalidJobType, problem.Code)
+	assert.EqualValues(t, "urn:c4svc:problem:invalid_job_type", problem.Type)
+	assert.EqualValues(t, "/api/v1/job", problem.Instance)
+	assert.EqualValues(t, "client-request-1", problem.RequestId)
+	assert.EqualValues(t, []domain.FieldError{
+		{Field: "type", Code: domain.CodeInvalidJobType, Message: "invalid job type"},
+		{Field: "src_url", Code: domain.CodeInvalidSourceUrl, Message: "invalid source Url"},
+		{Field: "digest_types[0]", Code: domain.CodeInvalidDigestType, Message: "invalid digest type md4"},
+	}, problem.Errors)
+
+	resp, err = http.Get(server.URL + "/api/v1/job/" + "3KuYck9AZXJJqw5v8ygYYzXY6Ad")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.EqualValues(t, http.StatusNotFound, resp.StatusCode)
+	problem = domain.Problem{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.EqualValues(t, domain.CodeJobNotFound, problem.Code)
+	assert.NotEmpty(t, problem.RequestId)
+	assert.EqualValues(t, resp.Header.Get(request.IdHeader), problem.RequestId)
+
+	resp = postJob(t, server, `{"type": 1}`, "")
+	problem = domain.Problem{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.EqualValues(t, domain.CodeInvalidJson, problem.Code)
+	assert.EqualValues(t, "type", problem.Errors[0].Field)
+
+	resp, err = http.Get(server.URL + "/api/v2/job")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.EqualValues(t, http.StatusNotFound, resp.StatusCode)
+	assert.EqualValues(t, request.ProblemContentType, resp.Header.Get("Content-Type"))
+}
+
+func TestJobsKeepTheIdOfTheCreatingRequest(t *testing.T) {
+	server := newTestServer(t, config.New())
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/job", strings.NewReader(`{"type": "Create", "src_url": "https://server/media/file1.ext"}`))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(request.IdHeader, "create-1")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.EqualValues(t, "create-1", resp.Header.Get(request.IdHeader))
+	var job domain.Job
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.EqualValues(t, "create-1", job.RequestId)
+
+	req, err = http.NewRequest(http.MethodPatch, server.URL+"/api/v1/job/"+job.Id, strings.NewReader(`{"name": "renamed"}`))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.NotEqualValues(t, "create-1", resp.Header.Get(request.IdHeader))
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.EqualValues(t, "create-1", job.RequestId)
+
+	req, err = http.NewRequest(http.MethodPost, server.URL+"/api/v1/jobs/batch", strings.NewReader(`[{"type": "Create", "src_url": "https://server/media/file2.ext"}]`))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(request.IdHeader, "batch-1")
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	var batch domain.BatchResult
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&batch))
+	assert.EqualValues(t, "batch-1", batch.Items[0].Job.RequestId)
+}
+
+func TestBatchWithoutCreatedJobsIsUnprocessable(t *testing.T) {
+	server := newTestServer(t, config.New())
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/jobs/batch", strings.NewReader(`[{"type": "Create", "src_url": ""}, {"type": "Invalid", "src_url": "https://server/media/file1.ext"}]`))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.EqualValues(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.EqualValues(t, request.ProblemContentType, resp.Header.Get("Content-Type"))
+	var problem domain.Problem
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&problem))
+	assert.EqualValues(t, domain.CodeBatchFailed, problem.Code)
+	assert.EqualValues(t, 2, len(problem.Items))
+	assert.EqualValues(t, domain.CodeInvalidSourceUrl, problem.Items[0].Error.Code)
+	assert.EqualValues(t, domain.CodeInvalidJobType, problem.Items[1].Error.Code)
+
+	req, err = http.NewRequest(http.MethodPost, server.URL+"/api/v1/jobs/batch", strings.NewReader(`[{"type": "Create", "src_url": ""}, {"type": "Create", "src_url": "https://server/media/file1.ext"}]`))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.EqualValues(t, http.StatusCreated, resp.StatusCode)
+}
+
+func TestPartialUpdateSwitchesFlagsOff(t *testing.T) {
+	server := newTestServer(t, config.New())
+	resp := postJob(t, server, `{"type": "Create", "src_url": "https://server/media/file1.ext", "write_metadata": true, "trust_metadata": true}`, "")
+	var job domain.Job
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.True(t, job.TrustMetadata)
+
+	req, err := http.NewRequest(http.MethodPatch, server.URL+"/api/v1/job/"+job.Id, strings.NewReader(`{"trust_metadata": false}`))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err = http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.False(t, job.TrustMetadata)
+	assert.True(t, job.WriteMetadata)
+}
+
+func TestJobsContinueTheTraceOfTheCreatingRequest(t *testing.T) {
+	server := newTestServer(t, config.New())
+	req, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/job", strings.NewReader(`{"type": "Create", "src_url": "https://server/media/file1.ext"}`))
+	assert.Nil(t, err)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	var job domain.Job
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.True(t, strings.HasPrefix(job.TraceContext["traceparent"], "00-4bf92f3577b34da6a3ce929d0e0e4736-"))
+}
+
+func TestStartReturnsAfterStopFinished(t *testing.T) {
+	cfg := config.New()
+	cfg.ListenAddr = "127.0.0.1:0"
+	a, err := New(cfg)
+	assert.Nil(t, err)
+	started := make(chan error)
+	go func() {
+		started <- a.Start()
+	}()
+	go a.Stop(context.Background())
+	assert.Nil(t, <-started)
+	select {
+	case <-a.stopped:
+	default:
+		assert.Fail(t, "Start returned before Stop finished")
+	}
+	assert.Nil(t, a.Stop(context.Background()))
+}
@@ -0,0 +1,18 @@
+package app
+
+import (
+	_ "embed"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	//go:embed openapi.yaml
+	openApiSpec []byte
+)
+
+// serveOpenApi serves the OpenAPI document describing the API
+func serveOpenApi(c *gin.Context) {
+	c.Data(http.StatusOK, "application/yaml", openApiSpec)
+}
@@ -0,0 +1,157 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	ginParam = regexp.MustCompile(`:([a-z0-9_]+)`)
+)
+
+func loadOpenApi(t *testing.T) (*openapi3.T, routers.Router) {
+	doc, err := openapi3.NewLoader().LoadFromData(openApiSpec)
+	assert.Nil(t, err)
+	assert.Nil(t, doc.Validate(context.Background()))
+	router, err := legacy.NewRouter(doc)
+	assert.Nil(t, err)
+	return doc, router
+}
+
+// callAndValidate sends the request to the server and validates the response against the OpenAPI document
+func callAndValidate(t *testing.T, server *httptest.Server, router routers.Router, method string, path string, body string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, server.URL+path, strings.NewReader(body))
+	assert.Nil(t, err)
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	content, err := io.ReadAll(resp.Body)
+	assert.Nil(t, err)
+
+	route, pathParams, err := router.FindRoute(req)
+	if !assert.Nil(t, err, "%v %v is not described", method, path) {
+		return resp, content
+	}
+	input := openapi3filter.ResponseValidationInput{
+		RequestValidationInput: &openapi3filter.RequestValidationInput{
+			Request:    req,
+			PathParams: pathParams,
+			Route:      route,
+		},
+		Status: resp.StatusCode,
+		Header: resp.Header,
+		Body:   io.NopCloser(bytes.NewReader(content)),
+	}
+	assert.Nil(t, openapi3filter.ValidateResponse(context.Background(), &input), "%v %v: %s", method, path, content)
+	return resp, content
+}
+
+func TestOpenApiDescribesAllRoutes(t *testing.T) {
+	doc, _ := loadOpenApi(t)
+	cfg := config.New()
+	cfg.GinMode = "test"
+	a, err := New(cfg)
+	assert.Nil(t, err)
+	routes := make(map[string]bool)
+	for _, route := range a.router.Routes() {
+		routes[route.Method+" "+route.Path] = true
+	}
+	for _, route := range a.router.Routes() {
+		if routes[route.Method+" "+apiV1+route.Path] {
+			// deprecated alias of a versioned route
+			continue
+		}
+		path := ginParam.ReplaceAllString(route.Path, "{$1}")
+		item := doc.Paths.Find(path)
+		if assert.NotNil(t, item, "%v is not described", path) {
+			assert.NotNil(t, item.GetOperation(route.Method), "%v %v is not described", route.Method, path)
+		}
+	}
+}
+
+func TestResponsesMatchOpenApi(t *testing.T) {
+	_, router := loadOpenApi(t)
+	server := newTestServer(t, config.New())
+
+	resp, content := callAndValidate(t, server, router, http.MethodPost, apiV1+"/job", `{"type": "Create", "src_url": "https://server/media/file1.ext", "digest_types": ["md5"]}`)
+	assert.EqualValues(t, http.StatusCreated, resp.StatusCode)
+	var job domain.Job
+	assert.Nil(t, json.Unmarshal(content, &job))
+	jobPath := apiV1 + "/job/" + job.Id
+
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, jobPath, "")
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodPatch, jobPath, `{"name": "renamed"}`)
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodPut, jobPath, `{"type": "Create", "src_url": "https://server/media/file2.ext"}`)
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodPost, jobPath+"/retry", "")
+	assert.EqualValues(t, http.StatusConflict, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, apiV1+"/job/invalid", "")
+	assert.EqualValues(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodPost, apiV1+"/job", `{"type": "Unknown", "src_url": "https://server/media/file1.ext"}`)
+	assert.EqualValues(t, http.StatusBadRequest, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, apiV1+"/jobs/", "")
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, apiV1+"/jobs/manifest?ids="+job.Id, "")
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+
+	resp, content = callAndValidate(t, server, router, http.MethodPost, apiV1+"/jobs/batch", `[{"type": "Create", "src_url": "https://server/media/file3.ext"}, {"type": "Unknown"}]`)
+	assert.EqualValues(t, http.StatusCreated, resp.StatusCode)
+	var batch struct {
+		Id string `json:"id"`
+	}
+	assert.Nil(t, json.Unmarshal(content, &batch))
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, apiV1+"/batch/"+batch.Id, "")
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, apiV1+"/c4/c42FTpMRKrEEL6sgwRVRfxbzYDsYZe4VgsNVC7D6Jkqz8ABjsSAybKLYwPLGSJexGkJ9qt3aR8sMAjZ8fhKd7GfQsB", "")
+	assert.EqualValues(t, http.StatusNotFound, resp.StatusCode)
+
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, apiV1+"/config", "")
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, apiV1+"/quotas", "")
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, apiV1+"/openapi.yaml", "")
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, "/ping", "")
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, "/health/ready", "")
+	assert.EqualValues(t, http.StatusServiceUnavailable, resp.StatusCode)
+	resp, _ = callAndValidate(t, server, router, http.MethodGet, "/metrics", "")
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+
+	resp, _ = callAndValidate(t, server, router, http.MethodDelete, jobPath, "")
+	assert.EqualValues(t, http.StatusNoContent, resp.StatusCode)
+}
+
+func TestUnversionedRoutesAreDeprecatedAliases(t *testing.T) {
+	server := newTestServer(t, config.New())
+	resp := postJob(t, server, `{"type": "Create", "src_url": "https://server/media/file1.ext"}`, "")
+	assert.EqualValues(t, http.StatusCreated, resp.StatusCode)
+	assert.EqualValues(t, "true", resp.Header.Get("Deprecation"))
+	assert.EqualValues(t, `</api/v1/job>; rel="successor-version"`, resp.Header.Get("Link"))
+
+	resp, err := http.Get(server.URL + apiV1 + "/jobs/")
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.EqualValues(t, http.StatusOK, resp.StatusCode)
+	assert.EqualValues(t, "", resp.Header.Get("Deprecation"))
+}
@@ -0,0 +1,120 @@
+package app
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+const (
+	// certCheckInterval is the minimum time between checks whether the certificate files changed
+	certCheckInterval = time.Second * 10
+)
+
+// certReloader serves the configured certificate and reloads it when the files are replaced,
+// so rotated certificates are picked up without a restart
+type certReloader struct {
+	certFile string
+	keyFile  string
+	mu       sync.Mutex
+	cert     *tls.Certificate
+	modTime  time.Time
+	checked  time.Time
+}
+
+func newCertReloader(certFile string, keyFile string) (*certReloader, error) {
+	r := certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	modTime, err := r.filesModTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= certCheckInterval {
+		r.checked = time.Now()
+		modTime, err := r.filesModTime()
+		if err == nil && !modTime.Equal(r.modTime) {
+			if err = r.load(modTime); err == nil {
+				logger.Info(fmt.Sprintf("Reloaded TLS certificate %v", r.certFile))
+			}
+		}
+		if err != nil {
+			logger.Error("Cannot reload TLS certificate, keeping the current one", err)
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("cannot load TLS certificate: %w", err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	r.checked = time.Now()
+	return nil
+}
+
+// filesModTime returns the later modification time of the certificate and the key file
+func (r *certReloader) filesModTime() (time.Time, error) {
+	var latest time.Time
+	for _, file := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(file)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("cannot access TLS certificate: %w", err)
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// newTlsConfig returns the server TLS configuration, or nil to serve plain HTTP.
+// With client CAs configured, client certificates are verified and identify the caller.
+func newTlsConfig(cfg *config.AppConfig) (*tls.Config, error) {
+	if cfg.TlsCertFile == "" {
+		return nil, nil
+	}
+	reloader, err := newCertReloader(cfg.TlsCertFile, cfg.TlsKeyFile)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: reloader.GetCertificate,
+	}
+	if cfg.TlsClientCaFile != "" {
+		pem, err := os.ReadFile(cfg.TlsClientCaFile)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("client CA file %v contains no certificates", cfg.TlsClientCaFile)
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+		if cfg.TlsRequireClient {
+			tlsConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+	return &tlsConfig, nil
+}
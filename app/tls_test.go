@@ -0,0 +1,152 @@
+package app
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert creates a certificate for name, signed by parent or self-signed if parent is nil
+func newTestCert(t *testing.T, name string, parent *testCert, isCa bool) *testCert {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	assert.Nil(t, err)
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		BasicConstraintsValid: true,
+		IsCA:                  isCa,
+	}
+	signer, signerKey := &template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, signer, &key.PublicKey, signerKey)
+	assert.Nil(t, err)
+	cert, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+	return &testCert{cert: cert, key: key}
+}
+
+// write stores the certificate and key as PEM files and returns their paths
+func (tc *testCert) write(t *testing.T, dir string, name string) (string, string) {
+	certFile := filepath.Join(dir, name+".pem")
+	keyFile := filepath.Join(dir, name+"-key.pem")
+	assert.Nil(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: tc.cert.Raw}), 0644))
+	keyDer, err := x509.MarshalECPrivateKey(tc.key)
+	assert.Nil(t, err)
+	assert.Nil(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer}), 0600))
+	return certFile, keyFile
+}
+
+func (tc *testCert) tlsCertificate() tls.Certificate {
+	return tls.Certificate{Certificate: [][]byte{tc.cert.Raw}, PrivateKey: tc.key, Leaf: tc.cert}
+}
+
+func TestMutualTlsIdentifiesCaller(t *testing.T) {
+	dir := t.TempDir()
+	ca := newTestCert(t, "test ca", nil, true)
+	caFile, _ := ca.write(t, dir, "ca")
+	cfg := config.New()
+	cfg.GinMode = "test"
+	cfg.TlsCertFile, cfg.TlsKeyFile = newTestCert(t, "server", ca, false).write(t, dir, "server")
+	cfg.TlsClientCaFile = caFile
+	a, err := New(cfg)
+	assert.Nil(t, err)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	server := &http.Server{Handler: a.Handler(), TLSConfig: a.tlsConfig}
+	go server.ServeTLS(listener, "", "")
+	t.Cleanup(func() { server.Close() })
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: certs}}}
+	}
+	url := "https://" + listener.Addr().String() + "/job"
+	body := `{"type": "Create", "src_url": "https://server/media/file1.ext"}`
+
+	resp, err := newClient(newTestCert(t, "alice", ca, false).tlsCertificate()).Post(url, "application/json", strings.NewReader(body))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.EqualValues(t, http.StatusCreated, resp.StatusCode)
+	var job domain.Job
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.EqualValues(t, "cert:alice", job.CreatedBy)
+
+	resp, err = newClient().Post(url, "application/json", strings.NewReader(body))
+	assert.Nil(t, err)
+	defer resp.Body.Close()
+	assert.EqualValues(t, http.StatusUnauthorized, resp.StatusCode)
+
+	untrusted := newTestCert(t, "mallory", nil, false).tlsCertificate()
+	client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+		RootCAs: roots,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &untrusted, nil
+		},
+	}}}
+	_, err = client.Post(url, "application/json", strings.NewReader(body))
+	assert.NotNil(t, err)
+}
+
+func TestCertReloaderPicksUpRotatedCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := newTestCert(t, "first", nil, false).write(t, dir, "server")
+	reloader, err := newCertReloader(certFile, keyFile)
+	assert.Nil(t, err)
+	cert, err := reloader.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "first", parseLeaf(t, cert).Subject.CommonName)
+
+	newTestCert(t, "second", nil, false).write(t, dir, "server")
+	later := time.Now().Add(time.Minute)
+	assert.Nil(t, os.Chtimes(certFile, later, later))
+	cert, _ = reloader.GetCertificate(nil)
+	assert.EqualValues(t, "first", parseLeaf(t, cert).Subject.CommonName)
+	reloader.checked = time.Now().Add(-certCheckInterval)
+	cert, _ = reloader.GetCertificate(nil)
+	assert.EqualValues(t, "second", parseLeaf(t, cert).Subject.CommonName)
+
+	assert.Nil(t, os.WriteFile(keyFile, []byte("broken"), 0600))
+	assert.Nil(t, os.Chtimes(keyFile, later.Add(time.Minute), later.Add(time.Minute)))
+	reloader.checked = time.Now().Add(-certCheckInterval)
+	cert, err = reloader.GetCertificate(nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "second", parseLeaf(t, cert).Subject.CommonName)
+}
+
+func parseLeaf(t *testing.T, cert *tls.Certificate) *x509.Certificate {
+	leaf, err := x509.ParseCertificate(cert.Certificate[0])
+	assert.Nil(t, err)
+	return leaf
+}
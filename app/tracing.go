@@ -0,0 +1,48 @@
+package app
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.7.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	serviceName = "c4svc"
+)
+
+var (
+	// propagator reads the trace context and baggage sent by clients
+	propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+)
+
+// newTracerProvider returns a tracer provider exporting spans to the configured OTLP collector, or one
+// that records nothing if no collector is configured. The returned function flushes and stops the exporter.
+func newTracerProvider(cfg *config.AppConfig) (trace.TracerProvider, func(context.Context) error, error) {
+	if cfg.TracingEndpoint == "" {
+		return trace.NewNoopTracerProvider(), func(context.Context) error { return nil }, nil
+	}
+	options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(cfg.TracingEndpoint)}
+	if cfg.TracingInsecure {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		logger.Error("Cannot create trace exporter", err)
+		return nil, nil, err
+	}
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, semconv.ServiceNameKey.String(serviceName))),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(float64(cfg.TracingSamplePct)/100))),
+	)
+	logger.Info(fmt.Sprintf("Exporting traces to %v", cfg.TracingEndpoint))
+	return provider, provider.Shutdown, nil
+}
@@ -1,20 +1,70 @@
 package app
 
 import (
-	"github.com/johannes-kuhfuss/c4svc/controllers"
+	"fmt"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johannes-kuhfuss/c4svc/auth"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/request"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
 	"github.com/johannes-kuhfuss/services_utils/logger"
 )
 
-func mapUrls() {
+const (
+	apiV1 = "/api/v1"
+)
+
+// mapUrls registers the routes. Probes, metrics and the OpenAPI document stay open, everything else requires
+// authentication and a role. The API is served under /api/v1; the unversioned paths remain as deprecated aliases.
+func (a *App) mapUrls(authenticate gin.HandlerFunc, c appControllers) {
 	logger.Debug("Mapping URLs")
 
-	router.GET("/ping", controllers.PingController.Pong)
-	router.POST("/job", controllers.JobController.Create)
-	router.GET("/job/:job_id", controllers.JobController.Get)
-	router.DELETE("/job/:job_id", controllers.JobController.Delete)
-	router.PUT("/job/:job_id", controllers.JobController.Update)
-	router.PATCH("/job/:job_id", controllers.JobController.UpdatePart)
-	router.GET("/jobs/", controllers.JobController.GetAll)
+	a.router.GET("/ping", c.ping.Pong)
+	a.router.GET("/health/live", c.health.Live)
+	a.router.GET("/health/ready", c.health.Ready)
+	a.router.GET("/metrics", gin.WrapH(a.metrics.Handler()))
+	a.router.GET(apiV1+"/openapi.yaml", serveOpenApi)
+
+	mapApiUrls(a.router.Group(apiV1, authenticate), c)
+	mapApiUrls(a.router.Group("/", deprecated, authenticate), c)
+	a.router.NoRoute(noRoute)
 
 	logger.Debug("Done mapping URLs")
 }
+
+// mapApiUrls registers the API routes: submitters create and change jobs, viewers read them, and only
+// admins see the configuration and quotas and write manifests. Which jobs a caller may see and change is decided by the
+// job service. Job creation is rate limited.
+func mapApiUrls(api *gin.RouterGroup, c appControllers) {
+	submitter := auth.RequireRole(domain.RoleSubmitter)
+	viewer := auth.RequireRole(domain.RoleViewer, domain.RoleSubmitter)
+	admin := auth.RequireRole(domain.RoleAdmin)
+
+	api.GET("/config", admin, c.config.Get)
+	api.GET("/quotas", admin, c.quota.Get)
+	api.POST("/job", submitter, c.quota.Limit, c.job.Create)
+	api.GET("/job/:job_id", viewer, c.job.Get)
+	api.DELETE("/job/:job_id", submitter, c.job.Delete)
+	api.PUT("/job/:job_id", submitter, c.job.Update)
+	api.PATCH("/job/:job_id", submitter, c.job.UpdatePart)
+	api.POST("/job/:job_id/retry", submitter, c.job.Retry)
+	api.GET("/jobs/", viewer, c.job.GetAll)
+	api.GET("/jobs/manifest", viewer, c.manifest.Get)
+	api.POST("/jobs/manifest", admin, c.manifest.Write)
+	api.POST("/jobs/batch", submitter, c.quota.Limit, c.batch.Create)
+	api.GET("/batch/:batch_id", viewer, c.batch.Get)
+	api.GET("/c4/:c4_id", viewer, c.c4Index.Get)
+}
+
+// noRoute reports unknown paths as problem details like every other error
+func noRoute(c *gin.Context) {
+	request.Fail(c, api_error.NewNotFoundError(fmt.Sprintf("no route for %v %v", c.Request.Method, c.Request.URL.Path)))
+}
+
+// deprecated marks responses of the unversioned aliases and links to the route under /api/v1
+func deprecated(c *gin.Context) {
+	c.Header("Deprecation", "true")
+	c.Header("Link", "<"+apiV1+c.Request.URL.Path+">; rel=\"successor-version\"")
+	c.Next()
+}
@@ -0,0 +1,31 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+)
+
+// HashKey returns the hex SHA-256 hash of an API key, as configured in auth.api_keys
+func HashKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// lookupKey compares the hash of key against every configured hash in constant time
+func lookupKey(hashes map[string]config.ApiKey, key string) (config.ApiKey, bool) {
+	if key == "" {
+		return config.ApiKey{}, false
+	}
+	keyHash := []byte(HashKey(key))
+	var found config.ApiKey
+	ok := false
+	for hash, apiKey := range hashes {
+		if subtle.ConstantTimeCompare(keyHash, []byte(hash)) == 1 {
+			found, ok = apiKey, true
+		}
+	}
+	return found, ok
+}
@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"crypto/x509"
+	"fmt"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/request"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+const (
+	ApiKeyHeader = "X-API-Key"
+	bearerPrefix = "Bearer "
+	callerKey    = "caller"
+	// caller names are prefixed with the kind of credential, so that an API key, a certificate
+	// and a token of the same name are different callers
+	apiKeyCallerPrefix = "key:"
+	certCallerPrefix   = "cert:"
+	jwtCallerPrefix    = "jwt:"
+)
+
+var (
+	// defaultApiKeyRoles are the roles of API keys and client certificates configured without roles
+	defaultApiKeyRoles = []string{domain.RoleSubmitter, domain.RoleViewer}
+)
+
+// Authenticator identifies callers by API key (X-API-Key header), bearer token (Authorization header)
+// or verified TLS client certificate
+type Authenticator struct {
+	apiKeys     map[string]config.ApiKey
+	tokens      *tokenValidator
+	clientCerts bool
+	certRoles   map[string][]string
+}
+
+func New(cfg *config.AppConfig) (*Authenticator, error) {
+	apiKeys, err := cfg.ApiKeyHashes()
+	if err != nil {
+		return nil, err
+	}
+	for hash, apiKey := range apiKeys {
+		if len(apiKey.Roles) == 0 {
+			apiKey.Roles = defaultApiKeyRoles
+			apiKeys[hash] = apiKey
+		}
+		for _, role := range apiKey.Roles {
+			if !domain.IsRole(role) {
+				return nil, fmt.Errorf("auth.api_keys role %q of %v must be one of %v", role, apiKey.Name, strings.Join(domain.Roles, ", "))
+			}
+		}
+	}
+	subjectRoles, err := cfg.ClientCertRoleMapping()
+	if err != nil {
+		return nil, err
+	}
+	certRoles := make(map[string][]string, len(subjectRoles))
+	for subject, roles := range subjectRoles {
+		for _, role := range roles {
+			if !domain.IsRole(role) {
+				return nil, fmt.Errorf("auth.client_cert_roles role %q of %v must be one of %v", role, subject, strings.Join(domain.Roles, ", "))
+			}
+		}
+		certRoles[certCallerPrefix+subject] = roles
+	}
+	a := Authenticator{
+		apiKeys:     apiKeys,
+		clientCerts: cfg.TlsClientCaFile != "",
+		certRoles:   certRoles,
+	}
+	if cfg.JwksUrl != "" {
+		roleMapping, err := cfg.JwtRoleMapping()
+		if err != nil {
+			return nil, err
+		}
+		keys, err := newKeySet(cfg.JwksUrl, cfg.JwksRefreshWait)
+		if err != nil {
+			return nil, err
+		}
+		a.tokens = &tokenValidator{
+			keys:        keys,
+			issuer:      cfg.JwtIssuer,
+			audience:    cfg.JwtAudience,
+			nameClaim:   cfg.JwtNameClaim,
+			rolesClaim:  cfg.JwtRolesClaim,
+			roleMapping: roleMapping,
+			parser:      jwt.NewParser(jwt.WithValidMethods(signingMethods)),
+		}
+	}
+	return &a, nil
+}
+
+// Enabled reports whether API keys, bearer tokens or client certificates are configured
+func (a *Authenticator) Enabled() bool {
+	return len(a.apiKeys) > 0 || a.tokens != nil || a.clientCerts
+}
+
+// Middleware rejects requests without valid credentials and attaches the caller to the context.
+// With authentication disabled, every request gets an anonymous admin caller.
+func (a *Authenticator) Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !a.Enabled() {
+			c.Set(callerKey, domain.Caller{Roles: []string{domain.RoleAdmin}})
+			c.Next()
+			return
+		}
+		caller, err := a.authenticate(c)
+		if err != nil {
+			logger.Info(fmt.Sprintf("Rejected request: %v", err.Message()), request.LogField(c))
+			request.Fail(c, err)
+			return
+		}
+		c.Set(callerKey, *caller)
+		c.Next()
+	}
+}
+
+func (a *Authenticator) authenticate(c *gin.Context) (*domain.Caller, api_error.ApiErr) {
+	if header := c.GetHeader("Authorization"); a.tokens != nil && strings.HasPrefix(header, bearerPrefix) {
+		caller, err := a.tokens.validate(strings.TrimSpace(strings.TrimPrefix(header, bearerPrefix)))
+		if err != nil {
+			logger.Debug(fmt.Sprintf("Invalid bearer token: %v", err), request.LogField(c))
+			return nil, domain.WithCode(domain.CodeInvalidCredentials, api_error.NewUnauthenticatedError("invalid bearer token"))
+		}
+		return &caller, nil
+	}
+	if key := strings.TrimSpace(c.GetHeader(ApiKeyHeader)); key != "" && len(a.apiKeys) > 0 {
+		apiKey, ok := lookupKey(a.apiKeys, key)
+		if !ok {
+			return nil, domain.WithCode(domain.CodeInvalidCredentials, api_error.NewUnauthenticatedError("invalid API key"))
+		}
+		return &domain.Caller{Name: apiKeyCallerPrefix + apiKey.Name, Roles: apiKey.Roles}, nil
+	}
+	if tls := c.Request.TLS; a.clientCerts && tls != nil && len(tls.VerifiedChains) > 0 && len(tls.VerifiedChains[0]) > 0 {
+		caller := a.certCaller(tls.VerifiedChains[0][0])
+		return &caller, nil
+	}
+	return nil, domain.WithCode(domain.CodeMissingCredentials, api_error.NewUnauthenticatedError("missing credentials"))
+}
+
+// certCaller identifies the caller by the common name of the certificate, or the full subject if it has none.
+// The TLS handshake already verified the certificate against the client CAs.
+func (a *Authenticator) certCaller(cert *x509.Certificate) domain.Caller {
+	name := cert.Subject.CommonName
+	if name == "" {
+		name = cert.Subject.String()
+	}
+	name = certCallerPrefix + name
+	roles, ok := a.certRoles[name]
+	if !ok {
+		roles = defaultApiKeyRoles
+	}
+	return domain.Caller{Name: name, Roles: roles}
+}
+
+// RequireRole rejects callers that have none of the roles with 403
+func RequireRole(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !GetCaller(c).HasRole(roles...) {
+			apiErr := domain.WithCode(domain.CodeMissingRole, api_error.NewUnauthorizedError(fmt.Sprintf("requires role %v", strings.Join(roles, " or "))))
+			request.Fail(c, apiErr)
+			return
+		}
+		c.Next()
+	}
+}
+
+// GetCaller returns the caller attached to the context by the middleware
+func GetCaller(c *gin.Context) domain.Caller {
+	if caller, ok := c.Get(callerKey); ok {
+		return caller.(domain.Caller)
+	}
+	return domain.Caller{}
+}
@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testKid = "test-key"
+)
+
+func writeJwks(t *testing.T, kid string, key *rsa.PublicKey) string {
+	set := jwkSet{Keys: []jwk{{
+		Kid: kid,
+		Kty: "RSA",
+		Use: "sig",
+		N:   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+		E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+	}}}
+	content, err := json.Marshal(set)
+	assert.Nil(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	assert.Nil(t, os.WriteFile(path, content, 0644))
+	return path
+}
+
+func signToken(t *testing.T, key *rsa.PrivateKey, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = testKid
+	signed, err := token.SignedString(key)
+	assert.Nil(t, err)
+	return signed
+}
+
+func newTestAuthenticator(t *testing.T, cfg *config.AppConfig) *Authenticator {
+	a, err := New(cfg)
+	assert.Nil(t, err)
+	return a
+}
+
+func newTestRouter(a *Authenticator, roles ...string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(a.Middleware())
+	router.GET("/caller", RequireRole(roles...), func(c *gin.Context) {
+		c.JSON(http.StatusOK, GetCaller(c))
+	})
+	return router
+}
+
+func call(router *gin.Engine, header string, value string) (*httptest.ResponseRecorder, domain.Caller) {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/caller", nil)
+	if value != "" {
+		req.Header.Set(header, value)
+	}
+	router.ServeHTTP(w, req)
+	var caller domain.Caller
+	json.Unmarshal(w.Body.Bytes(), &caller)
+	return w, caller
+}
+
+func TestApiKeyValid(t *testing.T) {
+	cfg := config.New()
+	cfg.ApiKeys = "alice:" + HashKey("alice-key") + ",bob:" + HashKey("bob-key") + ":admin"
+	router := newTestRouter(newTestAuthenticator(t, cfg), domain.RoleViewer)
+	w, caller := call(router, ApiKeyHeader, "alice-key")
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, domain.Caller{Name: "key:alice", Roles: defaultApiKeyRoles}, caller)
+	_, caller = call(router, ApiKeyHeader, "bob-key")
+	assert.EqualValues(t, []string{domain.RoleAdmin}, caller.Roles)
+}
+
+func TestApiKeyInvalid(t *testing.T) {
+	cfg := config.New()
+	cfg.ApiKeys = "alice:" + HashKey("alice-key")
+	router := newTestRouter(newTestAuthenticator(t, cfg))
+	w, _ := call(router, ApiKeyHeader, "")
+	assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+	w, _ = call(router, ApiKeyHeader, "bob-key")
+	assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+	w, _ = call(router, ApiKeyHeader, HashKey("alice-key"))
+	assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestApiKeyUnknownRole(t *testing.T) {
+	cfg := config.New()
+	cfg.ApiKeys = "alice:" + HashKey("alice-key") + ":superuser"
+	_, err := New(cfg)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, `auth.api_keys role "superuser" of alice must be one of submitter, viewer, admin`, err.Error())
+}
+
+func TestRequireRoleForbidden(t *testing.T) {
+	cfg := config.New()
+	cfg.ApiKeys = "alice:" + HashKey("alice-key") + ":viewer"
+	router := newTestRouter(newTestAuthenticator(t, cfg), domain.RoleAdmin)
+	w, _ := call(router, ApiKeyHeader, "alice-key")
+	assert.EqualValues(t, http.StatusForbidden, w.Code)
+}
+
+func TestAuthenticationDisabled(t *testing.T) {
+	router := newTestRouter(newTestAuthenticator(t, config.New()), domain.RoleAdmin)
+	w, caller := call(router, ApiKeyHeader, "")
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, domain.Caller{Roles: []string{domain.RoleAdmin}}, caller)
+}
+
+func TestClientCertificate(t *testing.T) {
+	cfg := config.New()
+	cfg.TlsClientCaFile = "ca.pem"
+	cfg.ClientCertRoles = "{carol: [admin]}"
+	router := newTestRouter(newTestAuthenticator(t, cfg), domain.RoleViewer)
+	for name, roles := range map[string][]string{"alice": defaultApiKeyRoles, "carol": {domain.RoleAdmin}} {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/caller", nil)
+		req.TLS = &tls.ConnectionState{
+			VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: name}}}},
+		}
+		router.ServeHTTP(w, req)
+		var caller domain.Caller
+		json.Unmarshal(w.Body.Bytes(), &caller)
+		assert.EqualValues(t, http.StatusOK, w.Code)
+		assert.EqualValues(t, domain.Caller{Name: "cert:" + name, Roles: roles}, caller)
+	}
+	w, _ := call(router, ApiKeyHeader, "")
+	assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestBearerToken(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	cfg := config.New()
+	cfg.JwksUrl = "file://" + writeJwks(t, testKid, &key.PublicKey)
+	cfg.JwtIssuer = "https://idp.example.com"
+	cfg.JwtRoleMap = "c4-users:submitter+viewer,c4-admins:admin"
+	router := newTestRouter(newTestAuthenticator(t, cfg), domain.RoleSubmitter)
+	claims := jwt.MapClaims{
+		"sub":   "carol",
+		"iss":   "https://idp.example.com",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"roles": []string{"c4-users", "other"},
+	}
+
+	w, caller := call(router, "Authorization", "Bearer "+signToken(t, key, claims))
+	assert.EqualValues(t, http.StatusOK, w.Code)
+	assert.EqualValues(t, domain.Caller{Name: "jwt:carol", Roles: []string{domain.RoleSubmitter, domain.RoleViewer}}, caller)
+
+	claims["roles"] = "other"
+	w, _ = call(router, "Authorization", "Bearer "+signToken(t, key, claims))
+	assert.EqualValues(t, http.StatusForbidden, w.Code)
+
+	claims["exp"] = time.Now().Add(-time.Minute).Unix()
+	w, _ = call(router, "Authorization", "Bearer "+signToken(t, key, claims))
+	assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+
+	claims["exp"] = time.Now().Add(time.Hour).Unix()
+	claims["iss"] = "https://other.example.com"
+	w, _ = call(router, "Authorization", "Bearer "+signToken(t, key, claims))
+	assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+}
+
+func TestBearerTokenWrongKey(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	cfg := config.New()
+	cfg.JwksUrl = "file://" + writeJwks(t, testKid, &key.PublicKey)
+	router := newTestRouter(newTestAuthenticator(t, cfg))
+	claims := jwt.MapClaims{"sub": "carol", "exp": time.Now().Add(time.Hour).Unix()}
+	w, _ := call(router, "Authorization", "Bearer "+signToken(t, otherKey, claims))
+	assert.EqualValues(t, http.StatusUnauthorized, w.Code)
+}
@@ -0,0 +1,198 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/url"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	maxJwksSize = 1024 * 1024
+)
+
+type jwk struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+// keySet holds the public keys of a JWKS. Keys are reloaded when a token names an unknown
+// key id, at most once per refreshWait, so rotated signing keys are picked up.
+type keySet struct {
+	url         *url.URL
+	refreshWait time.Duration
+	client      *http.Client
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	loadedAt    time.Time
+}
+
+func newKeySet(jwksUrl string, refreshWait time.Duration) (*keySet, error) {
+	u, err := url.Parse(jwksUrl)
+	if err != nil {
+		return nil, fmt.Errorf("cannot parse JWKS URL: %w", err)
+	}
+	ks := keySet{
+		url:         u,
+		refreshWait: refreshWait,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}
+	if err := ks.load(); err != nil {
+		return nil, err
+	}
+	return &ks, nil
+}
+
+// key returns the public key with the given key id
+func (ks *keySet) key(kid string) (crypto.PublicKey, error) {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	if key, ok := ks.keys[kid]; ok {
+		return key, nil
+	}
+	if time.Since(ks.loadedAt) >= ks.refreshWait {
+		if err := ks.loadLocked(); err != nil {
+			return nil, err
+		}
+		if key, ok := ks.keys[kid]; ok {
+			return key, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+func (ks *keySet) load() error {
+	ks.mu.Lock()
+	defer ks.mu.Unlock()
+	return ks.loadLocked()
+}
+
+func (ks *keySet) loadLocked() error {
+	ks.loadedAt = time.Now()
+	content, err := ks.read()
+	if err != nil {
+		return fmt.Errorf("cannot read JWKS: %w", err)
+	}
+	keys, err := parseJwks(content)
+	if err != nil {
+		return err
+	}
+	ks.keys = keys
+	return nil
+}
+
+func (ks *keySet) read() ([]byte, error) {
+	if ks.url.Scheme == "file" {
+		return os.ReadFile(ks.url.Path)
+	}
+	resp, err := ks.client.Get(ks.url.String())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %v", resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxJwksSize))
+}
+
+// parseJwks decodes the RSA and EC signing keys of a JWKS, ignoring keys of other types
+func parseJwks(content []byte) (map[string]crypto.PublicKey, error) {
+	var set jwkSet
+	if err := json.Unmarshal(content, &set); err != nil {
+		return nil, fmt.Errorf("cannot parse JWKS: %w", err)
+	}
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		var key crypto.PublicKey
+		var err error
+		switch k.Kty {
+		case "RSA":
+			key, err = k.rsaKey()
+		case "EC":
+			key, err = k.ecKey()
+		default:
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("cannot parse JWKS key %q: %w", k.Kid, err)
+		}
+		keys[k.Kid] = key
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("JWKS contains no usable signing keys")
+	}
+	return keys, nil
+}
+
+func (k jwk) rsaKey() (*rsa.PublicKey, error) {
+	n, err := decodeBigInt(k.N)
+	if err != nil {
+		return nil, err
+	}
+	e, err := decodeBigInt(k.E)
+	if err != nil {
+		return nil, err
+	}
+	if !e.IsInt64() || e.Int64() < 3 || e.Int64() > 1<<31-1 {
+		return nil, fmt.Errorf("invalid RSA exponent")
+	}
+	return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+}
+
+func (k jwk) ecKey() (*ecdsa.PublicKey, error) {
+	var curve elliptic.Curve
+	switch k.Crv {
+	case "P-256":
+		curve = elliptic.P256()
+	case "P-384":
+		curve = elliptic.P384()
+	case "P-521":
+		curve = elliptic.P521()
+	default:
+		return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+	}
+	x, err := decodeBigInt(k.X)
+	if err != nil {
+		return nil, err
+	}
+	y, err := decodeBigInt(k.Y)
+	if err != nil {
+		return nil, err
+	}
+	if !curve.IsOnCurve(x, y) {
+		return nil, fmt.Errorf("point is not on curve %v", k.Crv)
+	}
+	return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("invalid base64url value")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
@@ -0,0 +1,52 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJwksEcKey(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	content := fmt.Sprintf(`{"keys": [{"kid": "ec", "kty": "EC", "crv": "P-256", "x": %q, "y": %q}, {"kid": "sym", "kty": "oct", "k": "c2VjcmV0"}]}`,
+		base64.RawURLEncoding.EncodeToString(key.X.Bytes()), base64.RawURLEncoding.EncodeToString(key.Y.Bytes()))
+	keys, err := parseJwks([]byte(content))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(keys))
+	assert.True(t, key.PublicKey.Equal(keys["ec"]))
+}
+
+func TestParseJwksNoKeys(t *testing.T) {
+	_, err := parseJwks([]byte(`{"keys": []}`))
+	assert.NotNil(t, err)
+	assert.EqualValues(t, "JWKS contains no usable signing keys", err.Error())
+}
+
+func TestKeySetReloadsUnknownKid(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+	var loads int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		kid := "old"
+		if atomic.AddInt32(&loads, 1) > 1 {
+			kid = "new"
+		}
+		fmt.Fprintf(w, `{"keys": [{"kid": %q, "kty": "EC", "crv": "P-256", "x": %q, "y": %q}]}`, kid,
+			base64.RawURLEncoding.EncodeToString(key.X.Bytes()), base64.RawURLEncoding.EncodeToString(key.Y.Bytes()))
+	}))
+	defer server.Close()
+	ks, err := newKeySet(server.URL, 0)
+	assert.Nil(t, err)
+	_, err = ks.key("new")
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, atomic.LoadInt32(&loads))
+}
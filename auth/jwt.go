@@ -0,0 +1,77 @@
+package auth
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+)
+
+var (
+	signingMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+)
+
+// tokenValidator checks bearer tokens against a JWKS and turns their claims into a caller
+type tokenValidator struct {
+	keys        *keySet
+	issuer      string
+	audience    string
+	nameClaim   string
+	rolesClaim  string
+	roleMapping map[string][]string
+	parser      *jwt.Parser
+}
+
+func (tv *tokenValidator) validate(token string) (domain.Caller, error) {
+	claims := jwt.MapClaims{}
+	_, err := tv.parser.ParseWithClaims(token, claims, func(t *jwt.Token) (interface{}, error) {
+		kid, _ := t.Header["kid"].(string)
+		return tv.keys.key(kid)
+	})
+	if err != nil {
+		return domain.Caller{}, err
+	}
+	if _, ok := claims["exp"]; !ok {
+		return domain.Caller{}, fmt.Errorf("token has no expiry")
+	}
+	if tv.issuer != "" && !claims.VerifyIssuer(tv.issuer, true) {
+		return domain.Caller{}, fmt.Errorf("token has wrong issuer")
+	}
+	if tv.audience != "" && !claims.VerifyAudience(tv.audience, true) {
+		return domain.Caller{}, fmt.Errorf("token has wrong audience")
+	}
+	name, _ := claims[tv.nameClaim].(string)
+	if strings.TrimSpace(name) == "" {
+		return domain.Caller{}, fmt.Errorf("token has no %v claim", tv.nameClaim)
+	}
+	return domain.Caller{Name: jwtCallerPrefix + name, Roles: tv.roles(claims[tv.rolesClaim])}, nil
+}
+
+// roles maps the values of the roles claim, given as list or as space separated string, to known roles
+func (tv *tokenValidator) roles(claim interface{}) []string {
+	var values []string
+	switch v := claim.(type) {
+	case string:
+		values = strings.Fields(v)
+	case []interface{}:
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+	}
+	var roles []string
+	for _, value := range values {
+		mapped := []string{value}
+		if len(tv.roleMapping) > 0 {
+			mapped = tv.roleMapping[value]
+		}
+		for _, role := range mapped {
+			if domain.IsRole(role) {
+				roles = append(roles, role)
+			}
+		}
+	}
+	return roles
+}
@@ -1,53 +1,94 @@
 package config
 
 import (
-	"fmt"
-	"os"
 	"time"
-
-	"github.com/gin-gonic/gin"
-	"github.com/johannes-kuhfuss/services_utils/logger"
-	"github.com/joho/godotenv"
-)
-
-var (
-	ginMode            = "debug" // release, debug, test
-	ShutDown           = false
-	NoJobWaitTime      = (time.Second * 10)
-	DeleteFinishedAge  = (time.Hour * 1)
-	DeleteFailedAge    = (time.Hour * 2)
-	WarnStatusAge      = (time.Hour * 5)
-	CleanupWaitTime    = (time.Hour * 1)
-	StorageAccountName = ""
-	StorageAccountKey  = ""
-	ListenAddr         = ""
 )
 
-func init() {
-	logger.Info("Initalizing configuration")
-	osGinMode := os.Getenv("GIN_MODE")
-	if osGinMode == gin.ReleaseMode || osGinMode == gin.DebugMode || osGinMode == gin.TestMode {
-		ginMode = osGinMode
-	}
-	logger.Debug(fmt.Sprintf("Gin-Gonic Mode: %v\n", ginMode))
-	StorageAccountName = os.Getenv("STORAGE_ACCOUNT_NAME")
-	StorageAccountKey = os.Getenv("STORAGE_ACCOUNT_KEY")
-	if StorageAccountName == "" || StorageAccountKey == "" {
-		err := godotenv.Load(".env")
-		if err != nil {
-			logger.Error("Could not open env file", err)
-		}
-		StorageAccountName = os.Getenv("STORAGE_ACCOUNT_NAME")
-		StorageAccountKey = os.Getenv("STORAGE_ACCOUNT_KEY")
-	}
-	logger.Debug(fmt.Sprintf("Storage Account Name: %v\n", StorageAccountName))
-	ListenAddr = os.Getenv("LISTEN_ADDR")
-	if len(ListenAddr) == 0 {
-		ListenAddr = ":8080"
-	}
-	logger.Info("Done initalizing configuration")
+type AppConfig struct {
+	ConfigFile         string
+	GinMode            string // release, debug, test
+	WorkerCount        int
+	NoJobWaitTime      time.Duration
+	DeleteFinishedAge  time.Duration
+	DeleteFailedAge    time.Duration
+	WarnStatusAge      time.Duration
+	CleanupWaitTime    time.Duration
+	ProgressInterval   time.Duration
+	ChunkSize          int64
+	ChunkParallelism   int
+	ChunkRetries       int
+	ChunkRetryWait     time.Duration
+	HeartbeatTimeout   time.Duration
+	HealthCheckTimeout time.Duration
+	HealthCheckStorage bool
+	IdempotencyAge     time.Duration
+	DeleteBatchAge     time.Duration
+	MaxBatchSize       int
+	CoalesceJobs       bool
+	StorageAccountName string
+	StorageAccountKey  string
+	ListenAddr         string
+	TrustedProxies     string // comma separated IPs or CIDRs of proxies whose X-Forwarded-For is trusted
+	TlsCertFile        string
+	TlsKeyFile         string
+	TlsClientCaFile    string
+	TlsRequireClient   bool
+	LocalRootDir       string
+	ManifestLocation   string
+	ApiKeys            string // comma separated name:sha256 pairs
+	JwksUrl            string
+	JwksRefreshWait    time.Duration
+	JwtIssuer          string
+	JwtAudience        string
+	JwtNameClaim       string
+	JwtRolesClaim      string
+	JwtRoleMap         string
+	ClientCertRoles    string
+	PolicySchemes      string
+	PolicyAccounts     string
+	PolicyContainers   string
+	PolicyAllowed      string
+	PolicyDenied       string
+	PolicyDestructive  string
+	PolicyMaxSize      int64
+	RateLimit          int // job creation requests per minute and client, 0 disables
+	RateBurst          int
+	QuotaMaxQueued     int
+	QuotaMaxBytesDay   int64
+	QueueMaxJobs       int
+	QueueMaxMemory     int64
+	QueueRetryAfter    time.Duration
+	TracingEndpoint    string // host:port of an OTLP/HTTP collector, empty disables tracing
+	TracingInsecure    bool
+	TracingSamplePct   int
 }
 
-func GinMode() string {
-	return ginMode
+// New returns the default configuration. It does not read the environment; use Load for that.
+func New() *AppConfig {
+	return &AppConfig{
+		GinMode:            "debug",
+		WorkerCount:        1,
+		NoJobWaitTime:      (time.Second * 10),
+		DeleteFinishedAge:  (time.Hour * 1),
+		DeleteFailedAge:    (time.Hour * 2),
+		WarnStatusAge:      (time.Hour * 5),
+		CleanupWaitTime:    (time.Hour * 1),
+		ProgressInterval:   (time.Second * 2),
+		ChunkSize:          int64(32 * 1024 * 1024),
+		ChunkParallelism:   4,
+		ChunkRetries:       3,
+		ChunkRetryWait:     (time.Second * 1),
+		HeartbeatTimeout:   (time.Minute * 1),
+		HealthCheckTimeout: (time.Second * 5),
+		IdempotencyAge:     (time.Hour * 24),
+		DeleteBatchAge:     (time.Hour * 24),
+		MaxBatchSize:       1000,
+		ListenAddr:         ":8080",
+		JwksRefreshWait:    (time.Minute * 5),
+		JwtNameClaim:       "sub",
+		JwtRolesClaim:      "roles",
+		RateBurst:          10,
+		QueueRetryAfter:    (time.Second * 30),
+		TracingSamplePct:   100,
+	}
 }
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ApiKey is a configured API key, identified by the hash of the key
+type ApiKey struct {
+	Name  string
+	Roles []string
+}
+
+// ApiKeyHashes parses the configured API keys into a map from the hex SHA-256 hash of a key
+// to its caller. Only hashes are configured, so the keys themselves are never stored.
+// Entries are name:hash or name:hash:role+role.
+func (cfg *AppConfig) ApiKeyHashes() (map[string]ApiKey, error) {
+	hashes := make(map[string]ApiKey)
+	for _, entry := range strings.Split(cfg.ApiKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		parts := strings.SplitN(entry, ":", 3)
+		if len(parts) < 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("auth.api_keys entry %q must be name:hash or name:hash:roles", entry)
+		}
+		name, hash := strings.TrimSpace(parts[0]), strings.ToLower(strings.TrimSpace(parts[1]))
+		if decoded, err := hex.DecodeString(hash); err != nil || len(decoded) != 32 {
+			return nil, fmt.Errorf("auth.api_keys hash of %v must be a hex SHA-256 digest", name)
+		}
+		if _, ok := hashes[hash]; ok {
+			return nil, fmt.Errorf("auth.api_keys hash of %v is used more than once", name)
+		}
+		key := ApiKey{Name: name}
+		if len(parts) == 3 {
+			key.Roles = splitList(parts[2], "+")
+		}
+		hashes[hash] = key
+	}
+	return hashes, nil
+}
+
+// JwtRoleMapping parses auth.jwt_role_map, which maps claim values to roles as value:role+role pairs
+func (cfg *AppConfig) JwtRoleMapping() (map[string][]string, error) {
+	return roleMapping("auth.jwt_role_map", cfg.JwtRoleMap)
+}
+
+// ClientCertRoleMapping parses auth.client_cert_roles, a YAML map from certificate subjects to lists of roles.
+// Subjects are distinguished names or common names, which may contain any separator, so they are not
+// listed as pairs like the other mappings.
+func (cfg *AppConfig) ClientCertRoleMapping() (map[string][]string, error) {
+	mapping := make(map[string][]string)
+	if strings.TrimSpace(cfg.ClientCertRoles) == "" {
+		return mapping, nil
+	}
+	var entries map[string][]string
+	if err := yaml.Unmarshal([]byte(cfg.ClientCertRoles), &entries); err != nil {
+		return nil, fmt.Errorf("auth.client_cert_roles must map subjects to lists of roles: %w", err)
+	}
+	for subject, roles := range entries {
+		var cleaned []string
+		for _, role := range roles {
+			if role = strings.TrimSpace(role); role != "" {
+				cleaned = append(cleaned, role)
+			}
+		}
+		if strings.TrimSpace(subject) == "" || len(cleaned) == 0 {
+			return nil, fmt.Errorf("auth.client_cert_roles entry %q must have a subject and roles", subject)
+		}
+		mapping[subject] = cleaned
+	}
+	return mapping, nil
+}
+
+func roleMapping(key string, list string) (map[string][]string, error) {
+	mapping := make(map[string][]string)
+	for _, entry := range splitList(list, ",") {
+		parts := strings.SplitN(entry, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" || len(splitList(parts[1], "+")) == 0 {
+			return nil, fmt.Errorf("%v entry %q must be value:roles", key, entry)
+		}
+		mapping[strings.TrimSpace(parts[0])] = splitList(parts[1], "+")
+	}
+	return mapping, nil
+}
+
+// SplitList splits a comma separated setting, dropping empty items
+func SplitList(list string) []string {
+	return splitList(list, ",")
+}
+
+func splitList(list string, sep string) []string {
+	var items []string
+	for _, item := range strings.Split(list, sep) {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,373 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/joho/godotenv"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	redacted = "***"
+)
+
+// setting describes one tunable. key is used in the config file (nested by ".") and as flag
+// name, env is the environment variable overriding the file, value points to the field of the configuration.
+type setting struct {
+	key       string
+	env       string
+	usage     string
+	secret    bool
+	allowZero bool
+	mapping   bool
+	value     interface{}
+}
+
+// settings lists every tunable of the configuration, pointing into cfg
+func (cfg *AppConfig) settings() []setting {
+	return []setting{
+		{key: "server.listen_addr", env: "LISTEN_ADDR", usage: "address to listen on", value: &cfg.ListenAddr},
+		{key: "server.tls_cert_file", env: "TLS_CERT_FILE", usage: "PEM certificate chain to serve HTTPS with, empty serves HTTP; reloaded when it changes", value: &cfg.TlsCertFile},
+		{key: "server.tls_key_file", env: "TLS_KEY_FILE", usage: "PEM private key of the certificate; reloaded when it changes", value: &cfg.TlsKeyFile},
+		{key: "server.tls_client_ca_file", env: "TLS_CLIENT_CA_FILE", usage: "PEM CA certificates client certificates are verified against, empty disables client certificates", value: &cfg.TlsClientCaFile},
+		{key: "server.tls_require_client_cert", env: "TLS_REQUIRE_CLIENT_CERT", usage: "reject connections without a valid client certificate", value: &cfg.TlsRequireClient},
+		{key: "server.trusted_proxies", env: "TRUSTED_PROXIES", usage: "comma separated IPs or CIDRs of reverse proxies whose X-Forwarded-For header identifies the client, empty trusts none", value: &cfg.TrustedProxies},
+		{key: "server.gin_mode", env: "GIN_MODE", usage: "release, debug or test", value: &cfg.GinMode},
+		{key: "storage.account_name", env: "STORAGE_ACCOUNT_NAME", usage: "storage account name", value: &cfg.StorageAccountName},
+		{key: "storage.account_key", env: "STORAGE_ACCOUNT_KEY", usage: "storage account key", secret: true, value: &cfg.StorageAccountKey},
+		{key: "storage.local_root_dir", env: "LOCAL_ROOT_DIR", usage: "root directory for file:// sources, empty to disable", value: &cfg.LocalRootDir},
+		{key: "manifest.location", env: "MANIFEST_LOCATION", usage: "file:// directory or container URL manifests are written to", value: &cfg.ManifestLocation},
+		{key: "auth.api_keys", env: "API_KEYS", usage: "comma separated name:hash[:role+role] entries of accepted API keys, hash is the hex SHA-256 of the key", secret: true, value: &cfg.ApiKeys},
+		{key: "auth.jwks_url", env: "JWKS_URL", usage: "https:// or file:// URL of the JWKS used to validate bearer tokens, empty disables tokens", value: &cfg.JwksUrl},
+		{key: "auth.jwks_refresh_wait", env: "JWKS_REFRESH_WAIT", usage: "minimum time between reloads of the JWKS for unknown key ids", value: &cfg.JwksRefreshWait},
+		{key: "auth.jwt_issuer", env: "JWT_ISSUER", usage: "required issuer of bearer tokens, empty to accept any", value: &cfg.JwtIssuer},
+		{key: "auth.jwt_audience", env: "JWT_AUDIENCE", usage: "required audience of bearer tokens, empty to accept any", value: &cfg.JwtAudience},
+		{key: "auth.jwt_name_claim", env: "JWT_NAME_CLAIM", usage: "claim holding the caller name", value: &cfg.JwtNameClaim},
+		{key: "auth.jwt_roles_claim", env: "JWT_ROLES_CLAIM", usage: "claim holding the caller roles", value: &cfg.JwtRolesClaim},
+		{key: "auth.jwt_role_map", env: "JWT_ROLE_MAP", usage: "comma separated value:role+role entries mapping claim values to roles, empty to use claim values as roles", value: &cfg.JwtRoleMap},
+		{key: "auth.client_cert_roles", env: "CLIENT_CERT_ROLES", usage: "YAML map of client certificate subjects to lists of roles, e.g. {\"CN=x,O=y\": [admin]}, other subjects get submitter and viewer", mapping: true, value: &cfg.ClientCertRoles},
+		{key: "policy.schemes", env: "POLICY_SCHEMES", usage: "comma separated source URL schemes jobs may use, empty allows all", value: &cfg.PolicySchemes},
+		{key: "policy.accounts", env: "POLICY_ACCOUNTS", usage: "comma separated storage accounts jobs may read, empty allows all", value: &cfg.PolicyAccounts},
+		{key: "policy.containers", env: "POLICY_CONTAINERS", usage: "comma separated containers jobs may read, empty allows all", value: &cfg.PolicyContainers},
+		{key: "policy.allowed_prefixes", env: "POLICY_ALLOWED_PREFIXES", usage: "comma separated source URL prefixes jobs may read, empty allows all", value: &cfg.PolicyAllowed},
+		{key: "policy.denied_prefixes", env: "POLICY_DENIED_PREFIXES", usage: "comma separated source URL prefixes jobs must not read", value: &cfg.PolicyDenied},
+		{key: "policy.destructive_principals", env: "POLICY_DESTRUCTIVE_PRINCIPALS", usage: "comma separated caller names (key:<name>, jwt:<name> or cert:<subject>) or role:<role> allowed to create jobs deleting the source, empty allows all", value: &cfg.PolicyDestructive},
+		{key: "policy.max_size", env: "POLICY_MAX_SIZE", usage: "maximum number of bytes a job may hash, 0 for no limit", value: &cfg.PolicyMaxSize},
+		{key: "limits.rate_per_minute", env: "RATE_LIMIT", usage: "job creation requests per minute per API key or IP, 0 for no limit", value: &cfg.RateLimit},
+		{key: "limits.rate_burst", env: "RATE_BURST", usage: "job creation requests an idle client may send at once", value: &cfg.RateBurst},
+		{key: "limits.max_queued_jobs", env: "QUOTA_MAX_QUEUED_JOBS", usage: "maximum number of queued jobs per user, 0 for no limit", value: &cfg.QuotaMaxQueued},
+		{key: "limits.max_bytes_per_day", env: "QUOTA_MAX_BYTES_PER_DAY", usage: "maximum number of bytes hashed per user and UTC day, 0 for no limit", value: &cfg.QuotaMaxBytesDay},
+		{key: "queue.max_jobs", env: "QUEUE_MAX_JOBS", usage: "maximum number of queued jobs, 0 for no limit", value: &cfg.QueueMaxJobs},
+		{key: "queue.max_memory", env: "QUEUE_MAX_MEMORY", usage: "estimated memory in bytes all stored jobs may use, 0 for no limit", value: &cfg.QueueMaxMemory},
+		{key: "queue.retry_after", env: "QUEUE_RETRY_AFTER", usage: "time clients are asked to wait when the queue is full", value: &cfg.QueueRetryAfter},
+		{key: "tracing.otlp_endpoint", env: "TRACING_OTLP_ENDPOINT", usage: "host:port of the OTLP/HTTP collector spans are exported to, empty disables tracing", value: &cfg.TracingEndpoint},
+		{key: "tracing.insecure", env: "TRACING_INSECURE", usage: "export spans via plain HTTP, e.g. to a local collector", value: &cfg.TracingInsecure},
+		{key: "tracing.sample_percent", env: "TRACING_SAMPLE_PERCENT", usage: "percentage of new traces that are recorded", value: &cfg.TracingSamplePct},
+		{key: "processor.workers", env: "WORKER_COUNT", usage: "number of concurrent job processors", value: &cfg.WorkerCount},
+		{key: "processor.no_job_wait_time", env: "NO_JOB_WAIT_TIME", usage: "wait time when no job is queued", value: &cfg.NoJobWaitTime},
+		{key: "processor.coalesce_jobs", env: "COALESCE_JOBS", usage: "return pending jobs for the same source instead of creating new ones", value: &cfg.CoalesceJobs},
+		{key: "processor.progress_interval", env: "PROGRESS_INTERVAL", usage: "interval of progress updates", value: &cfg.ProgressInterval},
+		{key: "download.chunk_size", env: "CHUNK_SIZE", usage: "size of ranged downloads in bytes", value: &cfg.ChunkSize},
+		{key: "download.chunk_parallelism", env: "CHUNK_PARALLELISM", usage: "number of concurrent ranged downloads per job", value: &cfg.ChunkParallelism},
+		{key: "download.chunk_retries", env: "CHUNK_RETRIES", usage: "retries per ranged download", value: &cfg.ChunkRetries},
+		{key: "download.chunk_retry_wait", env: "CHUNK_RETRY_WAIT", usage: "wait time before the first retry", allowZero: true, value: &cfg.ChunkRetryWait},
+		{key: "cleanup.wait_time", env: "CLEANUP_WAIT_TIME", usage: "interval of the cleanup", value: &cfg.CleanupWaitTime},
+		{key: "cleanup.delete_finished_age", env: "DELETE_FINISHED_AGE", usage: "age after which finished jobs are deleted", value: &cfg.DeleteFinishedAge},
+		{key: "cleanup.delete_failed_age", env: "DELETE_FAILED_AGE", usage: "age after which failed jobs are deleted", value: &cfg.DeleteFailedAge},
+		{key: "cleanup.warn_status_age", env: "WARN_STATUS_AGE", usage: "age after which jobs in other states are logged", value: &cfg.WarnStatusAge},
+		{key: "cleanup.idempotency_age", env: "IDEMPOTENCY_AGE", usage: "age after which idempotency keys expire", value: &cfg.IdempotencyAge},
+		{key: "cleanup.delete_batch_age", env: "DELETE_BATCH_AGE", usage: "age after which batches are deleted once all of their jobs are done", value: &cfg.DeleteBatchAge},
+		{key: "batch.max_size", env: "MAX_BATCH_SIZE", usage: "maximum number of jobs in a batch", value: &cfg.MaxBatchSize},
+		{key: "health.heartbeat_timeout", env: "HEARTBEAT_TIMEOUT", usage: "time a background loop may miss its heartbeat", value: &cfg.HeartbeatTimeout},
+		{key: "health.check_timeout", env: "HEALTH_CHECK_TIMEOUT", usage: "timeout of readiness dependency checks", value: &cfg.HealthCheckTimeout},
+		{key: "health.check_storage", env: "HEALTH_CHECK_STORAGE", usage: "check that the storage account answers in readiness checks", value: &cfg.HealthCheckStorage},
+	}
+}
+
+// Load layers the configuration: defaults, then the config file, then environment variables
+// (including a .env file in the working directory), then command line flags. The resulting configuration is validated.
+// It returns true if the effective configuration should be printed instead of starting.
+func (cfg *AppConfig) Load(args []string) (bool, error) {
+	flags := flag.NewFlagSet("c4svc", flag.ContinueOnError)
+	configFile := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML configuration file")
+	printConfig := flags.Bool("print-config", false, "print the effective configuration with secrets redacted and exit")
+	flagValues := make(map[string]string)
+	settings := cfg.settings()
+	for _, s := range settings {
+		key := s.key
+		flags.Func(key, s.usage+" (env "+s.env+")", func(value string) error {
+			flagValues[key] = value
+			return nil
+		})
+	}
+	if err := flags.Parse(args); err != nil {
+		return false, err
+	}
+	if *configFile != "" {
+		cfg.ConfigFile = *configFile
+		if err := loadFile(settings, *configFile); err != nil {
+			return false, err
+		}
+	}
+	if err := godotenv.Load(".env"); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return false, fmt.Errorf("cannot load .env file: %w", err)
+	}
+	if err := applyEnv(settings); err != nil {
+		return false, err
+	}
+	for _, s := range settings {
+		if value, ok := flagValues[s.key]; ok {
+			if err := s.set(value); err != nil {
+				return false, fmt.Errorf("flag -%v: %w", s.key, err)
+			}
+		}
+	}
+	return *printConfig, cfg.Validate()
+}
+
+func applyEnv(settings []setting) error {
+	for _, s := range settings {
+		if value, ok := os.LookupEnv(s.env); ok {
+			if err := s.set(value); err != nil {
+				return fmt.Errorf("environment variable %v: %w", s.env, err)
+			}
+		}
+	}
+	return nil
+}
+
+func loadFile(settings []setting, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read config file: %w", err)
+	}
+	var tree map[string]interface{}
+	if err := yaml.Unmarshal(content, &tree); err != nil {
+		return fmt.Errorf("cannot parse config file %v: %w", path, err)
+	}
+	known := make(map[string]setting)
+	for _, s := range settings {
+		known[s.key] = s
+	}
+	values := make(map[string]string)
+	if err := flatten("", tree, known, values); err != nil {
+		return fmt.Errorf("config file %v: %w", path, err)
+	}
+	for key, value := range values {
+		s, ok := known[key]
+		if !ok {
+			return fmt.Errorf("config file %v: unknown setting %v", path, key)
+		}
+		if err := s.set(value); err != nil {
+			return fmt.Errorf("config file %v: %v: %w", path, key, err)
+		}
+	}
+	return nil
+}
+
+// flatten turns the nested keys of the tree into setting keys. Maps of settings holding a mapping
+// are kept as YAML, as their keys are values and not nested settings.
+func flatten(prefix string, tree map[string]interface{}, known map[string]setting, values map[string]string) error {
+	for key, value := range tree {
+		if prefix != "" {
+			key = prefix + "." + key
+		}
+		subtree, ok := value.(map[string]interface{})
+		switch {
+		case ok && known[key].mapping:
+			content, err := yaml.Marshal(subtree)
+			if err != nil {
+				return fmt.Errorf("%v: %w", key, err)
+			}
+			values[key] = string(content)
+		case ok:
+			if err := flatten(key, subtree, known, values); err != nil {
+				return err
+			}
+		default:
+			values[key] = fmt.Sprint(value)
+		}
+	}
+	return nil
+}
+
+func (s setting) set(value string) error {
+	var err error
+	switch v := s.value.(type) {
+	case *string:
+		*v = value
+	case *bool:
+		*v, err = strconv.ParseBool(value)
+	case *int:
+		*v, err = strconv.Atoi(value)
+	case *int64:
+		*v, err = strconv.ParseInt(value, 10, 64)
+	case *time.Duration:
+		*v, err = time.ParseDuration(value)
+	default:
+		err = fmt.Errorf("unsupported setting type %T", s.value)
+	}
+	return err
+}
+
+func (s setting) get() interface{} {
+	switch v := s.value.(type) {
+	case *string:
+		return *v
+	case *bool:
+		return *v
+	case *int:
+		return *v
+	case *int64:
+		return *v
+	case *time.Duration:
+		return *v
+	default:
+		return nil
+	}
+}
+
+func (s setting) String() string {
+	value := fmt.Sprint(s.get())
+	if s.secret && value != "" {
+		return redacted
+	}
+	return value
+}
+
+// Effective returns the current value of every setting with secrets redacted
+func (cfg *AppConfig) Effective() map[string]string {
+	effective := make(map[string]string)
+	for _, s := range cfg.settings() {
+		effective[s.key] = s.String()
+	}
+	return effective
+}
+
+func (cfg *AppConfig) PrintEffective(w io.Writer) {
+	effective := cfg.Effective()
+	keys := make([]string, 0, len(effective))
+	for key := range effective {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%v = %v\n", key, effective[key])
+	}
+}
+
+// Validate checks all settings and reports every problem found, not just the first one
+func (cfg *AppConfig) Validate() error {
+	var problems []string
+	if cfg.GinMode != "release" && cfg.GinMode != "debug" && cfg.GinMode != "test" {
+		problems = append(problems, fmt.Sprintf("server.gin_mode must be release, debug or test, not %q", cfg.GinMode))
+	}
+	if strings.TrimSpace(cfg.ListenAddr) == "" {
+		problems = append(problems, "server.listen_addr must not be empty")
+	}
+	if (cfg.StorageAccountName == "") != (cfg.StorageAccountKey == "") {
+		problems = append(problems, "storage.account_name and storage.account_key must be set together")
+	}
+	if cfg.LocalRootDir != "" {
+		if info, err := os.Stat(cfg.LocalRootDir); err != nil || !info.IsDir() {
+			problems = append(problems, fmt.Sprintf("storage.local_root_dir %v is not a directory", cfg.LocalRootDir))
+		}
+	}
+	if _, err := cfg.ApiKeyHashes(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := cfg.JwtRoleMapping(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := cfg.ClientCertRoleMapping(); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if (cfg.TlsCertFile == "") != (cfg.TlsKeyFile == "") {
+		problems = append(problems, "server.tls_cert_file and server.tls_key_file must be set together")
+	}
+	if cfg.TlsClientCaFile != "" && cfg.TlsCertFile == "" {
+		problems = append(problems, "server.tls_client_ca_file requires server.tls_cert_file")
+	}
+	if cfg.TlsRequireClient && cfg.TlsClientCaFile == "" {
+		problems = append(problems, "server.tls_require_client_cert requires server.tls_client_ca_file")
+	}
+	for _, file := range []struct{ key, path string }{
+		{"server.tls_cert_file", cfg.TlsCertFile},
+		{"server.tls_key_file", cfg.TlsKeyFile},
+		{"server.tls_client_ca_file", cfg.TlsClientCaFile},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if info, err := os.Stat(file.path); err != nil || info.IsDir() {
+			problems = append(problems, fmt.Sprintf("%v %v is not a file", file.key, file.path))
+		}
+	}
+	if cfg.JwksUrl != "" {
+		if u, err := url.Parse(cfg.JwksUrl); err != nil || (u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "file") {
+			problems = append(problems, fmt.Sprintf("auth.jwks_url %v must be a https://, http:// or file:// URL", cfg.JwksUrl))
+		}
+		if strings.TrimSpace(cfg.JwtNameClaim) == "" || strings.TrimSpace(cfg.JwtRolesClaim) == "" {
+			problems = append(problems, "auth.jwt_name_claim and auth.jwt_roles_claim must not be empty")
+		}
+	}
+	for _, proxy := range SplitList(cfg.TrustedProxies) {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			problems = append(problems, fmt.Sprintf("server.trusted_proxies entry %v is neither an IP nor a CIDR", proxy))
+		}
+	}
+	if strings.Contains(cfg.TracingEndpoint, "://") {
+		problems = append(problems, fmt.Sprintf("tracing.otlp_endpoint %v must be host:port without a scheme", cfg.TracingEndpoint))
+	}
+	if cfg.TracingSamplePct > 100 {
+		problems = append(problems, "tracing.sample_percent must be at most 100")
+	}
+	minimums := []struct {
+		key   string
+		value int64
+		min   int64
+	}{
+		{"processor.workers", int64(cfg.WorkerCount), 1},
+		{"download.chunk_size", cfg.ChunkSize, 1},
+		{"download.chunk_parallelism", int64(cfg.ChunkParallelism), 1},
+		{"download.chunk_retries", int64(cfg.ChunkRetries), 0},
+		{"batch.max_size", int64(cfg.MaxBatchSize), 1},
+		{"policy.max_size", cfg.PolicyMaxSize, 0},
+		{"limits.rate_per_minute", int64(cfg.RateLimit), 0},
+		{"limits.rate_burst", int64(cfg.RateBurst), 1},
+		{"limits.max_queued_jobs", int64(cfg.QuotaMaxQueued), 0},
+		{"limits.max_bytes_per_day", cfg.QuotaMaxBytesDay, 0},
+		{"queue.max_jobs", int64(cfg.QueueMaxJobs), 0},
+		{"queue.max_memory", cfg.QueueMaxMemory, 0},
+		{"tracing.sample_percent", int64(cfg.TracingSamplePct), 0},
+	}
+	for _, m := range minimums {
+		if m.value < m.min {
+			problems = append(problems, fmt.Sprintf("%v must be at least %d", m.key, m.min))
+		}
+	}
+	for _, s := range cfg.settings() {
+		d, ok := s.value.(*time.Duration)
+		if !ok {
+			continue
+		}
+		if *d < 0 || (*d == 0 && !s.allowZero) {
+			problems = append(problems, fmt.Sprintf("%v must be a positive duration", s.key))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("invalid configuration:\n  - %v", strings.Join(problems, "\n  - "))
+	}
+	return nil
+}
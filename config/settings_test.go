@@ -0,0 +1,174 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "c4svc.yaml")
+	err := os.WriteFile(path, []byte(content), 0644)
+	assert.Nil(t, err)
+	return path
+}
+
+func TestNewIsValid(t *testing.T) {
+	assert.Nil(t, New().Validate())
+}
+
+func TestLoadLayersFileEnvAndFlags(t *testing.T) {
+	path := writeConfigFile(t, "processor:\n  workers: 3\n  no_job_wait_time: 5s\ncleanup:\n  delete_finished_age: 30m\n  delete_failed_age: 3h\n")
+	t.Setenv("DELETE_FAILED_AGE", "4h")
+	t.Setenv("WORKER_COUNT", "5")
+	cfg := New()
+	printConfig, err := cfg.Load([]string{"-config", path, "-processor.workers=7"})
+	assert.Nil(t, err)
+	assert.False(t, printConfig)
+	assert.EqualValues(t, 7, cfg.WorkerCount)
+	assert.EqualValues(t, 5*time.Second, cfg.NoJobWaitTime)
+	assert.EqualValues(t, 30*time.Minute, cfg.DeleteFinishedAge)
+	assert.EqualValues(t, 4*time.Hour, cfg.DeleteFailedAge)
+	assert.EqualValues(t, path, cfg.ConfigFile)
+}
+
+func TestLoadUnknownFileSetting(t *testing.T) {
+	path := writeConfigFile(t, "processor:\n  worker: 3\n")
+	_, err := New().Load([]string{"-config", path})
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasSuffix(err.Error(), "unknown setting processor.worker"))
+}
+
+func TestLoadInvalidFlagValue(t *testing.T) {
+	_, err := New().Load([]string{"-cleanup.wait_time=often"})
+	assert.NotNil(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "flag -cleanup.wait_time"))
+}
+
+func TestLoadDoesNotChangeOtherInstances(t *testing.T) {
+	first, second := New(), New()
+	_, err := first.Load([]string{"-processor.workers=4"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 4, first.WorkerCount)
+	assert.EqualValues(t, 1, second.WorkerCount)
+}
+
+func TestValidateReportsAllProblems(t *testing.T) {
+	cfg := New()
+	cfg.WorkerCount = 0
+	cfg.CleanupWaitTime = 0
+	cfg.StorageAccountName, cfg.StorageAccountKey = "account", ""
+	err := cfg.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "processor.workers must be at least 1")
+	assert.Contains(t, err.Error(), "cleanup.wait_time must be a positive duration")
+	assert.Contains(t, err.Error(), "storage.account_name and storage.account_key must be set together")
+}
+
+func TestEffectiveRedactsSecrets(t *testing.T) {
+	cfg := New()
+	cfg.StorageAccountName, cfg.StorageAccountKey = "account", "secret-key"
+	effective := cfg.Effective()
+	assert.EqualValues(t, "account", effective["storage.account_name"])
+	assert.EqualValues(t, redacted, effective["storage.account_key"])
+	var out bytes.Buffer
+	cfg.PrintEffective(&out)
+	assert.NotContains(t, out.String(), "secret-key")
+	assert.Contains(t, out.String(), "storage.account_key = ***\n")
+}
+
+func TestApiKeyHashes(t *testing.T) {
+	cfg := New()
+	cfg.ApiKeys = "alice:" + strings.Repeat("AB", 32) + ", bob:" + strings.Repeat("cd", 32) + ":viewer+submitter"
+	hashes, err := cfg.ApiKeyHashes()
+	assert.Nil(t, err)
+	assert.EqualValues(t, map[string]ApiKey{
+		strings.Repeat("ab", 32): {Name: "alice"},
+		strings.Repeat("cd", 32): {Name: "bob", Roles: []string{"viewer", "submitter"}},
+	}, hashes)
+	assert.EqualValues(t, redacted, cfg.Effective()["auth.api_keys"])
+}
+
+func TestApiKeyHashesInvalid(t *testing.T) {
+	cfg := New()
+	cfg.ApiKeys = "alice:secret"
+	_, err := cfg.ApiKeyHashes()
+	assert.NotNil(t, err)
+	assert.EqualValues(t, "auth.api_keys hash of alice must be a hex SHA-256 digest", err.Error())
+	assert.NotNil(t, cfg.Validate())
+}
+
+func TestJwtRoleMapping(t *testing.T) {
+	cfg := New()
+	cfg.JwtRoleMap = "c4-admins:admin, c4-users:submitter+viewer"
+	mapping, err := cfg.JwtRoleMapping()
+	assert.Nil(t, err)
+	assert.EqualValues(t, map[string][]string{"c4-admins": {"admin"}, "c4-users": {"submitter", "viewer"}}, mapping)
+	cfg.JwtRoleMap = "c4-admins"
+	_, err = cfg.JwtRoleMapping()
+	assert.NotNil(t, err)
+}
+
+func TestClientCertRoleMapping(t *testing.T) {
+	path := writeConfigFile(t, "auth:\n  client_cert_roles:\n    \"CN=carol,O=Example\\\\, Inc.\": [admin]\n    \"Doe, John\": [submitter, viewer]\n")
+	cfg := New()
+	_, err := cfg.Load([]string{"-config", path})
+	assert.Nil(t, err)
+	mapping, err := cfg.ClientCertRoleMapping()
+	assert.Nil(t, err)
+	assert.EqualValues(t, map[string][]string{`CN=carol,O=Example\, Inc.`: {"admin"}, "Doe, John": {"submitter", "viewer"}}, mapping)
+	cfg.ClientCertRoles = `{"CN=carol,O=Example": [admin]}`
+	mapping, err = cfg.ClientCertRoleMapping()
+	assert.Nil(t, err)
+	assert.EqualValues(t, map[string][]string{"CN=carol,O=Example": {"admin"}}, mapping)
+	cfg.ClientCertRoles = `{"CN=carol,O=Example": []}`
+	_, err = cfg.ClientCertRoleMapping()
+	assert.NotNil(t, err)
+	assert.EqualValues(t, `auth.client_cert_roles entry "CN=carol,O=Example" must have a subject and roles`, err.Error())
+}
+
+func TestValidateJwksUrl(t *testing.T) {
+	cfg := New()
+	cfg.JwksUrl = "ftp://server/jwks.json"
+	err := cfg.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "auth.jwks_url ftp://server/jwks.json must be a https://, http:// or file:// URL")
+}
+
+func TestValidateTls(t *testing.T) {
+	cfg := New()
+	cfg.TlsCertFile = "/nonexistent/cert.pem"
+	cfg.TlsRequireClient = true
+	cfg.ClientCertRoles = "alice"
+	err := cfg.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "server.tls_cert_file and server.tls_key_file must be set together")
+	assert.Contains(t, err.Error(), "server.tls_require_client_cert requires server.tls_client_ca_file")
+	assert.Contains(t, err.Error(), "server.tls_cert_file /nonexistent/cert.pem is not a file")
+	assert.Contains(t, err.Error(), "auth.client_cert_roles must map subjects to lists of roles")
+}
+
+func TestValidateTrustedProxies(t *testing.T) {
+	cfg := New()
+	cfg.TrustedProxies = "10.0.0.1, 192.168.0.0/16, proxy.local"
+	err := cfg.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "server.trusted_proxies entry proxy.local is neither an IP nor a CIDR")
+	assert.NotContains(t, err.Error(), "entry 10.0.0.1")
+	assert.NotContains(t, err.Error(), "entry 192.168.0.0/16")
+}
+
+func TestValidateTracing(t *testing.T) {
+	cfg := New()
+	cfg.TracingEndpoint = "http://localhost:4318"
+	cfg.TracingSamplePct = 101
+	err := cfg.Validate()
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "tracing.otlp_endpoint http://localhost:4318 must be host:port without a scheme")
+	assert.Contains(t, err.Error(), "tracing.sample_percent must be at most 100")
+}
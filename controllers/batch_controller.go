@@ -0,0 +1,72 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johannes-kuhfuss/c4svc/auth"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/request"
+	"github.com/johannes-kuhfuss/c4svc/services"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+type BatchController interface {
+	Create(*gin.Context)
+	Get(*gin.Context)
+}
+
+type batchController struct {
+	batchService services.BatchService
+}
+
+func NewBatchController(batchService services.BatchService) BatchController {
+	return &batchController{
+		batchService: batchService,
+	}
+}
+
+func (bc batchController) Create(c *gin.Context) {
+	logger.Debug("Processing batch create request", request.LogField(c))
+	var newJobs []domain.Job
+	if err := c.ShouldBindJSON(&newJobs); err != nil {
+		logger.Error("invalid JSON body in batch create request", err, request.LogField(c))
+		request.Fail(c, invalidJsonError(err))
+		return
+	}
+	for i := range newJobs {
+		newJobs[i].RequestId = request.GetId(c)
+		newJobs[i].TraceContext = domain.NewTraceContext(c.Request.Context())
+	}
+	result, err := bc.batchService.Create(newJobs, auth.GetCaller(c))
+	if err != nil {
+		logger.Error("Service error while creating batch", err, request.LogField(c))
+		setRetryAfter(c, err)
+		request.Fail(c, err)
+		return
+	}
+	// a batch of which no job could be created fails as a whole, with the errors of its items
+	if result.Created == 0 {
+		failed := domain.WithCode(domain.CodeBatchFailed, api_error.NewError("no job of the batch could be created", http.StatusUnprocessableEntity, nil))
+		logger.Error("No job of the batch could be created", failed, request.LogField(c))
+		problem := domain.NewProblem(failed, c.Request.URL.Path, request.GetId(c))
+		problem.Items = result.Items
+		request.FailProblem(c, problem)
+		return
+	}
+	c.JSON(http.StatusCreated, result)
+	logger.Debug("Done processing batch create request", request.LogField(c))
+}
+
+func (bc batchController) Get(c *gin.Context) {
+	logger.Debug("Processing batch get request", request.LogField(c))
+	summary, err := bc.batchService.Get(c.Param("batch_id"), auth.GetCaller(c))
+	if err != nil {
+		logger.Error("Service error while getting batch", err, request.LogField(c))
+		request.Fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, summary)
+	logger.Debug("Done processing batch get request", request.LogField(c))
+}
@@ -0,0 +1,37 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johannes-kuhfuss/c4svc/auth"
+	"github.com/johannes-kuhfuss/c4svc/request"
+	"github.com/johannes-kuhfuss/c4svc/services"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+type C4IndexController interface {
+	Get(*gin.Context)
+}
+
+type c4IndexController struct {
+	c4IndexService services.C4IndexService
+}
+
+func NewC4IndexController(c4IndexService services.C4IndexService) C4IndexController {
+	return &c4IndexController{
+		c4IndexService: c4IndexService,
+	}
+}
+
+func (cc c4IndexController) Get(c *gin.Context) {
+	logger.Debug("Processing C4 Id get request", request.LogField(c))
+	locations, err := cc.c4IndexService.Get(c.Param("c4_id"), auth.GetCaller(c))
+	if err != nil {
+		logger.Error("Service error while getting C4 Id locations", err, request.LogField(c))
+		request.Fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, locations)
+	logger.Debug("Done processing C4 Id get request", request.LogField(c))
+}
@@ -0,0 +1,30 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/request"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+type ConfigController interface {
+	Get(*gin.Context)
+}
+
+type configController struct {
+	cfg *config.AppConfig
+}
+
+func NewConfigController(cfg *config.AppConfig) ConfigController {
+	return &configController{
+		cfg: cfg,
+	}
+}
+
+func (cc *configController) Get(c *gin.Context) {
+	logger.Debug("Processing config get request", request.LogField(c))
+	c.JSON(http.StatusOK, cc.cfg.Effective())
+	logger.Debug("Done processing config get request", request.LogField(c))
+}
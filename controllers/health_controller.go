@@ -0,0 +1,46 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/request"
+	"github.com/johannes-kuhfuss/c4svc/services"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+type HealthController interface {
+	Live(*gin.Context)
+	Ready(*gin.Context)
+}
+
+type healthController struct {
+	healthService services.HealthService
+}
+
+func NewHealthController(healthService services.HealthService) HealthController {
+	return &healthController{
+		healthService: healthService,
+	}
+}
+
+func (hc *healthController) Live(c *gin.Context) {
+	logger.Debug("Processing liveness get request", request.LogField(c))
+	writeHealthReport(c, hc.healthService.Live())
+	logger.Debug("Done processing liveness get request", request.LogField(c))
+}
+
+func (hc *healthController) Ready(c *gin.Context) {
+	logger.Debug("Processing readiness get request", request.LogField(c))
+	writeHealthReport(c, hc.healthService.Ready())
+	logger.Debug("Done processing readiness get request", request.LogField(c))
+}
+
+func writeHealthReport(c *gin.Context, report domain.HealthReport) {
+	if report.Up() {
+		c.JSON(http.StatusOK, report)
+	} else {
+		c.JSON(http.StatusServiceUnavailable, report)
+	}
+}
@@ -1,156 +1,213 @@
 package controllers
 
 import (
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/johannes-kuhfuss/c4svc/auth"
 	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/request"
 	"github.com/johannes-kuhfuss/c4svc/services"
 	"github.com/johannes-kuhfuss/services_utils/api_error"
 	"github.com/johannes-kuhfuss/services_utils/logger"
 	"github.com/segmentio/ksuid"
 )
 
-var (
-	JobController jobControllerInterface = &jobController{}
+const (
+	idempotencyKeyHeader     = "Idempotency-Key"
+	idempotentReplayedHeader = "Idempotent-Replayed"
 )
 
-type jobControllerInterface interface {
+type JobController interface {
 	Create(*gin.Context)
 	Get(*gin.Context)
 	Delete(*gin.Context)
+	Retry(*gin.Context)
 	Update(*gin.Context)
 	UpdatePart(*gin.Context)
 	GetAll(*gin.Context)
 }
 
 type jobController struct {
+	jobService services.JobService
 }
 
-func getJobId(jobIdParam string) (string, api_error.ApiErr) {
-	jobId, err := ksuid.Parse(jobIdParam)
+func NewJobController(jobService services.JobService) JobController {
+	return &jobController{
+		jobService: jobService,
+	}
+}
+
+func getJobId(c *gin.Context) (string, api_error.ApiErr) {
+	jobId, err := ksuid.Parse(c.Param("job_id"))
 	if err != nil {
-		logger.Error("User Id should be a ksuid", err)
-		return "", api_error.NewBadRequestError("user id should be a ksuid")
+		logger.Error("Job Id should be a ksuid", err, request.LogField(c))
+		return "", domain.WithCode(domain.CodeInvalidJobId, api_error.NewBadRequestError("job Id should be a ksuid"))
 	}
 	return jobId.String(), nil
 }
 
+// invalidJsonError describes why the request body could not be read, naming the field if the
+// body has a value of the wrong type
+func invalidJsonError(err error) api_error.ApiErr {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) && typeErr.Field != "" {
+		return domain.NewValidationError([]domain.FieldError{{
+			Field:   typeErr.Field,
+			Code:    domain.CodeInvalidJson,
+			Message: fmt.Sprintf("%v should be of type %v", typeErr.Field, typeErr.Type),
+		}})
+	}
+	return domain.WithCode(domain.CodeInvalidJson, api_error.NewBadRequestError("invalid json body"))
+}
+
 func (jc jobController) Create(c *gin.Context) {
-	logger.Debug("Processing job create request")
+	logger.Debug("Processing job create request", request.LogField(c))
 	var newJob domain.Job
 	if err := c.ShouldBindJSON(&newJob); err != nil {
-		logger.Error("invalid JSON body in create request", err)
-		apiErr := api_error.NewBadRequestError("invalid json body")
-		c.JSON(apiErr.StatusCode(), apiErr)
+		logger.Error("invalid JSON body in create request", err, request.LogField(c))
+		request.Fail(c, invalidJsonError(err))
 		return
 	}
+	newJob.RequestId = request.GetId(c)
+	newJob.TraceContext = domain.NewTraceContext(c.Request.Context())
 
-	result, err := services.JobService.Create(newJob)
+	result, existing, err := jc.jobService.CreateIdempotent(newJob, c.GetHeader(idempotencyKeyHeader), auth.GetCaller(c))
 	if err != nil {
-		logger.Error("Service error while creating job", err)
-		c.JSON(err.StatusCode(), err)
+		logger.Error("Service error while creating job", err, request.LogField(c))
+		setRetryAfter(c, err)
+		request.Fail(c, err)
 		return
 	}
-	c.JSON(http.StatusCreated, result)
-	logger.Debug("Done processing job create request")
+	if existing {
+		c.Header(idempotentReplayedHeader, "true")
+		c.JSON(http.StatusOK, result)
+	} else {
+		c.JSON(http.StatusCreated, result)
+	}
+	logger.Debug("Done processing job create request", request.LogField(c))
 }
 
 func (jc jobController) Get(c *gin.Context) {
-	logger.Debug("Processing job get request")
-	jobId, err := getJobId(c.Param("job_id"))
+	logger.Debug("Processing job get request", request.LogField(c))
+	jobId, err := getJobId(c)
 	if err != nil {
-		c.JSON(err.StatusCode(), err)
+		request.Fail(c, err)
 		return
 	}
-	job, err := services.JobService.Get(jobId)
+	job, err := jc.jobService.Get(jobId, auth.GetCaller(c))
 	if err != nil {
-		logger.Error("Service error while getting job", err)
-		c.JSON(err.StatusCode(), err)
+		logger.Error("Service error while getting job", err, request.LogField(c))
+		request.Fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, job)
-	logger.Debug("Done processing job get request")
+	logger.Debug("Done processing job get request", request.LogField(c))
 }
 
 func (jc jobController) Delete(c *gin.Context) {
-	logger.Debug("Processing job delete request")
-	jobId, err := getJobId(c.Param("job_id"))
+	logger.Debug("Processing job delete request", request.LogField(c))
+	jobId, err := getJobId(c)
 	if err != nil {
-		c.JSON(err.StatusCode(), err)
+		request.Fail(c, err)
 		return
 	}
-	err = services.JobService.Delete(jobId)
+	err = jc.jobService.Delete(jobId, auth.GetCaller(c))
 	if err != nil {
-		logger.Error("Service error while deleting job", err)
-		c.JSON(err.StatusCode(), err)
+		logger.Error("Service error while deleting job", err, request.LogField(c))
+		request.Fail(c, err)
 		return
 	}
 	c.String(http.StatusNoContent, "")
-	logger.Debug("Done processing job delete request")
+	logger.Debug("Done processing job delete request", request.LogField(c))
 }
 
-func validateUpdate(c *gin.Context) (id string, job domain.Job, err api_error.ApiErr) {
-	logger.Debug("Validating update")
+func (jc jobController) Retry(c *gin.Context) {
+	logger.Debug("Processing job retry request", request.LogField(c))
+	jobId, err := getJobId(c)
+	if err != nil {
+		request.Fail(c, err)
+		return
+	}
+	job, err := jc.jobService.Retry(jobId, auth.GetCaller(c))
+	if err != nil {
+		logger.Error("Service error while retrying job", err, request.LogField(c))
+		setRetryAfter(c, err)
+		request.Fail(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, job)
+	logger.Debug("Done processing job retry request", request.LogField(c))
+}
+
+func validateUpdate(c *gin.Context) (id string, job domain.Job, flags domain.JobFlags, err api_error.ApiErr) {
+	logger.Debug("Validating update", request.LogField(c))
 	var inputJob domain.Job
-	if err := c.ShouldBindJSON(&inputJob); err != nil {
-		apiErr := api_error.NewBadRequestError("invalid json body")
-		return "", inputJob, apiErr
+	if err := c.ShouldBindBodyWith(&inputJob, binding.JSON); err != nil {
+		return "", inputJob, flags, invalidJsonError(err)
+	}
+	// bound separately to tell flags set to false from flags missing in a partial update
+	if err := c.ShouldBindBodyWith(&flags, binding.JSON); err != nil {
+		return "", inputJob, flags, invalidJsonError(err)
 	}
-	jobId, err := getJobId(c.Param("job_id"))
+	jobId, err := getJobId(c)
 	if err != nil {
-		return "", inputJob, err
+		return "", inputJob, flags, err
 	}
-	logger.Debug("Done validating update")
-	return jobId, inputJob, nil
+	logger.Debug("Done validating update", request.LogField(c))
+	return jobId, inputJob, flags, nil
 }
 
 func (jc jobController) Update(c *gin.Context) {
-	logger.Debug("Processing job full update request")
+	logger.Debug("Processing job full update request", request.LogField(c))
 	partial := false
-	jobId, inputJob, err := validateUpdate(c)
+	jobId, inputJob, flags, err := validateUpdate(c)
 	if err != nil {
-		logger.Error("Error while validating full job update", err)
-		c.JSON(err.StatusCode(), err)
+		logger.Error("Error while validating full job update", err, request.LogField(c))
+		request.Fail(c, err)
 		return
 	}
-	result, err := services.JobService.Update(jobId, inputJob, partial)
+	result, err := jc.jobService.Update(jobId, inputJob, partial, flags, auth.GetCaller(c))
 	if err != nil {
-		logger.Error("Service error while updating full job", err)
-		c.JSON(err.StatusCode(), err)
+		logger.Error("Service error while updating full job", err, request.LogField(c))
+		request.Fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, result)
-	logger.Debug("Done processing job full update request")
+	logger.Debug("Done processing job full update request", request.LogField(c))
 }
 
 func (jc jobController) UpdatePart(c *gin.Context) {
-	logger.Debug("Processing job partial update request")
+	logger.Debug("Processing job partial update request", request.LogField(c))
 	partial := true
-	jobId, inputJob, err := validateUpdate(c)
+	jobId, inputJob, flags, err := validateUpdate(c)
 	if err != nil {
-		logger.Error("Error while validating partial job update", err)
-		c.JSON(err.StatusCode(), err)
+		logger.Error("Error while validating partial job update", err, request.LogField(c))
+		request.Fail(c, err)
 		return
 	}
-	result, err := services.JobService.Update(jobId, inputJob, partial)
+	result, err := jc.jobService.Update(jobId, inputJob, partial, flags, auth.GetCaller(c))
 	if err != nil {
-		logger.Error("Service error while updating partial job", err)
-		c.JSON(err.StatusCode(), err)
+		logger.Error("Service error while updating partial job", err, request.LogField(c))
+		request.Fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, result)
-	logger.Debug("Done processing job partial update request")
+	logger.Debug("Done processing job partial update request", request.LogField(c))
 }
 
 func (jc jobController) GetAll(c *gin.Context) {
-	logger.Debug("Processing job get all request")
-	jobs, err := services.JobService.GetAll()
+	logger.Debug("Processing job get all request", request.LogField(c))
+	jobs, err := jc.jobService.GetAll(auth.GetCaller(c))
 	if err != nil {
-		logger.Error("Service error while getting all jobs", err)
-		c.JSON(err.StatusCode(), err)
+		logger.Error("Service error while getting all jobs", err, request.LogField(c))
+		request.Fail(c, err)
 		return
 	}
 	c.JSON(http.StatusOK, jobs)
-	logger.Debug("Done processing job get request")
+	logger.Debug("Done processing job get request", request.LogField(c))
 }
@@ -0,0 +1,129 @@
+package controllers
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johannes-kuhfuss/c4svc/auth"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/request"
+	"github.com/johannes-kuhfuss/c4svc/services"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+const (
+	manifestFormatText = "text"
+)
+
+type ManifestController interface {
+	Get(*gin.Context)
+	Write(*gin.Context)
+}
+
+type manifestController struct {
+	manifestService services.ManifestService
+}
+
+func NewManifestController(manifestService services.ManifestService) ManifestController {
+	return &manifestController{
+		manifestService: manifestService,
+	}
+}
+
+func getTimeParam(c *gin.Context, name string) (*time.Time, api_error.ApiErr) {
+	value := strings.TrimSpace(c.Query(name))
+	if value == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		logger.Error("Invalid time in manifest request", err, request.LogField(c))
+		return nil, domain.NewValidationError([]domain.FieldError{{
+			Field:   name,
+			Code:    domain.CodeInvalidParameter,
+			Message: name + " should be a RFC3339 time",
+		}})
+	}
+	return &t, nil
+}
+
+func getJobFilter(c *gin.Context) (*domain.JobFilter, api_error.ApiErr) {
+	filter := domain.JobFilter{
+		SrcPrefix: c.Query("src_prefix"),
+		DstPrefix: c.Query("dst_prefix"),
+	}
+	for _, ids := range c.QueryArray("ids") {
+		for _, id := range strings.Split(ids, ",") {
+			if strings.TrimSpace(id) != "" {
+				filter.Ids = append(filter.Ids, strings.TrimSpace(id))
+			}
+		}
+	}
+	from, err := getTimeParam(c, "from")
+	if err != nil {
+		return nil, err
+	}
+	to, err := getTimeParam(c, "to")
+	if err != nil {
+		return nil, err
+	}
+	filter.From = from
+	filter.To = to
+	return &filter, nil
+}
+
+func (mc manifestController) Get(c *gin.Context) {
+	logger.Debug("Processing manifest get request", request.LogField(c))
+	filter, err := getJobFilter(c)
+	if err != nil {
+		request.Fail(c, err)
+		return
+	}
+	// getting a manifest has no side effects, it is written with a POST
+	if _, ok := c.GetQuery("write"); ok {
+		request.Fail(c, domain.NewValidationError([]domain.FieldError{{
+			Field:   "write",
+			Code:    domain.CodeInvalidParameter,
+			Message: "write is not supported, write a manifest with POST /jobs/manifest",
+		}}))
+		return
+	}
+	manifest, err := mc.manifestService.Create(*filter, auth.GetCaller(c))
+	if err != nil {
+		logger.Error("Service error while creating manifest", err, request.LogField(c))
+		request.Fail(c, err)
+		return
+	}
+	respondManifest(c, http.StatusOK, manifest)
+	logger.Debug("Done processing manifest get request", request.LogField(c))
+}
+
+// Write creates the manifest like Get and writes it to the configured location
+func (mc manifestController) Write(c *gin.Context) {
+	logger.Debug("Processing manifest write request", request.LogField(c))
+	filter, err := getJobFilter(c)
+	if err != nil {
+		request.Fail(c, err)
+		return
+	}
+	manifest, err := mc.manifestService.Write(*filter, auth.GetCaller(c))
+	if err != nil {
+		logger.Error("Service error while writing manifest", err, request.LogField(c))
+		request.Fail(c, err)
+		return
+	}
+	c.Header("Location", manifest.Location)
+	respondManifest(c, http.StatusCreated, manifest)
+	logger.Debug("Done processing manifest write request", request.LogField(c))
+}
+
+func respondManifest(c *gin.Context, status int, manifest *domain.Manifest) {
+	if c.Query("format") == manifestFormatText {
+		c.String(status, manifest.Text())
+	} else {
+		c.JSON(status, manifest)
+	}
+}
@@ -0,0 +1,35 @@
+package controllers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestContext(target string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodGet, target, nil)
+	return c
+}
+
+func TestGetJobFilterNoError(t *testing.T) {
+	c := newTestContext("/jobs/manifest?ids=a,b&ids=c&src_prefix=https://server/&from=2021-10-15T15:00:00Z")
+	filter, err := getJobFilter(c)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []string{"a", "b", "c"}, filter.Ids)
+	assert.EqualValues(t, "https://server/", filter.SrcPrefix)
+	assert.NotNil(t, filter.From)
+	assert.Nil(t, filter.To)
+}
+
+func TestGetJobFilterInvalidTime(t *testing.T) {
+	c := newTestContext("/jobs/manifest?to=yesterday")
+	filter, err := getJobFilter(c)
+	assert.Nil(t, filter)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, "to should be a RFC3339 time", err.Message())
+}
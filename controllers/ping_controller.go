@@ -4,6 +4,7 @@ import (
 	"net/http"
 
 	"github.com/gin-gonic/gin"
+	"github.com/johannes-kuhfuss/c4svc/request"
 	"github.com/johannes-kuhfuss/services_utils/logger"
 )
 
@@ -11,19 +12,19 @@ const (
 	pong = "pong"
 )
 
-var (
-	PingController pingControllerInterface = &pingController{}
-)
-
-type pingControllerInterface interface {
+type PingController interface {
 	Pong(*gin.Context)
 }
 
 type pingController struct {
 }
 
+func NewPingController() PingController {
+	return &pingController{}
+}
+
 func (pc *pingController) Pong(c *gin.Context) {
-	logger.Debug("Processing ping get request")
+	logger.Debug("Processing ping get request", request.LogField(c))
 	c.String(http.StatusOK, pong)
-	logger.Debug("Done processing ping get request")
+	logger.Debug("Done processing ping get request", request.LogField(c))
 }
@@ -0,0 +1,65 @@
+package controllers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johannes-kuhfuss/c4svc/auth"
+	"github.com/johannes-kuhfuss/c4svc/request"
+	"github.com/johannes-kuhfuss/c4svc/services"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+const (
+	retryAfterHeader = "Retry-After"
+)
+
+type QuotaController interface {
+	Limit(*gin.Context)
+	Get(*gin.Context)
+}
+
+type quotaController struct {
+	quotaService services.QuotaService
+}
+
+func NewQuotaController(quotaService services.QuotaService) QuotaController {
+	return &quotaController{
+		quotaService: quotaService,
+	}
+}
+
+// Limit rate limits requests per authenticated caller, or per client IP for anonymous callers.
+// The client IP is only taken from X-Forwarded-For when the request comes from a trusted proxy.
+func (qc *quotaController) Limit(c *gin.Context) {
+	client := "ip:" + c.ClientIP()
+	if caller := auth.GetCaller(c); caller.Name != "" {
+		client = caller.Name
+	}
+	if err := qc.quotaService.Allow(client); err != nil {
+		setRetryAfter(c, err)
+		request.Fail(c, err)
+		return
+	}
+	c.Next()
+}
+
+func (qc *quotaController) Get(c *gin.Context) {
+	logger.Debug("Processing quota get request", request.LogField(c))
+	c.JSON(http.StatusOK, qc.quotaService.Report())
+	logger.Debug("Done processing quota get request", request.LogField(c))
+}
+
+// setRetryAfter tells the client when to repeat a request that failed with a temporary error
+func setRetryAfter(c *gin.Context, err api_error.ApiErr) {
+	if retryErr, ok := err.(services.RetryAfterErr); ok {
+		seconds := int(math.Ceil(retryErr.RetryAfter().Seconds()))
+		if seconds < 1 {
+			seconds = 1
+		}
+		c.Header(retryAfterHeader, strconv.Itoa(seconds))
+	}
+}
@@ -0,0 +1,72 @@
+package domain
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/date"
+)
+
+type BatchDao interface {
+	Get(string) (*Batch, api_error.ApiErr)
+	Save(Batch) api_error.ApiErr
+	CleanBatches(time.Duration, func(string) bool) int
+}
+
+type batchDao struct {
+	list map[string]*Batch
+	mu   sync.Mutex
+}
+
+func NewBatchDao() BatchDao {
+	return &batchDao{
+		list: make(map[string]*Batch),
+	}
+}
+
+func (bd *batchDao) Get(batchId string) (*Batch, api_error.ApiErr) {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+	if batch := bd.list[batchId]; batch != nil {
+		return batch, nil
+	}
+	return nil, WithCode(CodeBatchNotFound, api_error.NewNotFoundError(fmt.Sprintf("batch with Id %v does not exist", batchId)))
+}
+
+func (bd *batchDao) Save(batch Batch) api_error.ApiErr {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+	if _, ok := bd.list[batch.Id]; ok {
+		return WithCode(CodeBatchExists, api_error.NewBadRequestError(fmt.Sprintf("batch with Id %v already exists", batch.Id)))
+	}
+	bd.list[batch.Id] = &batch
+	return nil
+}
+
+// CleanBatches removes batches older than age once all of their jobs are done, as reported by done for the job Id.
+// Batches with jobs still waiting or running are kept, so their progress can still be followed.
+func (bd *batchDao) CleanBatches(age time.Duration, done func(string) bool) int {
+	bd.mu.Lock()
+	defer bd.mu.Unlock()
+	delBatchCounter := 0
+	now := date.GetNowUtc()
+	for k, v := range bd.list {
+		createDate, err := time.Parse(date.ApiDateLayout, v.CreatedAt)
+		if (err != nil || createDate.Add(age).Before(now)) && v.jobsDone(done) {
+			delete(bd.list, k)
+			delBatchCounter++
+		}
+	}
+	return delBatchCounter
+}
+
+func (batch *Batch) jobsDone(done func(string) bool) bool {
+	for _, jobId := range batch.JobIds {
+		if !done(jobId) {
+			return false
+		}
+	}
+	return true
+}
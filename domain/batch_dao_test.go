@@ -0,0 +1,78 @@
+package domain
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/johannes-kuhfuss/services_utils/date"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBatchGetNotFound(t *testing.T) {
+	bd := NewBatchDao()
+	batch, err := bd.Get("X")
+	assert.Nil(t, batch)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+	assert.EqualValues(t, "batch with Id X does not exist", err.Message())
+}
+
+func allDone(string) bool {
+	return true
+}
+
+func TestBatchSaveExists(t *testing.T) {
+	bd := NewBatchDao()
+	batch := Batch{Id: "batch 1", CreatedAt: date.GetNowUtcString()}
+	assert.Nil(t, bd.Save(batch))
+	err := bd.Save(batch)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, "batch with Id batch 1 already exists", err.Message())
+	assert.EqualValues(t, 1, bd.CleanBatches(-time.Hour, allDone))
+}
+
+func TestBatchCleanOld(t *testing.T) {
+	bd := NewBatchDao()
+	assert.Nil(t, bd.Save(Batch{Id: "batch 2", CreatedAt: date.GetNowUtcString()}))
+	assert.Nil(t, bd.Save(Batch{Id: "batch 3", CreatedAt: date.GetNowUtc().Add(-2 * time.Hour).Format(date.ApiDateLayout)}))
+	assert.EqualValues(t, 1, bd.CleanBatches(time.Hour, allDone))
+	batch, err := bd.Get("batch 2")
+	assert.Nil(t, err)
+	assert.EqualValues(t, "batch 2", batch.Id)
+	assert.EqualValues(t, 1, bd.CleanBatches(-time.Hour, allDone))
+}
+
+func TestBatchCleanKeepsUnfinishedJobs(t *testing.T) {
+	bd := NewBatchDao()
+	old := date.GetNowUtc().Add(-2 * time.Hour).Format(date.ApiDateLayout)
+	assert.Nil(t, bd.Save(Batch{Id: "batch 5", CreatedAt: old, JobIds: []string{"job 1", "job 2"}}))
+	assert.Nil(t, bd.Save(Batch{Id: "batch 6", CreatedAt: old, JobIds: []string{"job 1", "job 3"}}))
+	running := map[string]bool{"job 2": true}
+	done := func(jobId string) bool {
+		return !running[jobId]
+	}
+	assert.EqualValues(t, 1, bd.CleanBatches(time.Hour, done))
+	_, err := bd.Get("batch 5")
+	assert.Nil(t, err)
+	_, err = bd.Get("batch 6")
+	assert.NotNil(t, err)
+	running["job 2"] = false
+	assert.EqualValues(t, 1, bd.CleanBatches(time.Hour, done))
+}
+
+func TestBatchSummarize(t *testing.T) {
+	batch := Batch{Id: "batch 4", JobIds: []string{"job 1", "job 2", "job 3", "job 4"}}
+	summary := batch.Summarize(map[string]JobStatus{
+		"job 1": JobStatusFinished,
+		"job 2": JobStatusRunning,
+		"job 3": JobStatusFailed,
+	})
+	assert.EqualValues(t, 4, summary.Total)
+	assert.EqualValues(t, 1, summary.Status[JobStatusFinished])
+	assert.EqualValues(t, 1, summary.Status[JobStatusRunning])
+	assert.EqualValues(t, 1, summary.Status[JobStatusFailed])
+	assert.EqualValues(t, 1, summary.Status[BatchStatusRemoved])
+	assert.EqualValues(t, 0.75, summary.Progress)
+}
@@ -0,0 +1,62 @@
+package domain
+
+const (
+	BatchStatusRemoved = "Removed"
+)
+
+type Batch struct {
+	Id        string   `json:"id"`
+	CreatedAt string   `json:"created_at"`
+	CreatedBy string   `json:"created_by"`
+	JobIds    []string `json:"job_ids"`
+}
+
+type BatchItem struct {
+	Index int      `json:"index"`
+	Job   *Job     `json:"job,omitempty"`
+	Error *Problem `json:"error,omitempty"`
+}
+
+type BatchResult struct {
+	Id      string      `json:"id"`
+	Created int         `json:"created"`
+	Failed  int         `json:"failed"`
+	Items   []BatchItem `json:"items"`
+}
+
+type BatchSummary struct {
+	Id        string         `json:"id"`
+	CreatedAt string         `json:"created_at"`
+	Total     int            `json:"total"`
+	Status    map[string]int `json:"status"`
+	Progress  float64        `json:"progress"`
+}
+
+// Summarize counts the batch's jobs by status. Jobs that are no longer in the job list have
+// been cleaned up after finishing or failing and count as removed. Progress is the share of
+// jobs that are done, regardless of their outcome.
+func (b *Batch) Summarize(jobs map[string]JobStatus) BatchSummary {
+	summary := BatchSummary{
+		Id:        b.Id,
+		CreatedAt: b.CreatedAt,
+		Total:     len(b.JobIds),
+		Status:    make(map[string]int),
+	}
+	done := 0
+	for _, jobId := range b.JobIds {
+		status, ok := jobs[jobId]
+		if !ok {
+			summary.Status[BatchStatusRemoved]++
+			done++
+			continue
+		}
+		summary.Status[string(status)]++
+		if status == JobStatusFinished || status == JobStatusFailed || status == JobStatusPartial {
+			done++
+		}
+	}
+	if summary.Total > 0 {
+		summary.Progress = float64(done) / float64(summary.Total)
+	}
+	return summary
+}
@@ -0,0 +1,86 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+)
+
+type C4IndexDao interface {
+	Add(C4IndexEntry) api_error.ApiErr
+	Get(string) (*C4Locations, api_error.ApiErr)
+	CleanEntries(func(string) bool) int
+}
+
+type c4IndexDao struct {
+	list map[string][]C4IndexEntry
+	mu   sync.Mutex
+}
+
+func NewC4IndexDao() C4IndexDao {
+	return &c4IndexDao{
+		list: make(map[string][]C4IndexEntry),
+	}
+}
+
+// Add records where content with a C4 Id has been seen. A location that the same user
+// already recorded for the C4 Id is replaced by the newer entry.
+func (cd *c4IndexDao) Add(entry C4IndexEntry) api_error.ApiErr {
+	if strings.TrimSpace(entry.FileC4Id) == "" {
+		return WithCode(CodeInvalidC4Id, api_error.NewBadRequestError("invalid C4 Id"))
+	}
+	if strings.TrimSpace(entry.Url) == "" {
+		return WithCode(CodeInvalidSourceUrl, api_error.NewBadRequestError("invalid URL"))
+	}
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	entries := cd.list[entry.FileC4Id]
+	for i, v := range entries {
+		if v.Url == entry.Url && v.CreatedBy == entry.CreatedBy {
+			entries[i] = entry
+			return nil
+		}
+	}
+	cd.list[entry.FileC4Id] = append(entries, entry)
+	return nil
+}
+
+func (cd *c4IndexDao) Get(c4Id string) (*C4Locations, api_error.ApiErr) {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	entries := cd.list[c4Id]
+	if len(entries) == 0 {
+		return nil, WithCode(CodeC4IdNotFound, api_error.NewNotFoundError(fmt.Sprintf("C4 Id %v has not been seen", c4Id)))
+	}
+	locations := C4Locations{
+		FileC4Id:  c4Id,
+		Locations: make([]C4IndexEntry, len(entries)),
+	}
+	copy(locations.Locations, entries)
+	return &locations, nil
+}
+
+// CleanEntries removes the entries of jobs that no longer exist, as reported by exists for the job Id
+func (cd *c4IndexDao) CleanEntries(exists func(string) bool) int {
+	cd.mu.Lock()
+	defer cd.mu.Unlock()
+	delEntryCounter := 0
+	for c4Id, entries := range cd.list {
+		kept := entries[:0]
+		for _, entry := range entries {
+			if exists(entry.JobId) {
+				kept = append(kept, entry)
+			} else {
+				delEntryCounter++
+			}
+		}
+		if len(kept) == 0 {
+			delete(cd.list, c4Id)
+		} else {
+			cd.list[c4Id] = kept
+		}
+	}
+	return delEntryCounter
+}
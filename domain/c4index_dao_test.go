@@ -0,0 +1,78 @@
+package domain
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	indexEntry1 C4IndexEntry = C4IndexEntry{
+		FileC4Id: "c4abc",
+		Url:      "http://server1/path1/file1.ext",
+		SrcUrl:   "http://server1/path1/file1.ext",
+		Size:     12,
+		JobId:    "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+	}
+	indexEntry2 C4IndexEntry = C4IndexEntry{
+		FileC4Id: "c4abc",
+		Url:      "http://server2/path2/file2.ext",
+		SrcUrl:   "http://server2/path2/file2.ext",
+		Size:     12,
+		JobId:    "1zXgBZNnBG1msmF1ARQK9ZphbcO",
+	}
+)
+
+func TestC4IndexGetNotFound(t *testing.T) {
+	cd := NewC4IndexDao()
+	locations, err := cd.Get("c4xyz")
+	assert.Nil(t, locations)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+	assert.EqualValues(t, "C4 Id c4xyz has not been seen", err.Message())
+}
+
+func TestC4IndexAddInvalidEntry(t *testing.T) {
+	cd := NewC4IndexDao()
+	err := cd.Add(C4IndexEntry{Url: "http://server1/path1/file1.ext"})
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, "invalid C4 Id", err.Message())
+	err = cd.Add(C4IndexEntry{FileC4Id: "c4abc"})
+	assert.NotNil(t, err)
+	assert.EqualValues(t, "invalid URL", err.Message())
+}
+
+func TestC4IndexAddDuplicates(t *testing.T) {
+	cd := NewC4IndexDao()
+	assert.Nil(t, cd.Add(indexEntry1))
+	assert.Nil(t, cd.Add(indexEntry2))
+	newer := indexEntry1
+	newer.JobId = "1zXgBZNnBG1msmF1ARQK9ZphbdO"
+	assert.Nil(t, cd.Add(newer))
+	locations, err := cd.Get(indexEntry1.FileC4Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, len(locations.Locations))
+	assert.EqualValues(t, newer.JobId, locations.Locations[0].JobId)
+	assert.EqualValues(t, indexEntry2.Url, locations.Locations[1].Url)
+}
+
+func TestC4IndexCleanEntries(t *testing.T) {
+	cd := NewC4IndexDao()
+	assert.Nil(t, cd.Add(indexEntry1))
+	assert.Nil(t, cd.Add(indexEntry2))
+	other := indexEntry1
+	other.FileC4Id = "c4def"
+	assert.Nil(t, cd.Add(other))
+	exists := func(jobId string) bool {
+		return jobId == indexEntry2.JobId
+	}
+	assert.EqualValues(t, 2, cd.CleanEntries(exists))
+	locations, err := cd.Get(indexEntry1.FileC4Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(locations.Locations))
+	assert.EqualValues(t, indexEntry2.JobId, locations.Locations[0].JobId)
+	_, err = cd.Get(other.FileC4Id)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,17 @@
+package domain
+
+type C4IndexEntry struct {
+	FileC4Id  string `json:"file_c4_id"`
+	Url       string `json:"url"`
+	SrcUrl    string `json:"src_url"`
+	DstUrl    string `json:"dst_url"`
+	Size      int64  `json:"size"`
+	JobId     string `json:"job_id"`
+	CreatedBy string `json:"created_by"`
+	SeenAt    string `json:"seen_at"`
+}
+
+type C4Locations struct {
+	FileC4Id  string         `json:"file_c4_id"`
+	Locations []C4IndexEntry `json:"locations"`
+}
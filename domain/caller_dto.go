@@ -0,0 +1,42 @@
+package domain
+
+const (
+	RoleSubmitter = "submitter"
+	RoleViewer    = "viewer"
+	RoleAdmin     = "admin"
+)
+
+var (
+	Roles = []string{RoleSubmitter, RoleViewer, RoleAdmin}
+)
+
+// Caller identifies the authenticated client of a request. If authentication is disabled,
+// the caller has no name and the admin role.
+type Caller struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+// HasRole reports whether the caller has one of the roles. Admins have every role.
+func (c Caller) HasRole(roles ...string) bool {
+	for _, own := range c.Roles {
+		if own == RoleAdmin {
+			return true
+		}
+		for _, role := range roles {
+			if own == role {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func IsRole(role string) bool {
+	for _, known := range Roles {
+		if role == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallerHasRole(t *testing.T) {
+	viewer := Caller{Name: "alice", Roles: []string{RoleViewer}}
+	assert.True(t, viewer.HasRole(RoleViewer))
+	assert.True(t, viewer.HasRole(RoleSubmitter, RoleViewer))
+	assert.False(t, viewer.HasRole(RoleSubmitter))
+	assert.False(t, viewer.HasRole(RoleAdmin))
+	admin := Caller{Name: "bob", Roles: []string{RoleAdmin}}
+	assert.True(t, admin.HasRole(RoleSubmitter))
+	assert.False(t, Caller{}.HasRole(RoleViewer))
+}
+
+func TestIsRole(t *testing.T) {
+	assert.True(t, IsRole("submitter"))
+	assert.False(t, IsRole("superuser"))
+}
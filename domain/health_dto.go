@@ -0,0 +1,33 @@
+package domain
+
+const (
+	HealthStatusUp   = "up"
+	HealthStatusDown = "down"
+)
+
+type HealthCheck struct {
+	Status   string `json:"status"`
+	Message  string `json:"message,omitempty"`
+	LastSeen string `json:"last_seen,omitempty"`
+}
+
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]HealthCheck `json:"checks"`
+}
+
+func (r *HealthReport) Add(name string, check HealthCheck) {
+	if r.Checks == nil {
+		r.Checks = make(map[string]HealthCheck)
+	}
+	r.Checks[name] = check
+	if check.Status != HealthStatusUp {
+		r.Status = HealthStatusDown
+	} else if r.Status == "" {
+		r.Status = HealthStatusUp
+	}
+}
+
+func (r *HealthReport) Up() bool {
+	return r.Status == HealthStatusUp
+}
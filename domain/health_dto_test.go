@@ -0,0 +1,17 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthReportAdd(t *testing.T) {
+	report := HealthReport{}
+	report.Add("first", HealthCheck{Status: HealthStatusUp})
+	assert.True(t, report.Up())
+	report.Add("second", HealthCheck{Status: HealthStatusDown})
+	report.Add("third", HealthCheck{Status: HealthStatusUp})
+	assert.False(t, report.Up())
+	assert.EqualValues(t, 3, len(report.Checks))
+}
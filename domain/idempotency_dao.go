@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/date"
+)
+
+type IdempotencyDao interface {
+	Get(string, time.Duration) (*IdempotencyKey, api_error.ApiErr)
+	Save(IdempotencyKey) api_error.ApiErr
+	CleanKeys(time.Duration) int
+}
+
+type idempotencyDao struct {
+	list map[string]IdempotencyKey
+	mu   sync.Mutex
+}
+
+func NewIdempotencyDao() IdempotencyDao {
+	return &idempotencyDao{
+		list: make(map[string]IdempotencyKey),
+	}
+}
+
+// Get treats keys older than the retention as unknown, even if they were not cleaned yet
+func (id *idempotencyDao) Get(key string, retention time.Duration) (*IdempotencyKey, api_error.ApiErr) {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	if entry, ok := id.list[key]; ok && !entry.expired(retention, date.GetNowUtc()) {
+		return &entry, nil
+	}
+	return nil, WithCode(CodeIdempotencyKeyNotFound, api_error.NewNotFoundError(fmt.Sprintf("idempotency key %v does not exist", key)))
+}
+
+func (id *idempotencyDao) Save(entry IdempotencyKey) api_error.ApiErr {
+	if strings.TrimSpace(entry.Key) == "" {
+		return WithCode(CodeInvalidIdempotencyKey, api_error.NewBadRequestError("invalid idempotency key"))
+	}
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	id.list[entry.Key] = entry
+	return nil
+}
+
+func (id *idempotencyDao) CleanKeys(retention time.Duration) int {
+	id.mu.Lock()
+	defer id.mu.Unlock()
+	delKeyCounter := 0
+	now := date.GetNowUtc()
+	for k, v := range id.list {
+		if v.expired(retention, now) {
+			delete(id.list, k)
+			delKeyCounter++
+		}
+	}
+	return delKeyCounter
+}
+
+func (entry IdempotencyKey) expired(retention time.Duration, now time.Time) bool {
+	createDate, err := time.Parse(date.ApiDateLayout, entry.CreatedAt)
+	return err != nil || createDate.Add(retention).Before(now)
+}
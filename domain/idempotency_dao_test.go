@@ -0,0 +1,60 @@
+package domain
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/johannes-kuhfuss/services_utils/date"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdempotencyKeyGetNotFound(t *testing.T) {
+	id := NewIdempotencyDao()
+	entry, err := id.Get("key 1", time.Hour)
+	assert.Nil(t, entry)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+	assert.EqualValues(t, "idempotency key key 1 does not exist", err.Message())
+}
+
+func TestIdempotencyKeySaveInvalidKey(t *testing.T) {
+	id := NewIdempotencyDao()
+	err := id.Save(IdempotencyKey{Key: " "})
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, "invalid idempotency key", err.Message())
+}
+
+func TestIdempotencyKeySaveAndClean(t *testing.T) {
+	id := NewIdempotencyDao()
+	err := id.Save(IdempotencyKey{Key: "key 1", JobId: job1.Id, CreatedAt: date.GetNowUtcString()})
+	assert.Nil(t, err)
+	err = id.Save(IdempotencyKey{Key: "key 2", JobId: job2.Id, CreatedAt: date.GetNowUtc().Add(-2 * time.Hour).Format(date.ApiDateLayout)})
+	assert.Nil(t, err)
+	entry, err := id.Get("key 1", time.Hour)
+	assert.Nil(t, err)
+	assert.EqualValues(t, job1.Id, entry.JobId)
+	assert.EqualValues(t, 1, id.CleanKeys(time.Hour))
+	_, err = id.Get("key 2", time.Hour)
+	assert.NotNil(t, err)
+}
+
+func TestIdempotencyKeyGetExpired(t *testing.T) {
+	id := NewIdempotencyDao()
+	err := id.Save(IdempotencyKey{Key: "key 1", JobId: job1.Id, CreatedAt: date.GetNowUtc().Add(-2 * time.Hour).Format(date.ApiDateLayout)})
+	assert.Nil(t, err)
+	entry, err := id.Get("key 1", time.Hour)
+	assert.Nil(t, entry)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+	entry, err = id.Get("key 1", 3*time.Hour)
+	assert.Nil(t, err)
+	assert.EqualValues(t, job1.Id, entry.JobId)
+}
+
+func TestIdempotencyKeySameRequest(t *testing.T) {
+	entry := IdempotencyKey{Key: "key 1", SrcUrl: job1.SrcUrl, Type: job1.Type}
+	assert.True(t, entry.SameRequest(job1))
+	assert.False(t, entry.SameRequest(job2))
+}
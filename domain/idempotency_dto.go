@@ -0,0 +1,14 @@
+package domain
+
+type IdempotencyKey struct {
+	Key       string  `json:"key"`
+	JobId     string  `json:"job_id"`
+	SrcUrl    string  `json:"src_url"`
+	Type      JobType `json:"type"`
+	CreatedAt string  `json:"created_at"`
+}
+
+// SameRequest checks whether a job submitted with an already used key matches the original submission
+func (k *IdempotencyKey) SameRequest(job Job) bool {
+	return k.SrcUrl == job.SrcUrl && k.Type == job.Type
+}
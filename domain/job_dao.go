@@ -10,20 +10,7 @@ import (
 	"github.com/johannes-kuhfuss/services_utils/date"
 )
 
-var (
-	jobs = jobList{
-		list: make(map[string]*Job),
-		mu:   sync.Mutex{},
-	}
-	JobDao jobDaoInterface = &jobDao{}
-)
-
-type jobList struct {
-	list map[string]*Job
-	mu   sync.Mutex
-}
-
-type jobDaoInterface interface {
+type JobDao interface {
 	Get(string) (*Job, api_error.ApiErr)
 	Save(Job, bool) (*Job, api_error.ApiErr)
 	Delete(string) api_error.ApiErr
@@ -32,89 +19,119 @@ type jobDaoInterface interface {
 	CleanJobs(time.Duration, time.Duration) (int, api_error.ApiErr)
 	SetC4Id(string, string) api_error.ApiErr
 	SetDstUrl(string, string) api_error.ApiErr
-	SetErrMsg(string, string) api_error.ApiErr
+	SetErrMsg(string, string, string) api_error.ApiErr
+	SetFromMetadata(string, bool) api_error.ApiErr
+	SetFileInfo(string, int64, string) api_error.ApiErr
+	SetDigests(string, map[string]string) api_error.ApiErr
+	SetFilesTotal(string, int) api_error.ApiErr
+	AddFile(string, TreeFile) api_error.ApiErr
+	SetProgress(string, JobProgress) api_error.ApiErr
+	SetCheckpoint(string, *Checkpoint) api_error.ApiErr
 	GetAll() (*Jobs, api_error.ApiErr)
+	Stats() QueueStats
+	Ping() api_error.ApiErr
 }
 
-type jobDao struct{}
+type jobDao struct {
+	list map[string]*Job
+	mu   sync.Mutex
+}
 
-func addJob(newJob Job) {
-	jobs.mu.Lock()
-	defer jobs.mu.Unlock()
-	jobs.list[newJob.Id] = &newJob
+func NewJobDao() JobDao {
+	return &jobDao{
+		list: make(map[string]*Job),
+	}
 }
 
-func removeJob(delJob Job) {
-	jobs.mu.Lock()
-	defer jobs.mu.Unlock()
-	delete(jobs.list, delJob.Id)
+func notFound(jobId string) api_error.ApiErr {
+	return WithCode(CodeJobNotFound, api_error.NewNotFoundError(fmt.Sprintf("job with Id %v does not exist", jobId)))
 }
 
-func getJob(jobId string) (*Job, api_error.ApiErr) {
-	jobs.mu.Lock()
-	defer jobs.mu.Unlock()
-	if job := jobs.list[jobId]; job != nil {
-		return job, nil
+// update changes the stored job under the lock and marks it as modified, unless change fails.
+// Jobs handed out by the DAO are copies, so readers never see a job while it changes.
+func (jd *jobDao) update(jobId string, change func(*Job) api_error.ApiErr) api_error.ApiErr {
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
+	job := jd.list[jobId]
+	if job == nil {
+		return notFound(jobId)
+	}
+	if err := change(job); err != nil {
+		return err
 	}
-	err := api_error.NewNotFoundError(fmt.Sprintf("job with Id %v does not exist", jobId))
-	return nil, err
+	job.ModifiedAt = date.GetNowUtcString()
+	return nil
 }
 
 func (jd *jobDao) Get(jobId string) (*Job, api_error.ApiErr) {
-	getJob, err := getJob(jobId)
-	if err != nil {
-		return nil, err
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
+	if job := jd.list[jobId]; job != nil {
+		return job.clone(), nil
 	}
-	return getJob, nil
+	return nil, notFound(jobId)
 }
 
 func (jd *jobDao) Save(newJob Job, overwrite bool) (*Job, api_error.ApiErr) {
-	_, err := getJob(newJob.Id)
-	if err == nil && !overwrite {
-		err := api_error.NewBadRequestError(fmt.Sprintf("job with Id %v already exists", newJob.Id))
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
+	if _, ok := jd.list[newJob.Id]; ok && !overwrite {
+		err := WithCode(CodeJobExists, api_error.NewBadRequestError(fmt.Sprintf("job with Id %v already exists", newJob.Id)))
 		return nil, err
 	}
-	addJob(newJob)
+	jd.list[newJob.Id] = newJob.clone()
 	return &newJob, nil
 }
 
 func (jd *jobDao) Delete(jobId string) api_error.ApiErr {
-	delJob, _ := getJob(jobId)
-	if delJob != nil {
-		removeJob(*delJob)
-		return nil
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
+	if _, ok := jd.list[jobId]; !ok {
+		return notFound(jobId)
 	}
-	err := api_error.NewNotFoundError(fmt.Sprintf("job with Id %v does not exist", jobId))
-	return err
+	delete(jd.list, jobId)
+	return nil
 }
 
+// GetNext claims the job in status created that has been waiting longest by setting it to running, so that
+// concurrent job processors never pick up the same job. Retried and requeued jobs wait from when they were queued again.
 func (jd *jobDao) GetNext() (*Job, api_error.ApiErr) {
 	nextJobId := ""
-	nextJobDate := date.GetNowUtc()
-	if len(jobs.list) == 0 {
-		err := api_error.NewNotFoundError("no jobs in list")
+	var nextJobDate time.Time
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
+	if len(jd.list) == 0 {
+		err := WithCode(CodeJobNotFound, api_error.NewNotFoundError("no jobs in list"))
 		return nil, err
 	}
-	for _, v := range jobs.list {
+	for _, v := range jd.list {
 		if v.Status == JobStatusCreated {
-			curJobDate, _ := time.Parse(date.ApiDateLayout, v.CreatedAt)
-			if curJobDate.Before(nextJobDate) {
+			queuedAt := v.QueuedAt
+			if queuedAt == "" {
+				queuedAt = v.CreatedAt
+			}
+			curJobDate, _ := time.Parse(date.ApiDateLayout, queuedAt)
+			if nextJobId == "" || curJobDate.Before(nextJobDate) {
 				nextJobDate = curJobDate
 				nextJobId = v.Id
 			}
 		}
 	}
-	getJob, err := getJob(nextJobId)
-	if err != nil {
-		return nil, err
+	nextJob := jd.list[nextJobId]
+	if nextJob == nil {
+		return nil, notFound(nextJobId)
 	}
-	return getJob, nil
+	nextJob.Status = JobStatusRunning
+	nextJob.ModifiedAt = date.GetNowUtcString()
+	return nextJob.clone(), nil
 }
 
 func (jd *jobDao) ChangeStatus(jobId string, newStatus string) api_error.ApiErr {
-	getJob, err := getJob(jobId)
-	if err != nil {
-		return err
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
+	getJob := jd.list[jobId]
+	if getJob == nil {
+		return notFound(jobId)
 	}
 	newStatus = strings.ToLower(newStatus)
 	if strings.ToLower(string(getJob.Status)) == newStatus {
@@ -123,101 +140,163 @@ func (jd *jobDao) ChangeStatus(jobId string, newStatus string) api_error.ApiErr
 	switch newStatus {
 	case "created":
 		getJob.Status = JobStatusCreated
+		getJob.QueuedAt = date.GetNowUtcString()
 	case "running":
 		getJob.Status = JobStatusRunning
 	case "failed":
 		getJob.Status = JobStatusFailed
 	case "finished":
 		getJob.Status = JobStatusFinished
+	case "partial":
+		getJob.Status = JobStatusPartial
 	default:
-		retErr := api_error.NewBadRequestError("invalid status value")
+		retErr := WithCode(CodeInvalidJobStatus, api_error.NewBadRequestError("invalid status value"))
 		return retErr
 	}
 	getJob.ModifiedAt = date.GetNowUtcString()
-	_, saveErr := JobDao.Save(*getJob, true)
-	if saveErr != nil {
-		return saveErr
-	}
 	return nil
 }
 
 func (jd *jobDao) CleanJobs(finishedTime time.Duration, failedTime time.Duration) (int, api_error.ApiErr) {
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
 	delJobCounter := 0
-	if len(jobs.list) == 0 {
-		err := api_error.NewNotFoundError("no jobs in list")
+	if len(jd.list) == 0 {
+		err := WithCode(CodeJobNotFound, api_error.NewNotFoundError("no jobs in list"))
 		return 0, err
 	}
-	for _, v := range jobs.list {
-		now := date.GetNowUtc()
+	now := date.GetNowUtc()
+	for k, v := range jd.list {
 		modDate, err := time.Parse(date.ApiDateLayout, v.ModifiedAt)
 		if err != nil {
 			continue
 		}
-		if (v.Status == JobStatusFailed && modDate.Add(failedTime).Before(now)) || (v.Status == JobStatusFinished && modDate.Add(finishedTime).Before(now)) {
+		failed := v.Status == JobStatusFailed || v.Status == JobStatusPartial
+		if (failed && modDate.Add(failedTime).Before(now)) || (v.Status == JobStatusFinished && modDate.Add(finishedTime).Before(now)) {
 			delJobCounter++
-			removeJob(*v)
+			delete(jd.list, k)
 		}
 	}
 	return delJobCounter, nil
 }
 
 func (jd *jobDao) SetC4Id(jobId string, c4Id string) api_error.ApiErr {
-	getJob, err := getJob(jobId)
-	if err != nil {
-		return err
-	}
-	if strings.TrimSpace(c4Id) == "" {
-		return api_error.NewBadRequestError("invalid C4 Id")
-	}
-	getJob.FileC4Id = c4Id
-	getJob.ModifiedAt = date.GetNowUtcString()
-	_, saveErr := JobDao.Save(*getJob, true)
-	if saveErr != nil {
-		return saveErr
-	}
-	return nil
+	return jd.update(jobId, func(job *Job) api_error.ApiErr {
+		if strings.TrimSpace(c4Id) == "" {
+			return WithCode(CodeInvalidC4Id, api_error.NewBadRequestError("invalid C4 Id"))
+		}
+		job.FileC4Id = c4Id
+		return nil
+	})
 }
 
 func (jd *jobDao) SetDstUrl(jobId string, dstUrl string) api_error.ApiErr {
-	getJob, err := getJob(jobId)
-	if err != nil {
-		return err
-	}
-	if strings.TrimSpace(dstUrl) == "" {
-		return api_error.NewBadRequestError("invalid destination URL")
-	}
-	getJob.DstUrl = dstUrl
-	getJob.ModifiedAt = date.GetNowUtcString()
-	_, saveErr := JobDao.Save(*getJob, true)
-	if saveErr != nil {
-		return saveErr
-	}
-	return nil
+	return jd.update(jobId, func(job *Job) api_error.ApiErr {
+		if strings.TrimSpace(dstUrl) == "" {
+			return WithCode(CodeInvalidDestinationUrl, api_error.NewBadRequestError("invalid destination URL"))
+		}
+		job.DstUrl = dstUrl
+		return nil
+	})
 }
 
-func (jd *jobDao) SetErrMsg(jobId string, errMsg string) api_error.ApiErr {
-	getJob, err := getJob(jobId)
-	if err != nil {
-		return err
-	}
-	getJob.ErrorMsg = errMsg
-	getJob.ModifiedAt = date.GetNowUtcString()
-	_, saveErr := JobDao.Save(*getJob, true)
-	if saveErr != nil {
-		return saveErr
-	}
-	return nil
+func (jd *jobDao) SetErrMsg(jobId string, errCode string, errMsg string) api_error.ApiErr {
+	return jd.update(jobId, func(job *Job) api_error.ApiErr {
+		job.ErrorCode = errCode
+		job.ErrorMsg = errMsg
+		return nil
+	})
+}
+
+func (jd *jobDao) SetFromMetadata(jobId string, fromMetadata bool) api_error.ApiErr {
+	return jd.update(jobId, func(job *Job) api_error.ApiErr {
+		job.FromMetadata = fromMetadata
+		return nil
+	})
+}
+
+func (jd *jobDao) SetFileInfo(jobId string, fileSize int64, fileModified string) api_error.ApiErr {
+	return jd.update(jobId, func(job *Job) api_error.ApiErr {
+		job.FileSize = fileSize
+		job.FileModified = fileModified
+		return nil
+	})
+}
+
+func (jd *jobDao) SetDigests(jobId string, digests map[string]string) api_error.ApiErr {
+	return jd.update(jobId, func(job *Job) api_error.ApiErr {
+		job.Digests = cloneStrings(digests)
+		return nil
+	})
+}
+
+// SetFilesTotal starts the file list of a tree job, dropping the files of an earlier run
+func (jd *jobDao) SetFilesTotal(jobId string, filesTotal int) api_error.ApiErr {
+	return jd.update(jobId, func(job *Job) api_error.ApiErr {
+		job.Files = nil
+		job.FilesDone = 0
+		job.FilesFailed = 0
+		job.FilesTotal = filesTotal
+		return nil
+	})
+}
+
+func (jd *jobDao) AddFile(jobId string, file TreeFile) api_error.ApiErr {
+	return jd.update(jobId, func(job *Job) api_error.ApiErr {
+		file.Digests = cloneStrings(file.Digests)
+		job.Files = append(job.Files, file)
+		job.FilesDone++
+		if file.ErrorMsg != "" {
+			job.FilesFailed++
+		}
+		return nil
+	})
+}
+
+func (jd *jobDao) SetProgress(jobId string, progress JobProgress) api_error.ApiErr {
+	return jd.update(jobId, func(job *Job) api_error.ApiErr {
+		job.JobProgress = progress
+		return nil
+	})
+}
+
+func (jd *jobDao) SetCheckpoint(jobId string, checkpoint *Checkpoint) api_error.ApiErr {
+	return jd.update(jobId, func(job *Job) api_error.ApiErr {
+		job.Checkpoint = checkpoint.clone()
+		return nil
+	})
 }
 
 func (jd *jobDao) GetAll() (*Jobs, api_error.ApiErr) {
-	if len(jobs.list) == 0 {
-		return nil, api_error.NewNotFoundError("no jobs in list")
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
+	if len(jd.list) == 0 {
+		return nil, WithCode(CodeJobNotFound, api_error.NewNotFoundError("no jobs in list"))
 	}
-	var returnJobs Jobs
-	jobs.mu.Lock()
-	defer jobs.mu.Unlock()
-	for job := range jobs.list {
-		returnJobs = append(returnJobs, *jobs.list[job])
+	returnJobs := make(Jobs, 0, len(jd.list))
+	for _, job := range jd.list {
+		returnJobs = append(returnJobs, *job.clone())
 	}
 	return &returnJobs, nil
 }
+
+// Stats counts the queued jobs and estimates the memory of all jobs
+func (jd *jobDao) Stats() QueueStats {
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
+	stats := QueueStats{}
+	for _, job := range jd.list {
+		if job.Status == JobStatusCreated {
+			stats.Queued++
+		}
+		stats.Memory += job.MemSize()
+	}
+	return stats
+}
+
+// Ping returns once the job store can be accessed
+func (jd *jobDao) Ping() api_error.ApiErr {
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
+	return nil
+}
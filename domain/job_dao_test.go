@@ -1,9 +1,11 @@
 package domain
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/johannes-kuhfuss/c4svc/config"
 	"github.com/johannes-kuhfuss/services_utils/date"
@@ -65,9 +67,20 @@ var (
 	}
 )
 
+func newTestJobDao() *jobDao {
+	return NewJobDao().(*jobDao)
+}
+
+func (jd *jobDao) addJob(newJob Job) {
+	jd.mu.Lock()
+	defer jd.mu.Unlock()
+	jd.list[newJob.Id] = newJob.clone()
+}
+
 func TestGetNotFound(t *testing.T) {
+	jd := newTestJobDao()
 	id := "X"
-	user, err := JobDao.Get(id)
+	user, err := jd.Get(id)
 	assert.Nil(t, user)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
@@ -75,17 +88,17 @@ func TestGetNotFound(t *testing.T) {
 }
 
 func TestGetNoError(t *testing.T) {
-	addJob(job1)
-	defer removeJob(job1)
-	testJob, err := JobDao.Get(job1.Id)
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	testJob, err := jd.Get(job1.Id)
 	assert.NotNil(t, testJob)
 	assert.Nil(t, err)
 	assert.EqualValues(t, job1.Id, testJob.Id)
 }
 
 func TestSaveJobExistsNoOverwrite(t *testing.T) {
-	addJob(job1)
-	defer removeJob(job1)
+	jd := newTestJobDao()
+	jd.addJob(job1)
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbbO"
 	newJob := Job{
 		Id:         fmt.Sprintf("%v", id),
@@ -100,7 +113,7 @@ func TestSaveJobExistsNoOverwrite(t *testing.T) {
 		Status:     "Running",
 		FileC4Id:   "abcdefg",
 	}
-	testJob, err := JobDao.Save(newJob, false)
+	testJob, err := jd.Save(newJob, false)
 	assert.Nil(t, testJob)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
@@ -108,8 +121,8 @@ func TestSaveJobExistsNoOverwrite(t *testing.T) {
 }
 
 func TestSaveJobExistsOverwrite(t *testing.T) {
-	addJob(job1)
-	defer removeJob(job1)
+	jd := newTestJobDao()
+	jd.addJob(job1)
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbbO"
 	name := "Job 2"
 	newJob := Job{
@@ -125,7 +138,7 @@ func TestSaveJobExistsOverwrite(t *testing.T) {
 		Status:     "Running",
 		FileC4Id:   "abcdefg",
 	}
-	testJob, err := JobDao.Save(newJob, true)
+	testJob, err := jd.Save(newJob, true)
 	assert.NotNil(t, testJob)
 	assert.Nil(t, err)
 	assert.EqualValues(t, id, testJob.Id)
@@ -133,22 +146,24 @@ func TestSaveJobExistsOverwrite(t *testing.T) {
 }
 
 func TestDeleteJobNotFound(t *testing.T) {
+	jd := newTestJobDao()
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbbO"
-	err := JobDao.Delete(id)
+	err := jd.Delete(id)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
 	assert.EqualValues(t, fmt.Sprintf("job with Id %v does not exist", id), err.Message())
 }
 
 func TestDeleteJobNoError(t *testing.T) {
-	addJob(job1)
-	defer removeJob(job1)
-	err := JobDao.Delete(job1.Id)
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.Delete(job1.Id)
 	assert.Nil(t, err)
 }
 
 func TestGetNextListEmpty(t *testing.T) {
-	nextJob, err := JobDao.GetNext()
+	jd := newTestJobDao()
+	nextJob, err := jd.GetNext()
 	assert.Nil(t, nextJob)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
@@ -156,186 +171,296 @@ func TestGetNextListEmpty(t *testing.T) {
 }
 
 func TestGetNextNoError(t *testing.T) {
-	addJob(job1)
-	addJob(job2)
-	addJob(job3)
-	defer removeJob(job1)
-	defer removeJob(job2)
-	defer removeJob(job3)
-	nextJob, err := JobDao.GetNext()
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	jd.addJob(job2)
+	jd.addJob(job3)
+	nextJob, err := jd.GetNext()
 	assert.NotNil(t, nextJob)
 	assert.Nil(t, err)
 	assert.EqualValues(t, job3.Id, nextJob.Id)
 	assert.EqualValues(t, job3.Name, nextJob.Name)
+	assert.EqualValues(t, JobStatusRunning, nextJob.Status)
+	nextJob, err = jd.GetNext()
+	assert.Nil(t, err)
+	assert.NotEqualValues(t, job3.Id, nextJob.Id)
+}
+
+func TestGetNextOrdersByQueuedAt(t *testing.T) {
+	jd := newTestJobDao()
+	now := date.GetNowUtc()
+	retried := Job{
+		Id:        "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+		Status:    JobStatusFailed,
+		CreatedAt: now.Add(-time.Hour).Format(date.ApiDateLayout),
+		QueuedAt:  now.Add(-time.Hour).Format(date.ApiDateLayout),
+	}
+	waiting := Job{
+		Id:        "1zXgBZNnBG1msmF1ARQK9ZphbcO",
+		Status:    JobStatusCreated,
+		CreatedAt: now.Add(-10 * time.Minute).Format(date.ApiDateLayout),
+		QueuedAt:  now.Add(-10 * time.Minute).Format(date.ApiDateLayout),
+	}
+	jd.addJob(retried)
+	jd.addJob(waiting)
+	assert.Nil(t, jd.ChangeStatus(retried.Id, "created"))
+	nextJob, err := jd.GetNext()
+	assert.Nil(t, err)
+	assert.EqualValues(t, waiting.Id, nextJob.Id)
+	nextJob, err = jd.GetNext()
+	assert.Nil(t, err)
+	assert.EqualValues(t, retried.Id, nextJob.Id)
 }
 
 func TestChangeStatusNoJob(t *testing.T) {
+	jd := newTestJobDao()
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbdO"
-	err := JobDao.ChangeStatus(id, "")
+	err := jd.ChangeStatus(id, "")
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
 	assert.EqualValues(t, fmt.Sprintf("job with Id %v does not exist", id), err.Message())
 }
 
 func TestChangeStatusInvalidStatus(t *testing.T) {
-	addJob(job3)
-	defer removeJob(job3)
-	err := JobDao.ChangeStatus(job3.Id, "invalidstatus")
+	jd := newTestJobDao()
+	jd.addJob(job3)
+	err := jd.ChangeStatus(job3.Id, "invalidstatus")
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
 	assert.EqualValues(t, "invalid status value", err.Message())
 }
 
 func TestChangeStatusSameStatus(t *testing.T) {
-	addJob(job3)
-	defer removeJob(job3)
-	err := JobDao.ChangeStatus(job3.Id, "created")
+	jd := newTestJobDao()
+	jd.addJob(job3)
+	err := jd.ChangeStatus(job3.Id, "created")
 	assert.Nil(t, err)
-	testJob, err := JobDao.Get(job3.Id)
+	testJob, err := jd.Get(job3.Id)
 	assert.NotNil(t, testJob)
 	assert.Nil(t, err)
 	assert.EqualValues(t, JobStatus("Created"), testJob.Status)
 }
 
 func TestChangeStatusNoErrorCreated(t *testing.T) {
-	addJob(job1)
-	defer removeJob(job1)
-	err := JobDao.ChangeStatus(job1.Id, "created")
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.ChangeStatus(job1.Id, "created")
 	assert.Nil(t, err)
-	testJob, err := JobDao.Get(job1.Id)
+	testJob, err := jd.Get(job1.Id)
 	assert.NotNil(t, testJob)
 	assert.Nil(t, err)
 	assert.EqualValues(t, JobStatus("Created"), testJob.Status)
 }
 
 func TestChangeStatusNoErrorRunning(t *testing.T) {
-	addJob(job3)
-	defer removeJob(job3)
-	err := JobDao.ChangeStatus(job3.Id, "running")
+	jd := newTestJobDao()
+	jd.addJob(job3)
+	err := jd.ChangeStatus(job3.Id, "running")
 	assert.Nil(t, err)
-	testJob, err := JobDao.Get(job3.Id)
+	testJob, err := jd.Get(job3.Id)
 	assert.NotNil(t, testJob)
 	assert.Nil(t, err)
 	assert.EqualValues(t, JobStatus("Running"), testJob.Status)
 }
 
 func TestChangeStatusNoErrorFailed(t *testing.T) {
-	addJob(job3)
-	defer removeJob(job3)
-	err := JobDao.ChangeStatus(job3.Id, "failed")
+	jd := newTestJobDao()
+	jd.addJob(job3)
+	err := jd.ChangeStatus(job3.Id, "failed")
 	assert.Nil(t, err)
-	testJob, err := JobDao.Get(job3.Id)
+	testJob, err := jd.Get(job3.Id)
 	assert.NotNil(t, testJob)
 	assert.Nil(t, err)
 	assert.EqualValues(t, JobStatus("Failed"), testJob.Status)
 }
 
 func TestChangeStatusNoErrorFinished(t *testing.T) {
-	addJob(job3)
-	defer removeJob(job3)
-	err := JobDao.ChangeStatus(job3.Id, "finished")
+	jd := newTestJobDao()
+	jd.addJob(job3)
+	err := jd.ChangeStatus(job3.Id, "finished")
 	assert.Nil(t, err)
-	testJob, err := JobDao.Get(job3.Id)
+	testJob, err := jd.Get(job3.Id)
 	assert.NotNil(t, testJob)
 	assert.Nil(t, err)
 	assert.EqualValues(t, JobStatus("Finished"), testJob.Status)
 }
 
 func TestCleanJobsNoJobs(t *testing.T) {
-	numJobs, err := JobDao.CleanJobs(config.DeleteFinishedAge, config.DeleteFailedAge)
+	jd := newTestJobDao()
+	numJobs, err := jd.CleanJobs(config.New().DeleteFinishedAge, config.New().DeleteFailedAge)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, 0, numJobs)
 }
 
 func TestCleanJobsNoModDate(t *testing.T) {
-	addJob(job4)
-	numJobs, err := JobDao.CleanJobs(config.DeleteFinishedAge, config.DeleteFailedAge)
+	jd := newTestJobDao()
+	jd.addJob(job4)
+	numJobs, err := jd.CleanJobs(config.New().DeleteFinishedAge, config.New().DeleteFailedAge)
 	assert.Nil(t, err)
 	assert.EqualValues(t, 0, numJobs)
 }
 
 func TestCleanJobsNoError(t *testing.T) {
-	job4.ModifiedAt = date.GetNowUtc().Add(-config.DeleteFinishedAge).Format(date.ApiDateLayout)
-	addJob(job4)
-	numJobs, err := JobDao.CleanJobs(config.DeleteFinishedAge, config.DeleteFailedAge)
+	jd := newTestJobDao()
+	job4.ModifiedAt = date.GetNowUtc().Add(-config.New().DeleteFinishedAge).Format(date.ApiDateLayout)
+	jd.addJob(job4)
+	numJobs, err := jd.CleanJobs(config.New().DeleteFinishedAge, config.New().DeleteFailedAge)
 	assert.Nil(t, err)
 	assert.EqualValues(t, 1, numJobs)
 }
 
 func TestSetC4IdNoJobFound(t *testing.T) {
+	jd := newTestJobDao()
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbdO"
-	err := JobDao.SetC4Id(id, "C4id")
+	err := jd.SetC4Id(id, "C4id")
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
 	assert.EqualValues(t, fmt.Sprintf("job with Id %v does not exist", id), err.Message())
 }
 
 func TestSetC4IdInvalidC4Id(t *testing.T) {
-	addJob(job1)
-	defer removeJob(job1)
-	err := JobDao.SetC4Id(job1.Id, "")
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.SetC4Id(job1.Id, "")
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
 	assert.EqualValues(t, "invalid C4 Id", err.Message())
 }
 
 func TestSetC4IdNoError(t *testing.T) {
-	addJob(job1)
-	defer removeJob(job1)
-	err := JobDao.SetC4Id(job1.Id, "new C4 Id")
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.SetC4Id(job1.Id, "new C4 Id")
 	assert.Nil(t, err)
-	testJob, err := JobDao.Get(job1.Id)
+	testJob, err := jd.Get(job1.Id)
 	assert.Nil(t, err)
 	assert.EqualValues(t, "new C4 Id", testJob.FileC4Id)
 }
 
 func TestSetDstUrlNoJobFound(t *testing.T) {
+	jd := newTestJobDao()
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbdO"
-	err := JobDao.SetDstUrl(id, "new url")
+	err := jd.SetDstUrl(id, "new url")
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
 	assert.EqualValues(t, fmt.Sprintf("job with Id %v does not exist", id), err.Message())
 }
 
 func TestSetDstUrlInvalidDstUrl(t *testing.T) {
-	addJob(job1)
-	defer removeJob(job1)
-	err := JobDao.SetDstUrl(job1.Id, "")
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.SetDstUrl(job1.Id, "")
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
 	assert.EqualValues(t, "invalid destination URL", err.Message())
 }
 
 func TestSetDstUrlNoError(t *testing.T) {
-	addJob(job1)
-	defer removeJob(job1)
-	err := JobDao.SetDstUrl(job1.Id, "new destination URL")
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.SetDstUrl(job1.Id, "new destination URL")
 	assert.Nil(t, err)
-	testJob, err := JobDao.Get(job1.Id)
+	testJob, err := jd.Get(job1.Id)
 	assert.Nil(t, err)
 	assert.EqualValues(t, "new destination URL", testJob.DstUrl)
 }
 
 func TestSetErrMsgNoJobFound(t *testing.T) {
+	jd := newTestJobDao()
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbdO"
-	err := JobDao.SetErrMsg(id, "new error message")
+	err := jd.SetErrMsg(id, CodeProcessingFailed, "new error message")
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
 	assert.EqualValues(t, fmt.Sprintf("job with Id %v does not exist", id), err.Message())
 }
 
 func TestSetErrMsgNoError(t *testing.T) {
-	addJob(job1)
-	defer removeJob(job1)
-	err := JobDao.SetErrMsg(job1.Id, "new error message")
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.SetErrMsg(job1.Id, CodeProcessingFailed, "new error message")
 	assert.Nil(t, err)
-	testJob, err := JobDao.Get(job1.Id)
+	testJob, err := jd.Get(job1.Id)
 	assert.Nil(t, err)
 	assert.EqualValues(t, "new error message", testJob.ErrorMsg)
+	assert.EqualValues(t, CodeProcessingFailed, testJob.ErrorCode)
+}
+
+func TestSetFromMetadataNoJobFound(t *testing.T) {
+	jd := newTestJobDao()
+	id := "1zXgBZNnBG1msmF1ARQK9ZphbdO"
+	err := jd.SetFromMetadata(id, true)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+	assert.EqualValues(t, fmt.Sprintf("job with Id %v does not exist", id), err.Message())
+}
+
+func TestSetFromMetadataNoError(t *testing.T) {
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.SetFromMetadata(job1.Id, true)
+	assert.Nil(t, err)
+	testJob, err := jd.Get(job1.Id)
+	assert.Nil(t, err)
+	assert.True(t, testJob.FromMetadata)
+}
+
+func TestSetFileInfoNoError(t *testing.T) {
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.SetFileInfo(job1.Id, 1024, "2021-10-15T15:00:00Z")
+	assert.Nil(t, err)
+	testJob, err := jd.Get(job1.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1024, testJob.FileSize)
+	assert.EqualValues(t, "2021-10-15T15:00:00Z", testJob.FileModified)
+}
+
+func TestSetDigestsNoError(t *testing.T) {
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.SetDigests(job1.Id, map[string]string{"md5": "abcdef"})
+	assert.Nil(t, err)
+	testJob, err := jd.Get(job1.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, "abcdef", testJob.Digests["md5"])
+}
+
+func TestSetFilesTotalNoError(t *testing.T) {
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.SetFilesTotal(job1.Id, 3)
+	assert.Nil(t, err)
+	testJob, err := jd.Get(job1.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, testJob.FilesTotal)
+}
+
+func TestAddFileNoJobFound(t *testing.T) {
+	jd := newTestJobDao()
+	id := "1zXgBZNnBG1msmF1ARQK9ZphbdO"
+	err := jd.AddFile(id, TreeFile{Path: "file1.ext"})
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+	assert.EqualValues(t, fmt.Sprintf("job with Id %v does not exist", id), err.Message())
+}
+
+func TestAddFileCountsFailures(t *testing.T) {
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.AddFile(job1.Id, TreeFile{Path: "file1.ext", FileC4Id: "abcdefg"})
+	assert.Nil(t, err)
+	err = jd.AddFile(job1.Id, TreeFile{Path: "file2.ext", ErrorMsg: "could not read file"})
+	assert.Nil(t, err)
+	testJob, err := jd.Get(job1.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, len(testJob.Files))
+	assert.EqualValues(t, 2, testJob.FilesDone)
+	assert.EqualValues(t, 1, testJob.FilesFailed)
 }
 
 func TestGetAllNoJobsError(t *testing.T) {
-	jobs, err := JobDao.GetAll()
+	jd := newTestJobDao()
+	jobs, err := jd.GetAll()
 	assert.Nil(t, jobs)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
@@ -343,14 +468,106 @@ func TestGetAllNoJobsError(t *testing.T) {
 }
 
 func TestGetAllNoError(t *testing.T) {
-	addJob(job1)
-	addJob(job2)
-	addJob(job3)
-	defer removeJob(job1)
-	defer removeJob(job2)
-	defer removeJob(job3)
-	jobs, err := JobDao.GetAll()
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	jd.addJob(job2)
+	jd.addJob(job3)
+	jobs, err := jd.GetAll()
 	assert.NotNil(t, jobs)
 	assert.Nil(t, err)
 	assert.EqualValues(t, 3, len(*jobs))
 }
+
+func TestSetProgressNoError(t *testing.T) {
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.SetProgress(job1.Id, JobProgress{BytesTotal: 100, BytesProcessed: 40, Throughput: 20, EtaSeconds: 3})
+	assert.Nil(t, err)
+	testJob, err := jd.Get(job1.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 100, testJob.BytesTotal)
+	assert.EqualValues(t, 40, testJob.BytesProcessed)
+	assert.EqualValues(t, 3, testJob.EtaSeconds)
+}
+
+func TestSetCheckpointNoError(t *testing.T) {
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	err := jd.SetCheckpoint(job1.Id, &Checkpoint{ETag: "etag", Offset: 1024})
+	assert.Nil(t, err)
+	testJob, err := jd.Get(job1.Id)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1024, testJob.Checkpoint.Offset)
+	err = jd.SetCheckpoint(job1.Id, nil)
+	assert.Nil(t, err)
+	testJob, _ = jd.Get(job1.Id)
+	assert.Nil(t, testJob.Checkpoint)
+}
+
+func TestStatsCountsQueuedJobs(t *testing.T) {
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	jd.addJob(job2)
+	stats := jd.Stats()
+	assert.EqualValues(t, 1, stats.Queued)
+	assert.EqualValues(t, job1.MemSize()+job2.MemSize(), stats.Memory)
+}
+
+func TestGetReturnsCopy(t *testing.T) {
+	jd := newTestJobDao()
+	jd.addJob(job1)
+	assert.Nil(t, jd.SetDigests(job1.Id, map[string]string{DigestMd5: "abc"}))
+	testJob, _ := jd.Get(job1.Id)
+	testJob.Name = "changed"
+	testJob.Digests[DigestMd5] = "changed"
+	storedJob, _ := jd.Get(job1.Id)
+	assert.EqualValues(t, job1.Name, storedJob.Name)
+	assert.EqualValues(t, "abc", storedJob.Digests[DigestMd5])
+}
+
+// TestReadWhileProcessing is meant to be run with -race
+func TestReadWhileProcessing(t *testing.T) {
+	jd := newTestJobDao()
+	jd.addJob(job2)
+	running, err := jd.GetNext()
+	assert.Nil(t, err)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			jd.SetProgress(running.Id, JobProgress{BytesTotal: 200, BytesProcessed: int64(i)})
+			jd.AddFile(running.Id, TreeFile{Path: fmt.Sprintf("file%d", i), Digests: map[string]string{DigestMd5: "abc"}})
+			jd.SetCheckpoint(running.Id, &Checkpoint{Offset: int64(i), States: map[string][]byte{DigestMd5: {1}}})
+		}
+	}()
+	for polling := true; polling; {
+		select {
+		case <-done:
+			polling = false
+		default:
+		}
+		testJob, err := jd.Get(running.Id)
+		assert.Nil(t, err)
+		_, jsonErr := json.Marshal(testJob)
+		assert.Nil(t, jsonErr)
+		jobs, err := jd.GetAll()
+		assert.Nil(t, err)
+		_, jsonErr = json.Marshal(jobs)
+		assert.Nil(t, jsonErr)
+	}
+	testJob, _ := jd.Get(running.Id)
+	assert.EqualValues(t, 199, testJob.BytesProcessed)
+	assert.EqualValues(t, 200, len(testJob.Files))
+}
+
+func TestChangeStatusToCreatedRequeues(t *testing.T) {
+	jd := newTestJobDao()
+	failed := job1
+	failed.Status = JobStatusFailed
+	jd.addJob(failed)
+	err := jd.ChangeStatus(failed.Id, JobStatusCreated)
+	assert.Nil(t, err)
+	testJob, _ := jd.Get(failed.Id)
+	_, parseErr := time.Parse(date.ApiDateLayout, testJob.QueuedAt)
+	assert.Nil(t, parseErr)
+}
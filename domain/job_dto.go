@@ -1,9 +1,18 @@
 package domain
 
 import (
+	"context"
+	"fmt"
 	"strings"
+	"time"
 
 	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+var (
+	traceContext = propagation.TraceContext{}
 )
 
 type JobType string
@@ -11,6 +20,19 @@ type JobType string
 const (
 	JobTypeCreate          = "Create"
 	JobTypeCreateAndRename = "CreateAndRename"
+	JobTypeTree            = "Tree"
+)
+
+const (
+	DigestMd5      = "md5"
+	DigestSha1     = "sha1"
+	DigestSha256   = "sha256"
+	DigestXxHash64 = "xxhash64"
+	DigestCrc32c   = "crc32c"
+)
+
+var (
+	DigestAlgorithms = []string{DigestMd5, DigestSha1, DigestSha256, DigestXxHash64, DigestCrc32c}
 )
 
 type JobStatus string
@@ -20,31 +42,188 @@ const (
 	JobStatusRunning  = "Running"
 	JobStatusFinished = "Finished"
 	JobStatusFailed   = "Failed"
+	// JobStatusPartial marks tree jobs that finished although some of their files could not be identified
+	JobStatusPartial = "Partial"
 )
 
 type Job struct {
-	Id         string    `json:"id"`
-	Name       string    `json:"name"`
-	CreatedAt  string    `json:"created_at"`
-	CreatedBy  string    `json:"created_by"`
-	ModifiedAt string    `json:"modified_at"`
-	ModifiedBy string    `json:"modified_by"`
-	SrcUrl     string    `json:"src_url"`
-	DstUrl     string    `json:"dst_url"`
-	Type       JobType   `json:"type"`
-	Status     JobStatus `json:"status"`
-	FileC4Id   string    `json:"file_c4_id"`
-	ErrorMsg   string    `json:"error_msg"`
+	Id            string            `json:"id"`
+	Name          string            `json:"name"`
+	CreatedAt     string            `json:"created_at"`
+	CreatedBy     string            `json:"created_by"`
+	ModifiedAt    string            `json:"modified_at"`
+	ModifiedBy    string            `json:"modified_by"`
+	QueuedAt      string            `json:"queued_at,omitempty"`
+	SrcUrl        string            `json:"src_url"`
+	DstUrl        string            `json:"dst_url"`
+	Type          JobType           `json:"type"`
+	Status        JobStatus         `json:"status"`
+	FileC4Id      string            `json:"file_c4_id"`
+	FileSize      int64             `json:"file_size"`
+	FileModified  string            `json:"file_modified"`
+	ErrorMsg      string            `json:"error_msg"`
+	ErrorCode     string            `json:"error_code,omitempty"`
+	BatchId       string            `json:"batch_id,omitempty"`
+	RequestId     string            `json:"request_id,omitempty"`
+	TraceContext  map[string]string `json:"trace_context,omitempty"`
+	WriteMetadata bool              `json:"write_metadata"`
+	WriteTags     bool              `json:"write_tags"`
+	TrustMetadata bool              `json:"trust_metadata"`
+	FromMetadata  bool              `json:"from_metadata"`
+	DigestTypes   []string          `json:"digest_types,omitempty"`
+	Digests       map[string]string `json:"digests,omitempty"`
+	Files         []TreeFile        `json:"files,omitempty"`
+	FilesTotal    int               `json:"files_total,omitempty"`
+	FilesDone     int               `json:"files_done,omitempty"`
+	FilesFailed   int               `json:"files_failed,omitempty"`
+	JobProgress
+	Checkpoint *Checkpoint `json:"-"`
+}
+
+// JobFlags holds the flags given in a partial update. Flags missing from the update are nil
+// and keep their current value, so that a partial update can also switch a flag off.
+type JobFlags struct {
+	WriteMetadata *bool `json:"write_metadata"`
+	WriteTags     *bool `json:"write_tags"`
+	TrustMetadata *bool `json:"trust_metadata"`
+}
+
+type JobProgress struct {
+	BytesTotal     int64   `json:"bytes_total,omitempty"`
+	BytesProcessed int64   `json:"bytes_processed,omitempty"`
+	BytesResumed   int64   `json:"bytes_resumed,omitempty"`
+	Throughput     float64 `json:"throughput,omitempty"`
+	EtaSeconds     int64   `json:"eta_seconds,omitempty"`
+}
+
+// Checkpoint holds the serialized hasher states after the first Offset bytes of the
+// blob with the given ETag have been hashed. Like the job, it is only held in memory.
+type Checkpoint struct {
+	ETag   string
+	Offset int64
+	States map[string][]byte
+}
+
+type TreeFile struct {
+	Path         string            `json:"path"`
+	Size         int64             `json:"size"`
+	LastModified string            `json:"last_modified"`
+	FileC4Id     string            `json:"file_c4_id"`
+	Digests      map[string]string `json:"digests,omitempty"`
+	ErrorMsg     string            `json:"error_msg"`
 }
 
 func (j *Job) Validate() api_error.ApiErr {
-	if (j.Type != JobTypeCreate) && (j.Type != JobTypeCreateAndRename) {
-		return api_error.NewBadRequestError("invalid job type")
+	var details []FieldError
+	if (j.Type != JobTypeCreate) && (j.Type != JobTypeCreateAndRename) && (j.Type != JobTypeTree) {
+		details = append(details, FieldError{Field: "type", Code: CodeInvalidJobType, Message: "invalid job type"})
 	}
 	if strings.TrimSpace(j.SrcUrl) == "" {
-		return api_error.NewBadRequestError("invalid source Url")
+		details = append(details, FieldError{Field: "src_url", Code: CodeInvalidSourceUrl, Message: "invalid source Url"})
+	}
+	for i, digestType := range j.DigestTypes {
+		if !IsDigestAlgorithm(digestType) {
+			details = append(details, FieldError{Field: fmt.Sprintf("digest_types[%d]", i), Code: CodeInvalidDigestType, Message: fmt.Sprintf("invalid digest type %v", digestType)})
+		}
+	}
+	if len(details) > 0 {
+		return NewValidationError(details)
 	}
 	return nil
 }
 
+// clone returns a deep copy of the job, which callers can read while the stored job changes
+func (j *Job) clone() *Job {
+	clone := *j
+	clone.TraceContext = cloneStrings(j.TraceContext)
+	clone.Digests = cloneStrings(j.Digests)
+	if j.DigestTypes != nil {
+		clone.DigestTypes = append([]string{}, j.DigestTypes...)
+	}
+	if j.Files != nil {
+		clone.Files = make([]TreeFile, len(j.Files))
+		for i, file := range j.Files {
+			file.Digests = cloneStrings(file.Digests)
+			clone.Files[i] = file
+		}
+	}
+	clone.Checkpoint = j.Checkpoint.clone()
+	return &clone
+}
+
+func (c *Checkpoint) clone() *Checkpoint {
+	if c == nil {
+		return nil
+	}
+	clone := *c
+	clone.States = make(map[string][]byte, len(c.States))
+	for k, v := range c.States {
+		clone.States[k] = append([]byte{}, v...)
+	}
+	return &clone
+}
+
+func cloneStrings(m map[string]string) map[string]string {
+	if m == nil {
+		return nil
+	}
+	clone := make(map[string]string, len(m))
+	for k, v := range m {
+		clone[k] = v
+	}
+	return clone
+}
+
+// LogFields identify the job and the request that created it in log lines
+func (j *Job) LogFields() []logger.Field {
+	return []logger.Field{
+		{Key: "job_id", Value: j.Id},
+		{Key: "request_id", Value: j.RequestId},
+	}
+}
+
+// NewTraceContext returns the W3C trace context of ctx, to be stored on a job
+func NewTraceContext(ctx context.Context) map[string]string {
+	carrier := propagation.MapCarrier{}
+	traceContext.Inject(ctx, carrier)
+	if len(carrier) == 0 {
+		return nil
+	}
+	return carrier
+}
+
+// SpanContext returns ctx with the trace context stored on the job, so spans started from it
+// continue the trace of the request that created the job
+func (j *Job) SpanContext(ctx context.Context) context.Context {
+	return traceContext.Extract(ctx, propagation.MapCarrier(j.TraceContext))
+}
+
+func IsDigestAlgorithm(digestType string) bool {
+	for _, algorithm := range DigestAlgorithms {
+		if algorithm == digestType {
+			return true
+		}
+	}
+	return false
+}
+
 type Jobs []Job
+
+// NewJobProgress calculates throughput in bytes per second and the estimated remaining time
+// from the bytes processed so far. Bytes resumed from a checkpoint count as processed, but not
+// towards the throughput, as they were not processed during the elapsed time.
+func NewJobProgress(bytesTotal int64, bytesProcessed int64, bytesResumed int64, elapsed time.Duration) JobProgress {
+	progress := JobProgress{
+		BytesTotal:     bytesTotal,
+		BytesProcessed: bytesProcessed,
+		BytesResumed:   bytesResumed,
+	}
+	if elapsed <= 0 || bytesProcessed-bytesResumed <= 0 {
+		return progress
+	}
+	progress.Throughput = float64(bytesProcessed-bytesResumed) / elapsed.Seconds()
+	if remaining := bytesTotal - bytesProcessed; remaining > 0 {
+		progress.EtaSeconds = int64(float64(remaining) / progress.Throughput)
+	}
+	return progress
+}
@@ -1,17 +1,21 @@
 package domain
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 	"testing"
+	"time"
 
 	"github.com/johannes-kuhfuss/services_utils/date"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func TestConstJobType(t *testing.T) {
 	assert.EqualValues(t, JobTypeCreate, "Create")
 	assert.EqualValues(t, JobTypeCreateAndRename, "CreateAndRename")
+	assert.EqualValues(t, JobTypeTree, "Tree")
 }
 
 func TestConstJobStatus(t *testing.T) {
@@ -65,12 +69,82 @@ func TestValidateNoSource(t *testing.T) {
 	assert.EqualValues(t, err.Message(), "invalid source Url")
 }
 
+func TestValidateInvalidDigestType(t *testing.T) {
+	job1 := Job{
+		Type:        "Create",
+		SrcUrl:      "https://server/path1/file1.ext",
+		DigestTypes: []string{"md5", "md4"},
+	}
+	err := job1.Validate()
+	assert.NotNil(t, err)
+	assert.EqualValues(t, err.StatusCode(), http.StatusBadRequest)
+	assert.EqualValues(t, err.Message(), "invalid digest type md4")
+}
+
 func TestValidateNoError(t *testing.T) {
 	job1 := Job{
-		Type:   "CreateAndRename",
-		SrcUrl: "https://server/path1/file1.ext",
-		DstUrl: "https://server/path2/file2.ext",
+		Type:        "CreateAndRename",
+		SrcUrl:      "https://server/path1/file1.ext",
+		DstUrl:      "https://server/path2/file2.ext",
+		DigestTypes: DigestAlgorithms,
 	}
 	err := job1.Validate()
 	assert.Nil(t, err)
 }
+
+func TestNewJobProgressNoElapsedTime(t *testing.T) {
+	progress := NewJobProgress(100, 50, 0, 0)
+	assert.EqualValues(t, 100, progress.BytesTotal)
+	assert.EqualValues(t, 50, progress.BytesProcessed)
+	assert.EqualValues(t, 0, progress.Throughput)
+	assert.EqualValues(t, 0, progress.EtaSeconds)
+}
+
+func TestNewJobProgressThroughputAndEta(t *testing.T) {
+	progress := NewJobProgress(1000, 250, 0, 5*time.Second)
+	assert.EqualValues(t, 50, progress.Throughput)
+	assert.EqualValues(t, 15, progress.EtaSeconds)
+}
+
+func TestNewJobProgressExcludesResumedBytes(t *testing.T) {
+	progress := NewJobProgress(1000, 750, 500, 5*time.Second)
+	assert.EqualValues(t, 750, progress.BytesProcessed)
+	assert.EqualValues(t, 500, progress.BytesResumed)
+	assert.EqualValues(t, 50, progress.Throughput)
+	assert.EqualValues(t, 5, progress.EtaSeconds)
+
+	progress = NewJobProgress(1000, 500, 500, 5*time.Second)
+	assert.EqualValues(t, 0, progress.Throughput)
+	assert.EqualValues(t, 0, progress.EtaSeconds)
+}
+
+func TestJobLogFields(t *testing.T) {
+	job1 := Job{
+		Id:        "1zXgBZNnBG1msmF1ARQK9ZphbdO",
+		RequestId: "req-1",
+	}
+	fields := job1.LogFields()
+	assert.EqualValues(t, 2, len(fields))
+	assert.EqualValues(t, "job_id", fields[0].Key)
+	assert.EqualValues(t, job1.Id, fields[0].Value)
+	assert.EqualValues(t, "request_id", fields[1].Key)
+	assert.EqualValues(t, "req-1", fields[1].Value)
+}
+
+func TestNewTraceContextNoSpan(t *testing.T) {
+	assert.Nil(t, NewTraceContext(context.Background()))
+}
+
+func TestTraceContextRoundTrip(t *testing.T) {
+	traceId, _ := trace.TraceIDFromHex("4bf92f3577b34da6a3ce929d0e0e4736")
+	spanId, _ := trace.SpanIDFromHex("00f067aa0ba902b7")
+	spanContext := trace.NewSpanContext(trace.SpanContextConfig{TraceID: traceId, SpanID: spanId, TraceFlags: trace.FlagsSampled})
+	job1 := Job{
+		TraceContext: NewTraceContext(trace.ContextWithSpanContext(context.Background(), spanContext)),
+	}
+	assert.EqualValues(t, "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", job1.TraceContext["traceparent"])
+	restored := trace.SpanContextFromContext(job1.SpanContext(context.Background()))
+	assert.EqualValues(t, traceId, restored.TraceID())
+	assert.EqualValues(t, spanId, restored.SpanID())
+	assert.True(t, restored.IsRemote())
+}
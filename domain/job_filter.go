@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"strings"
+	"time"
+
+	"github.com/johannes-kuhfuss/services_utils/date"
+)
+
+type JobFilter struct {
+	Ids       []string
+	SrcPrefix string
+	DstPrefix string
+	From      *time.Time
+	To        *time.Time
+}
+
+// Matches checks a job against the filter; the time range applies to the job's last modification
+func (f *JobFilter) Matches(job Job) bool {
+	if len(f.Ids) > 0 && !containsId(f.Ids, job.Id) {
+		return false
+	}
+	if f.SrcPrefix != "" && !strings.HasPrefix(job.SrcUrl, f.SrcPrefix) {
+		return false
+	}
+	if f.DstPrefix != "" && !strings.HasPrefix(job.DstUrl, f.DstPrefix) {
+		return false
+	}
+	if f.From != nil || f.To != nil {
+		modDate, err := time.Parse(date.ApiDateLayout, job.ModifiedAt)
+		if err != nil {
+			return false
+		}
+		if f.From != nil && modDate.Before(*f.From) {
+			return false
+		}
+		if f.To != nil && modDate.After(*f.To) {
+			return false
+		}
+	}
+	return true
+}
+
+func containsId(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,48 @@
+package domain
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	filterJob Job = Job{
+		Id:         "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+		ModifiedAt: "2021-10-15T15:00:00Z",
+		SrcUrl:     "https://server1/media/file1.ext",
+		DstUrl:     "https://server1/media/c4id.ext",
+	}
+)
+
+func TestFilterEmptyMatches(t *testing.T) {
+	filter := JobFilter{}
+	assert.True(t, filter.Matches(filterJob))
+}
+
+func TestFilterIds(t *testing.T) {
+	filter := JobFilter{Ids: []string{"other"}}
+	assert.False(t, filter.Matches(filterJob))
+	filter.Ids = append(filter.Ids, filterJob.Id)
+	assert.True(t, filter.Matches(filterJob))
+}
+
+func TestFilterPrefixes(t *testing.T) {
+	filter := JobFilter{SrcPrefix: "https://server1/media/", DstPrefix: "https://server2/"}
+	assert.False(t, filter.Matches(filterJob))
+	filter.DstPrefix = "https://server1/"
+	assert.True(t, filter.Matches(filterJob))
+}
+
+func TestFilterTimeRange(t *testing.T) {
+	from := time.Date(2021, 10, 15, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2021, 10, 15, 12, 0, 0, 0, time.UTC)
+	filter := JobFilter{From: &from, To: &to}
+	assert.False(t, filter.Matches(filterJob))
+	to = time.Date(2021, 10, 16, 0, 0, 0, 0, time.UTC)
+	assert.True(t, filter.Matches(filterJob))
+	noModDate := filterJob
+	noModDate.ModifiedAt = ""
+	assert.False(t, filter.Matches(noModDate))
+}
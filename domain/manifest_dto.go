@@ -0,0 +1,39 @@
+package domain
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	ManifestHeader = "@c4m 1.0"
+)
+
+type ManifestEntry struct {
+	Path         string `json:"path"`
+	Size         int64  `json:"size"`
+	LastModified string `json:"last_modified"`
+	FileC4Id     string `json:"file_c4_id"`
+	JobId        string `json:"job_id"`
+}
+
+type Manifest struct {
+	CreatedAt string          `json:"created_at"`
+	Location  string          `json:"location,omitempty"`
+	Entries   []ManifestEntry `json:"entries"`
+}
+
+// Text renders the manifest in c4m style, one "<last modified> <size> <path> <C4 Id>" line per entry
+func (m *Manifest) Text() string {
+	var sb strings.Builder
+	sb.WriteString(ManifestHeader + "\n")
+	for _, entry := range m.Entries {
+		path := entry.Path
+		if strings.ContainsAny(path, " \t\"") {
+			path = strconv.Quote(path)
+		}
+		sb.WriteString(fmt.Sprintf("%s %d %s %s\n", entry.LastModified, entry.Size, path, entry.FileC4Id))
+	}
+	return sb.String()
+}
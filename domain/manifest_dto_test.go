@@ -0,0 +1,25 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestManifestTextEmpty(t *testing.T) {
+	manifest := Manifest{}
+	assert.EqualValues(t, "@c4m 1.0\n", manifest.Text())
+}
+
+func TestManifestTextEntries(t *testing.T) {
+	manifest := Manifest{
+		Entries: []ManifestEntry{
+			{Path: "https://server/media/file1.ext", Size: 12, LastModified: "2021-10-15T15:00:00Z", FileC4Id: "c4abc"},
+			{Path: "file:///media/my file.ext", Size: 3, LastModified: "2021-10-15T16:00:00Z", FileC4Id: "c4def"},
+		},
+	}
+	expected := "@c4m 1.0\n" +
+		"2021-10-15T15:00:00Z 12 https://server/media/file1.ext c4abc\n" +
+		"2021-10-15T16:00:00Z 3 \"file:///media/my file.ext\" c4def\n"
+	assert.EqualValues(t, expected, manifest.Text())
+}
@@ -0,0 +1,145 @@
+package domain
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+)
+
+// Error codes identify the kind of an error independent of its message, so clients can rely on them
+const (
+	CodeInvalidJson              = "invalid_json"
+	CodeInvalidParameter         = "invalid_parameter"
+	CodeInvalidJobId             = "invalid_job_id"
+	CodeInvalidJobType           = "invalid_job_type"
+	CodeInvalidSourceUrl         = "invalid_source_url"
+	CodeInvalidDestinationUrl    = "invalid_destination_url"
+	CodeInvalidDigestType        = "invalid_digest_type"
+	CodeInvalidJobStatus         = "invalid_job_status"
+	CodeInvalidC4Id              = "invalid_c4_id"
+	CodeInvalidIdempotencyKey    = "invalid_idempotency_key"
+	CodeJobNotFound              = "job_not_found"
+	CodeJobExists                = "job_exists"
+	CodeJobConflictStatus        = "job_conflict_status"
+	CodeJobNotOwned              = "job_not_owned"
+	CodeJobNotFinished           = "job_not_finished"
+	CodeIdempotencyKeyConflict   = "idempotency_key_conflict"
+	CodeIdempotencyKeyNotFound   = "idempotency_key_not_found"
+	CodeBatchNotFound            = "batch_not_found"
+	CodeBatchExists              = "batch_exists"
+	CodeBatchEmpty               = "batch_empty"
+	CodeBatchTooLarge            = "batch_too_large"
+	CodeBatchFailed              = "batch_failed"
+	CodeC4IdNotFound             = "c4_id_not_found"
+	CodeSourceDenied             = "source_denied"
+	CodeSourceTooLarge           = "source_too_large"
+	CodeMissingCredentials       = "missing_credentials"
+	CodeInvalidCredentials       = "invalid_credentials"
+	CodeMissingRole              = "missing_role"
+	CodeRateLimited              = "rate_limited"
+	CodeQuotaExceeded            = "quota_exceeded"
+	CodeQueueFull                = "queue_full"
+	CodeManifestNotConfigured    = "manifest_not_configured"
+	CodeManifestWriteFailed      = "manifest_write_failed"
+	CodeStorageNotConfigured     = "storage_not_configured"
+	CodeStorageAuthFailed        = "storage_auth_failed"
+	CodeStorageUnavailable       = "storage_unavailable"
+	CodeStorageFileNotAccessible = "storage_file_not_accessible"
+	CodeStorageWriteFailed       = "storage_write_failed"
+	CodeLocalNotAllowed          = "local_not_allowed"
+	CodeNoFilesFound             = "no_files_found"
+	CodeProcessingFailed         = "processing_failed"
+	CodePartiallyFailed          = "partially_failed"
+)
+
+// CodedErr is an ApiErr with a stable error code and optional details about invalid fields
+type CodedErr interface {
+	api_error.ApiErr
+	Code() string
+	Details() []FieldError
+}
+
+// FieldError describes why a single field of a request is invalid
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type codedErr struct {
+	api_error.ApiErr
+	code    string
+	details []FieldError
+}
+
+// WithCode attaches an error code to err
+func WithCode(code string, err api_error.ApiErr) CodedErr {
+	return codedErr{
+		ApiErr: err,
+		code:   code,
+	}
+}
+
+// NewValidationError reports all invalid fields of a request. The first one determines the
+// code and message of the error.
+func NewValidationError(details []FieldError) CodedErr {
+	return codedErr{
+		ApiErr:  api_error.NewBadRequestError(details[0].Message),
+		code:    details[0].Code,
+		details: details,
+	}
+}
+
+func (e codedErr) Code() string {
+	return e.code
+}
+
+func (e codedErr) Details() []FieldError {
+	return e.details
+}
+
+// ErrorCode returns the code of err. Errors without one are classified by their status code,
+// e.g. not_found.
+func ErrorCode(err api_error.ApiErr) string {
+	if coded, ok := err.(CodedErr); ok && coded.Code() != "" {
+		return coded.Code()
+	}
+	text := http.StatusText(err.StatusCode())
+	if text == "" {
+		return "unknown"
+	}
+	return strings.ToLower(strings.ReplaceAll(text, " ", "_"))
+}
+
+// Problem is an error response as described in RFC 7807
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	Errors    []FieldError `json:"errors,omitempty"`
+	RequestId string       `json:"request_id,omitempty"`
+	// Items is an extension member listing the result of each job of a batch that failed as a whole
+	Items []BatchItem `json:"items,omitempty"`
+}
+
+// NewProblem describes err for the request with the given path and Id. The causes of err are
+// left out, as they may contain internal details.
+func NewProblem(err api_error.ApiErr, instance string, requestId string) *Problem {
+	problem := Problem{
+		Code:      ErrorCode(err),
+		Title:     http.StatusText(err.StatusCode()),
+		Status:    err.StatusCode(),
+		Detail:    err.Message(),
+		Instance:  instance,
+		RequestId: requestId,
+	}
+	problem.Type = "urn:c4svc:problem:" + problem.Code
+	if coded, ok := err.(CodedErr); ok {
+		problem.Errors = coded.Details()
+	}
+	return &problem
+}
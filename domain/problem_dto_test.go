@@ -0,0 +1,40 @@
+package domain
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorCodeFallsBackToStatus(t *testing.T) {
+	assert.EqualValues(t, CodeJobNotFound, ErrorCode(WithCode(CodeJobNotFound, api_error.NewNotFoundError("gone"))))
+	assert.EqualValues(t, "not_found", ErrorCode(api_error.NewNotFoundError("gone")))
+	assert.EqualValues(t, "internal_server_error", ErrorCode(api_error.NewInternalServerError("failed", nil)))
+}
+
+func TestNewProblemLeavesOutCauses(t *testing.T) {
+	err := WithCode(CodeStorageAuthFailed, api_error.NewInternalServerError("Cannot access storage account - wrong credentials", api_error.NewBadRequestError("secret detail")))
+	problem := NewProblem(err, "/api/v1/job", "req1")
+	assert.EqualValues(t, Problem{
+		Type:      "urn:c4svc:problem:storage_auth_failed",
+		Title:     "Internal Server Error",
+		Status:    http.StatusInternalServerError,
+		Detail:    "Cannot access storage account - wrong credentials",
+		Instance:  "/api/v1/job",
+		Code:      CodeStorageAuthFailed,
+		RequestId: "req1",
+	}, *problem)
+}
+
+func TestNewValidationErrorUsesFirstField(t *testing.T) {
+	err := NewValidationError([]FieldError{
+		{Field: "src_url", Code: CodeInvalidSourceUrl, Message: "invalid source Url"},
+		{Field: "type", Code: CodeInvalidJobType, Message: "invalid job type"},
+	})
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, CodeInvalidSourceUrl, err.Code())
+	assert.EqualValues(t, "invalid source Url", err.Message())
+	assert.EqualValues(t, 2, len(NewProblem(err, "", "").Errors))
+}
@@ -0,0 +1,67 @@
+package domain
+
+import (
+	"fmt"
+)
+
+const (
+	// jobOverhead is the estimated memory of a job besides its strings, maps and files
+	jobOverhead = 512
+)
+
+// QueueStats summarizes the job store
+type QueueStats struct {
+	Queued int   // jobs in status Created
+	Memory int64 // estimated memory of all jobs in bytes
+}
+
+// QueueLimits bound the job store, 0 means no limit
+type QueueLimits struct {
+	MaxQueued int
+	MaxMemory int64
+}
+
+func (l QueueLimits) Enabled() bool {
+	return l.MaxQueued > 0 || l.MaxMemory > 0
+}
+
+// Full returns why adding jobs to a store with the given stats would exceed the limits, or "" if they fit
+func (l QueueLimits) Full(stats QueueStats, adding int) string {
+	if l.MaxQueued > 0 && stats.Queued+adding > l.MaxQueued {
+		return fmt.Sprintf("queue is full, %d of %d jobs queued", stats.Queued, l.MaxQueued)
+	}
+	if l.MaxMemory > 0 && stats.Memory >= l.MaxMemory {
+		return fmt.Sprintf("job store is full, %d of %d bytes used", stats.Memory, l.MaxMemory)
+	}
+	return ""
+}
+
+// MemSize estimates the memory used by the job in bytes
+func (j *Job) MemSize() int64 {
+	size := int64(jobOverhead + len(j.Id) + len(j.Name) + len(j.CreatedAt) + len(j.CreatedBy) + len(j.ModifiedAt) +
+		len(j.ModifiedBy) + len(j.QueuedAt) + len(j.SrcUrl) + len(j.DstUrl) + len(j.Type) + len(j.Status) + len(j.FileC4Id) +
+		len(j.FileModified) + len(j.ErrorMsg) + len(j.ErrorCode) + len(j.BatchId) + len(j.RequestId))
+	for _, digestType := range j.DigestTypes {
+		size += int64(len(digestType))
+	}
+	size += mapSize(j.Digests)
+	size += mapSize(j.TraceContext)
+	for _, file := range j.Files {
+		size += int64(len(file.Path)+len(file.LastModified)+len(file.FileC4Id)+len(file.ErrorMsg)) + mapSize(file.Digests)
+	}
+	if j.Checkpoint != nil {
+		size += int64(len(j.Checkpoint.ETag))
+		for name, state := range j.Checkpoint.States {
+			size += int64(len(name) + len(state))
+		}
+	}
+	return size
+}
+
+func mapSize(m map[string]string) int64 {
+	var size int64
+	for key, value := range m {
+		size += int64(len(key) + len(value))
+	}
+	return size
+}
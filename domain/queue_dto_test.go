@@ -0,0 +1,33 @@
+package domain
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestQueueLimitsNoLimits(t *testing.T) {
+	limits := QueueLimits{}
+	assert.False(t, limits.Enabled())
+	assert.EqualValues(t, "", limits.Full(QueueStats{Queued: 1000, Memory: 1 << 40}, 1))
+}
+
+func TestQueueLimitsMaxQueued(t *testing.T) {
+	limits := QueueLimits{MaxQueued: 2}
+	assert.EqualValues(t, "", limits.Full(QueueStats{Queued: 1}, 1))
+	assert.EqualValues(t, "queue is full, 1 of 2 jobs queued", limits.Full(QueueStats{Queued: 1}, 2))
+}
+
+func TestQueueLimitsMaxMemory(t *testing.T) {
+	limits := QueueLimits{MaxMemory: 1000}
+	assert.EqualValues(t, "", limits.Full(QueueStats{Memory: 999}, 1))
+	assert.EqualValues(t, "job store is full, 1000 of 1000 bytes used", limits.Full(QueueStats{Memory: 1000}, 1))
+}
+
+func TestMemSizeGrowsWithFiles(t *testing.T) {
+	job := Job{Id: "id", SrcUrl: "https://server/container/file"}
+	size := job.MemSize()
+	assert.EqualValues(t, jobOverhead+2+29, size)
+	job.Files = append(job.Files, TreeFile{Path: "file", Digests: map[string]string{"md5": "abc"}})
+	assert.EqualValues(t, size+10, job.MemSize())
+}
@@ -0,0 +1,19 @@
+package domain
+
+// QuotaUsage holds the counters of one client. Rate limits count per API key or IP (Throttled),
+// quotas per user (QueuedJobs, BytesToday, Rejected).
+type QuotaUsage struct {
+	Client     string `json:"client"`
+	QueuedJobs int    `json:"queued_jobs"`
+	BytesToday int64  `json:"bytes_today"`
+	Throttled  int64  `json:"throttled"`
+	Rejected   int64  `json:"rejected"`
+}
+
+type QuotaReport struct {
+	RateLimit      int          `json:"rate_limit_per_minute"`
+	RateBurst      int          `json:"rate_burst"`
+	MaxQueuedJobs  int          `json:"max_queued_jobs"`
+	MaxBytesPerDay int64        `json:"max_bytes_per_day"`
+	Clients        []QuotaUsage `json:"clients"`
+}
@@ -0,0 +1,129 @@
+package domain
+
+import (
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+)
+
+const (
+	rolePrincipalPrefix = "role:"
+)
+
+// SourcePolicy restricts the sources jobs may read. Empty lists allow everything. Accounts and
+// containers only apply to storage account URLs, prefixes are compared against the cleaned URL
+// and only match whole path segments. Prefixes should be loaded with NormalizePrefixes.
+type SourcePolicy struct {
+	Schemes               []string
+	Accounts              []string
+	Containers            []string
+	AllowedPrefixes       []string
+	DeniedPrefixes        []string
+	DestructivePrincipals []string
+}
+
+// IsDestructive reports whether processing the job deletes or moves its source
+func (j *Job) IsDestructive() bool {
+	return j.Type == JobTypeCreateAndRename
+}
+
+// Check evaluates the policy for a job created or changed by the caller
+func (p SourcePolicy) Check(job Job, caller Caller) api_error.ApiErr {
+	u, err := url.Parse(job.SrcUrl)
+	if err != nil || u.Scheme == "" {
+		return WithCode(CodeInvalidSourceUrl, api_error.NewBadRequestError("invalid source Url"))
+	}
+	scheme := strings.ToLower(u.Scheme)
+	if len(p.Schemes) > 0 && !containsFold(p.Schemes, scheme) {
+		return WithCode(CodeSourceDenied, api_error.NewUnauthorizedError(fmt.Sprintf("source Url scheme %v is not allowed, allowed are %v", scheme, strings.Join(p.Schemes, ", "))))
+	}
+	cleaned := path.Clean("/" + u.Path)
+	if scheme != "file" {
+		account := strings.SplitN(strings.ToLower(u.Hostname()), ".", 2)[0]
+		if len(p.Accounts) > 0 && !containsFold(p.Accounts, account) {
+			return WithCode(CodeSourceDenied, api_error.NewUnauthorizedError(fmt.Sprintf("storage account %v is not allowed", account)))
+		}
+		container := strings.SplitN(strings.TrimPrefix(cleaned, "/"), "/", 2)[0]
+		if len(p.Containers) > 0 && !containsFold(p.Containers, container) {
+			return WithCode(CodeSourceDenied, api_error.NewUnauthorizedError(fmt.Sprintf("container %v is not allowed", container)))
+		}
+	}
+	normalized := normalizeUrl(u)
+	for _, prefix := range p.DeniedPrefixes {
+		if hasPrefix(normalized, prefix) {
+			return WithCode(CodeSourceDenied, api_error.NewUnauthorizedError(fmt.Sprintf("source Url matches denied prefix %v", prefix)))
+		}
+	}
+	if len(p.AllowedPrefixes) > 0 && !hasAnyPrefix(normalized, p.AllowedPrefixes) {
+		return WithCode(CodeSourceDenied, api_error.NewUnauthorizedError("source Url does not match any allowed prefix"))
+	}
+	if job.IsDestructive() && len(p.DestructivePrincipals) > 0 && !p.mayDestroy(caller) {
+		return WithCode(CodeSourceDenied, api_error.NewUnauthorizedError(fmt.Sprintf("job type %v deletes the source and is not allowed for %v", job.Type, caller.Name)))
+	}
+	return nil
+}
+
+// mayDestroy matches the caller against the principals, given as caller names or as role:<role>
+func (p SourcePolicy) mayDestroy(caller Caller) bool {
+	for _, principal := range p.DestructivePrincipals {
+		if strings.HasPrefix(principal, rolePrincipalPrefix) {
+			if caller.HasRole(strings.TrimPrefix(principal, rolePrincipalPrefix)) {
+				return true
+			}
+		} else if caller.Name != "" && principal == caller.Name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasAnyPrefix(value string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if hasPrefix(value, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// hasPrefix matches whole path segments, so that a prefix .../ingest does not match .../ingest-private
+func hasPrefix(value string, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return value == prefix || strings.HasPrefix(value, prefix+"/")
+}
+
+// normalizeUrl lower-cases scheme and host and cleans the path of a URL
+func normalizeUrl(u *url.URL) string {
+	return strings.ToLower(u.Scheme) + "://" + strings.ToLower(u.Host) + path.Clean("/"+u.Path)
+}
+
+// NormalizePrefixes normalizes configured URL prefixes the same way as the source URLs they are
+// compared against. A trailing slash is kept. Prefixes that are no URLs are kept as they are.
+func NormalizePrefixes(prefixes []string) []string {
+	normalized := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		u, err := url.Parse(prefix)
+		if err != nil || u.Scheme == "" {
+			normalized = append(normalized, prefix)
+			continue
+		}
+		value := normalizeUrl(u)
+		if strings.HasSuffix(u.Path, "/") && !strings.HasSuffix(value, "/") {
+			value += "/"
+		}
+		normalized = append(normalized, value)
+	}
+	return normalized
+}
@@ -0,0 +1,70 @@
+package domain
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSourcePolicyEmptyAllowsAll(t *testing.T) {
+	job := Job{Type: JobTypeCreateAndRename, SrcUrl: "https://account.blob.core.windows.net/media/file.ext"}
+	assert.Nil(t, SourcePolicy{}.Check(job, Caller{}))
+}
+
+func TestSourcePolicyAccountsAndContainers(t *testing.T) {
+	policy := SourcePolicy{Schemes: []string{"https"}, Accounts: []string{"media"}, Containers: []string{"ingest", "archive"}}
+	caller := Caller{Name: "alice"}
+	assert.Nil(t, policy.Check(Job{SrcUrl: "https://media.blob.core.windows.net/ingest/path/file.ext"}, caller))
+	err := policy.Check(Job{SrcUrl: "http://media.blob.core.windows.net/ingest/file.ext"}, caller)
+	assert.EqualValues(t, http.StatusForbidden, err.StatusCode())
+	assert.EqualValues(t, "source Url scheme http is not allowed, allowed are https", err.Message())
+	err = policy.Check(Job{SrcUrl: "https://other.blob.core.windows.net/ingest/file.ext"}, caller)
+	assert.EqualValues(t, "storage account other is not allowed", err.Message())
+	err = policy.Check(Job{SrcUrl: "https://media.blob.core.windows.net/ingest/../secret/file.ext"}, caller)
+	assert.EqualValues(t, "container secret is not allowed", err.Message())
+}
+
+func TestSourcePolicyPrefixes(t *testing.T) {
+	policy := SourcePolicy{
+		AllowedPrefixes: []string{"https://media.blob.core.windows.net/ingest/", "file:///data/"},
+		DeniedPrefixes:  []string{"https://media.blob.core.windows.net/ingest/private/"},
+	}
+	assert.Nil(t, policy.Check(Job{SrcUrl: "file:///data/tree"}, Caller{}))
+	err := policy.Check(Job{SrcUrl: "https://media.blob.core.windows.net/ingest/private/file.ext"}, Caller{})
+	assert.EqualValues(t, "source Url matches denied prefix https://media.blob.core.windows.net/ingest/private/", err.Message())
+	err = policy.Check(Job{SrcUrl: "file:///data/../etc/passwd"}, Caller{})
+	assert.EqualValues(t, "source Url does not match any allowed prefix", err.Message())
+}
+
+func TestSourcePolicyPrefixesMatchWholeSegments(t *testing.T) {
+	policy := SourcePolicy{
+		AllowedPrefixes: []string{"https://media.blob.core.windows.net/ingest"},
+		DeniedPrefixes:  []string{"https://media.blob.core.windows.net/ingest/private"},
+	}
+	assert.Nil(t, policy.Check(Job{SrcUrl: "https://media.blob.core.windows.net/ingest/file.ext"}, Caller{}))
+	assert.Nil(t, policy.Check(Job{SrcUrl: "https://media.blob.core.windows.net/ingest/private-not/file.ext"}, Caller{}))
+	err := policy.Check(Job{SrcUrl: "https://media.blob.core.windows.net/ingest-other/file.ext"}, Caller{})
+	assert.EqualValues(t, "source Url does not match any allowed prefix", err.Message())
+	err = policy.Check(Job{SrcUrl: "https://media.blob.core.windows.net/ingest/private/file.ext"}, Caller{})
+	assert.NotNil(t, err)
+}
+
+func TestNormalizePrefixes(t *testing.T) {
+	prefixes := NormalizePrefixes([]string{"HTTPS://Media.Blob.Core.Windows.Net/Ingest/./raw/", "file:///data//tree", "not a url"})
+	assert.EqualValues(t, []string{"https://media.blob.core.windows.net/Ingest/raw/", "file:///data/tree", "not a url"}, prefixes)
+	policy := SourcePolicy{DeniedPrefixes: NormalizePrefixes([]string{"HTTPS://Media.Blob.Core.Windows.Net/ingest/"})}
+	assert.NotNil(t, policy.Check(Job{SrcUrl: "https://media.blob.core.windows.net/ingest/file.ext"}, Caller{}))
+}
+
+func TestSourcePolicyDestructivePrincipals(t *testing.T) {
+	policy := SourcePolicy{DestructivePrincipals: []string{"ingest-bot", "role:admin"}}
+	job := Job{Type: JobTypeCreateAndRename, SrcUrl: "https://media.blob.core.windows.net/ingest/file.ext"}
+	assert.Nil(t, policy.Check(job, Caller{Name: "ingest-bot", Roles: []string{RoleSubmitter}}))
+	assert.Nil(t, policy.Check(job, Caller{Name: "carol", Roles: []string{RoleAdmin}}))
+	err := policy.Check(job, Caller{Name: "alice", Roles: []string{RoleSubmitter}})
+	assert.EqualValues(t, http.StatusForbidden, err.StatusCode())
+	assert.EqualValues(t, "job type CreateAndRename deletes the source and is not allowed for alice", err.Message())
+	job.Type = JobTypeCreate
+	assert.Nil(t, policy.Check(job, Caller{Name: "alice", Roles: []string{RoleSubmitter}}))
+}
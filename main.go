@@ -1,7 +1,44 @@
 package main
 
-import "github.com/johannes-kuhfuss/c4svc/app"
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/johannes-kuhfuss/c4svc/app"
+	"github.com/johannes-kuhfuss/c4svc/config"
+)
 
 func main() {
-	app.StartApp()
+	cfg := config.New()
+	printConfig, err := cfg.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	if printConfig {
+		cfg.PrintEffective(os.Stdout)
+		return
+	}
+	application, err := app.New(cfg)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		application.Stop(context.Background())
+	}()
+	if err := application.Start(); err != nil {
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,105 @@
+package metrics
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const (
+	namespace = "c4svc"
+)
+
+// Metrics holds the collectors of one application instance, registered with its own registry
+type Metrics struct {
+	registry           *prometheus.Registry
+	JobsProcessed      *prometheus.CounterVec
+	JobsFailed         *prometheus.CounterVec
+	ProcessingDuration *prometheus.HistogramVec
+	BytesHashed        *prometheus.HistogramVec
+	CleanupDeletions   *prometheus.CounterVec
+	WorkerBusy         prometheus.Gauge
+	WorkerBusySeconds  prometheus.Counter
+	HttpRequests       *prometheus.CounterVec
+	HttpDuration       *prometheus.HistogramVec
+}
+
+// New creates the collectors in a new registry. The queue depth and saturation are read from jobDao at scrape time.
+func New(jobDao domain.JobDao, limits domain.QueueLimits) *Metrics {
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(collectors.NewGoCollector())
+	registry.MustRegister(collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	registry.MustRegister(&queueCollector{jobDao: jobDao, limits: limits})
+	factory := promauto.With(registry)
+	return &Metrics{
+		registry: registry,
+		JobsProcessed: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_processed_total",
+			Help:      "Number of jobs processed successfully, by job type.",
+		}, []string{"type"}),
+		JobsFailed: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "jobs_failed_total",
+			Help:      "Number of jobs that failed, by job type and error class.",
+		}, []string{"type", "error_class"}),
+		ProcessingDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_processing_duration_seconds",
+			Help:      "Time spent processing a job, by job type.",
+			Buckets:   prometheus.ExponentialBuckets(0.1, 4, 10),
+		}, []string{"type"}),
+		BytesHashed: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "job_bytes_hashed",
+			Help:      "Number of bytes hashed per job, by job type.",
+			Buckets:   prometheus.ExponentialBuckets(1024, 16, 8),
+		}, []string{"type"}),
+		CleanupDeletions: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cleanup_deletions_total",
+			Help:      "Number of entries removed by the cleanup, by kind.",
+		}, []string{"kind"}),
+		WorkerBusy: factory.NewGauge(prometheus.GaugeOpts{
+			Namespace: namespace,
+			Name:      "worker_busy",
+			Help:      "Number of job processors currently processing a job.",
+		}),
+		WorkerBusySeconds: factory.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "worker_busy_seconds_total",
+			Help:      "Time job processors spent processing jobs. The rate is the worker utilization.",
+		}),
+		HttpRequests: factory.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "http_requests_total",
+			Help:      "Number of HTTP requests, by method, route and status code.",
+		}, []string{"method", "route", "status"}),
+		HttpDuration: factory.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Time spent serving HTTP requests, by method and route.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"method", "route"}),
+	}
+}
+
+// Handler serves the metrics of this instance in the Prometheus text format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{})
+}
+
+// ErrorClass turns the status code of an error into a label value such as "bad_request"
+func ErrorClass(err api_error.ApiErr) string {
+	text := http.StatusText(err.StatusCode())
+	if text == "" {
+		return "unknown"
+	}
+	return strings.ToLower(strings.ReplaceAll(text, " ", "_"))
+}
@@ -0,0 +1,57 @@
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestErrorClass(t *testing.T) {
+	assert.EqualValues(t, "bad_request", ErrorClass(api_error.NewBadRequestError("bad")))
+	assert.EqualValues(t, "internal_server_error", ErrorClass(api_error.NewInternalServerError("failed", nil)))
+	assert.EqualValues(t, "unknown", ErrorClass(api_error.NewError("odd", 599, nil)))
+}
+
+func TestGinMiddlewareUsesRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	m := New(domain.NewJobDao(), domain.QueueLimits{})
+	router := gin.New()
+	router.Use(m.GinMiddleware())
+	router.GET("/job/:job_id", func(c *gin.Context) {
+		c.String(http.StatusOK, "ok")
+	})
+	for _, path := range []string{"/job/1", "/job/2", "/nothing"} {
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+	assert.EqualValues(t, 2, testutil.ToFloat64(m.HttpRequests.WithLabelValues(http.MethodGet, "/job/:job_id", "200")))
+	assert.EqualValues(t, 1, testutil.ToFloat64(m.HttpRequests.WithLabelValues(http.MethodGet, unmatchedRoute, "404")))
+}
+
+func TestQueueCollectorReportsAllStatuses(t *testing.T) {
+	assert.EqualValues(t, 5, testutil.CollectAndCount(&queueCollector{jobDao: domain.NewJobDao()}, "c4svc_jobs"))
+}
+
+func TestQueueCollectorReportsSaturation(t *testing.T) {
+	jobDao := domain.NewJobDao()
+	collector := &queueCollector{jobDao: jobDao, limits: domain.QueueLimits{MaxQueued: 1}}
+	expected := "# HELP c4svc_queue_saturated 1 while the queue limits are reached and new jobs are rejected, else 0.\n# TYPE c4svc_queue_saturated gauge\nc4svc_queue_saturated %v\n"
+	assert.Nil(t, testutil.CollectAndCompare(collector, strings.NewReader(fmt.Sprintf(expected, 0)), "c4svc_queue_saturated"))
+	jobDao.Save(domain.Job{Id: "id", Status: domain.JobStatusCreated}, false)
+	assert.Nil(t, testutil.CollectAndCompare(collector, strings.NewReader(fmt.Sprintf(expected, 1)), "c4svc_queue_saturated"))
+}
+
+func TestNewUsesSeparateRegistries(t *testing.T) {
+	m1 := New(domain.NewJobDao(), domain.QueueLimits{})
+	m2 := New(domain.NewJobDao(), domain.QueueLimits{})
+	m1.JobsProcessed.WithLabelValues("Create").Inc()
+	assert.EqualValues(t, 1, testutil.ToFloat64(m1.JobsProcessed.WithLabelValues("Create")))
+	assert.EqualValues(t, 0, testutil.ToFloat64(m2.JobsProcessed.WithLabelValues("Create")))
+}
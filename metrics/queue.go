@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+var (
+	queueDepthDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "jobs"),
+		"Number of jobs in the job store, by status.",
+		[]string{"status"}, nil,
+	)
+	queueMemoryDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "jobs_memory_bytes"),
+		"Estimated memory used by the jobs in the job store.",
+		nil, nil,
+	)
+	queueSaturatedDesc = prometheus.NewDesc(
+		prometheus.BuildFQName(namespace, "", "queue_saturated"),
+		"1 while the queue limits are reached and new jobs are rejected, else 0.",
+		nil, nil,
+	)
+)
+
+// queueCollector counts the jobs by status at scrape time, so the numbers always match the job store
+type queueCollector struct {
+	jobDao domain.JobDao
+	limits domain.QueueLimits
+}
+
+func (qc *queueCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- queueMemoryDesc
+	ch <- queueSaturatedDesc
+}
+
+func (qc *queueCollector) Collect(ch chan<- prometheus.Metric) {
+	counts := map[string]int{
+		domain.JobStatusCreated:  0,
+		domain.JobStatusRunning:  0,
+		domain.JobStatusFinished: 0,
+		domain.JobStatusFailed:   0,
+		domain.JobStatusPartial:  0,
+	}
+	if jobs, err := qc.jobDao.GetAll(); err == nil {
+		for _, job := range *jobs {
+			counts[string(job.Status)]++
+		}
+	}
+	for status, count := range counts {
+		ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(count), status)
+	}
+	stats := qc.jobDao.Stats()
+	saturated := 0.0
+	if qc.limits.Full(stats, 1) != "" {
+		saturated = 1
+	}
+	ch <- prometheus.MustNewConstMetric(queueMemoryDesc, prometheus.GaugeValue, float64(stats.Memory))
+	ch <- prometheus.MustNewConstMetric(queueSaturatedDesc, prometheus.GaugeValue, saturated)
+}
@@ -0,0 +1,97 @@
+package providers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	c4gen "github.com/Avalanche-io/c4/id"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/johannes-kuhfuss/services_utils/date"
+)
+
+const (
+	metaKeyC4Id     = "c4id"
+	metaKeyC4IdTime = "c4idtime"
+	tagKeyC4Id      = "c4id"
+	tagKeyC4IdETag  = "c4idetag"
+)
+
+// metadataValue looks up a metadata key case-insensitively, since the storage service
+// returns metadata names in canonical header form
+func metadataValue(metadata map[string]string, key string) (string, bool) {
+	for k, v := range metadata {
+		if strings.EqualFold(k, key) {
+			return v, true
+		}
+	}
+	return "", false
+}
+
+func mergeMetadata(metadata map[string]string, c4Id string, writeTime time.Time) map[string]string {
+	merged := make(map[string]string)
+	for k, v := range metadata {
+		if strings.EqualFold(k, metaKeyC4Id) || strings.EqualFold(k, metaKeyC4IdTime) {
+			continue
+		}
+		merged[k] = v
+	}
+	merged[metaKeyC4Id] = c4Id
+	merged[metaKeyC4IdTime] = writeTime.Format(date.ApiDateLayout)
+	return merged
+}
+
+// trustedC4Id returns the C4 Id stored in the metadata, as long as the blob still has the ETag
+// recorded after the C4 Id was written. Writing the metadata changes the ETag, so the new ETag is
+// kept in a blob index tag, which can be set without changing the ETag again.
+func trustedC4Id(metadata map[string]string, tags map[string]string, eTag *string) (string, bool) {
+	c4Id, ok := metadataValue(metadata, metaKeyC4Id)
+	if !ok || eTag == nil {
+		return "", false
+	}
+	if _, err := c4gen.Parse(c4Id); err != nil {
+		return "", false
+	}
+	if tagETag, ok := tags[tagKeyC4IdETag]; !ok || tagETag != tagValue(*eTag) {
+		return "", false
+	}
+	return c4Id, true
+}
+
+// tagValue removes the quotes of an ETag, which are not allowed in tag values
+func tagValue(eTag string) string {
+	return strings.Trim(eTag, `"`)
+}
+
+func getTags(ctx context.Context, blob azblob.BlobClient) (map[string]string, error) {
+	resp, err := blob.GetTags(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	tags := make(map[string]string)
+	for _, tag := range resp.BlobTagSet {
+		if tag.Key != nil && tag.Value != nil {
+			tags[*tag.Key] = *tag.Value
+		}
+	}
+	return tags, nil
+}
+
+func mergeTags(tags map[string]string, c4Id string) map[string]string {
+	merged := make(map[string]string)
+	for k, v := range tags {
+		merged[k] = v
+	}
+	merged[tagKeyC4Id] = c4Id
+	return merged
+}
+
+// withETag records the ETag of the blob after its C4 Id metadata was written
+func withETag(tags map[string]string, eTag string) map[string]string {
+	merged := make(map[string]string)
+	for k, v := range tags {
+		merged[k] = v
+	}
+	merged[tagKeyC4IdETag] = tagValue(eTag)
+	return merged
+}
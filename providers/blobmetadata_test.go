@@ -0,0 +1,80 @@
+package providers
+
+import (
+	"testing"
+
+	"github.com/johannes-kuhfuss/services_utils/date"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testC4Id = "c42FTpMRKrEEL6sgwRVRfxbzYDsYZe4VgsNVC7D6Jkqz8ABjsSAybKLYwPLGSJexGkJ9qt3aR8sMAjZ8fhKd7GfQsB"
+)
+
+func TestMetadataValueCaseInsensitive(t *testing.T) {
+	metadata := map[string]string{"C4id": "abc"}
+	value, ok := metadataValue(metadata, "c4id")
+	assert.True(t, ok)
+	assert.EqualValues(t, "abc", value)
+	_, ok = metadataValue(metadata, "other")
+	assert.False(t, ok)
+}
+
+func TestMergeMetadataKeepsExisting(t *testing.T) {
+	writeTime := date.GetNowUtc()
+	metadata := map[string]string{"Owner": "user 1", "C4id": "old"}
+	merged := mergeMetadata(metadata, testC4Id, writeTime)
+	assert.EqualValues(t, 3, len(merged))
+	assert.EqualValues(t, "user 1", merged["Owner"])
+	assert.EqualValues(t, testC4Id, merged[metaKeyC4Id])
+	assert.EqualValues(t, writeTime.Format(date.ApiDateLayout), merged[metaKeyC4IdTime])
+}
+
+func TestMergeTagsKeepsExisting(t *testing.T) {
+	merged := mergeTags(map[string]string{"project": "p1"}, testC4Id)
+	assert.EqualValues(t, 2, len(merged))
+	assert.EqualValues(t, "p1", merged["project"])
+	assert.EqualValues(t, testC4Id, merged[tagKeyC4Id])
+}
+
+func TestTrustedC4IdNoMetadata(t *testing.T) {
+	eTag := `"0x8DA1"`
+	c4Id, ok := trustedC4Id(map[string]string{}, withETag(nil, eTag), &eTag)
+	assert.False(t, ok)
+	assert.EqualValues(t, "", c4Id)
+}
+
+func TestTrustedC4IdInvalidC4Id(t *testing.T) {
+	eTag := `"0x8DA1"`
+	metadata := mergeMetadata(nil, "not a c4 id", date.GetNowUtc())
+	c4Id, ok := trustedC4Id(metadata, withETag(nil, eTag), &eTag)
+	assert.False(t, ok)
+	assert.EqualValues(t, "", c4Id)
+}
+
+func TestTrustedC4IdWithoutETagTag(t *testing.T) {
+	eTag := `"0x8DA1"`
+	metadata := mergeMetadata(nil, testC4Id, date.GetNowUtc())
+	c4Id, ok := trustedC4Id(metadata, mergeTags(nil, testC4Id), &eTag)
+	assert.False(t, ok)
+	assert.EqualValues(t, "", c4Id)
+}
+
+func TestTrustedC4IdModifiedAfterWrite(t *testing.T) {
+	eTag := `"0x8DA2"`
+	metadata := mergeMetadata(nil, testC4Id, date.GetNowUtc())
+	c4Id, ok := trustedC4Id(metadata, withETag(nil, `"0x8DA1"`), &eTag)
+	assert.False(t, ok)
+	assert.EqualValues(t, "", c4Id)
+}
+
+func TestTrustedC4IdUnchanged(t *testing.T) {
+	eTag := `"0x8DA1"`
+	metadata := mergeMetadata(nil, testC4Id, date.GetNowUtc())
+	tags := withETag(map[string]string{"project": "p1"}, eTag)
+	assert.EqualValues(t, "0x8DA1", tags[tagKeyC4IdETag])
+	assert.EqualValues(t, "p1", tags["project"])
+	c4Id, ok := trustedC4Id(metadata, tags, &eTag)
+	assert.True(t, ok)
+	assert.EqualValues(t, testC4Id, c4Id)
+}
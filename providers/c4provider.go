@@ -2,99 +2,313 @@ package providers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
-	c4gen "github.com/Avalanche-io/c4/id"
 	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
 	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/date"
 	"github.com/johannes-kuhfuss/services_utils/logger"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	C4Provider c4ProviderInterface = &c4ProviderService{}
-)
+type c4ProviderService struct {
+	cfg    *config.AppConfig
+	tracer trace.Tracer
+}
+
+type C4Provider interface {
+	ProcessFile(context.Context, domain.Job, FileProgress) (*ProcessResult, api_error.ApiErr)
+	ProcessTree(context.Context, domain.Job, TreeProgress) (*ProcessResult, api_error.ApiErr)
+	CheckStorage(context.Context) api_error.ApiErr
+}
 
-type c4ProviderService struct{}
+type ProcessResult struct {
+	C4Id         string
+	DstUrl       string
+	FromMetadata bool
+	Digests      map[string]string
+	Size         int64
+	LastModified string
+	BytesHashed  int64
+	// FilesFailed counts the files of a tree that could not be identified
+	FilesFailed int
+}
 
-type c4ProviderInterface interface {
-	ProcessFile(string, bool) (*string, *string, api_error.ApiErr)
+func NewC4Provider(cfg *config.AppConfig, tracer trace.Tracer) C4Provider {
+	return &c4ProviderService{
+		cfg:    cfg,
+		tracer: tracer,
+	}
 }
 
-func (c4p *c4ProviderService) ProcessFile(srcUrl string, rename bool) (*string, *string, api_error.ApiErr) {
-	var dstUrl string
-	if strings.TrimSpace(config.StorageAccountName) == "" || strings.TrimSpace(config.StorageAccountKey) == "" {
-		logger.Error("No storage account access credentials", nil)
-		return nil, nil, api_error.NewInternalServerError("No storage account access credentials", nil)
+func (c4p *c4ProviderService) ProcessFile(ctx context.Context, job domain.Job, progress FileProgress) (*ProcessResult, api_error.ApiErr) {
+	rename := job.Type == domain.JobTypeCreateAndRename
+	if strings.TrimSpace(c4p.cfg.StorageAccountName) == "" || strings.TrimSpace(c4p.cfg.StorageAccountKey) == "" {
+		logger.Error("No storage account access credentials", nil, job.LogFields()...)
+		return nil, domain.WithCode(domain.CodeStorageNotConfigured, api_error.NewInternalServerError("No storage account access credentials", nil))
 	}
-	url, err := url.Parse(srcUrl)
-	if err != nil || srcUrl == "" {
-		logger.Error("Cannot parse source URL", nil)
-		return nil, nil, api_error.NewBadRequestError("Cannot parse source URL")
+	url, err := url.Parse(job.SrcUrl)
+	if err != nil || job.SrcUrl == "" {
+		logger.Error("Cannot parse source URL", nil, job.LogFields()...)
+		return nil, domain.WithCode(domain.CodeInvalidSourceUrl, api_error.NewBadRequestError("Cannot parse source URL"))
 	}
 	blobUrl := url.Scheme + "://" + url.Host + "/"
-	logger.Debug(fmt.Sprintf("blobUrl: %v", blobUrl))
+	logger.Debug(fmt.Sprintf("blobUrl: %v", blobUrl), job.LogFields()...)
 	containerName := strings.TrimLeft(filepath.Dir(url.Path), string(os.PathSeparator))
-	logger.Debug(fmt.Sprintf("containerName: %v", containerName))
+	logger.Debug(fmt.Sprintf("containerName: %v", containerName), job.LogFields()...)
 	fileName := filepath.Base(url.Path)
 	fileExt := filepath.Ext(url.Path)
 	if url.Scheme == "" || url.Host == "" || containerName == "." {
-		logger.Error("Cannot parse source URL", nil)
-		return nil, nil, api_error.NewBadRequestError("Cannot parse source URL")
+		logger.Error("Cannot parse source URL", nil, job.LogFields()...)
+		return nil, domain.WithCode(domain.CodeInvalidSourceUrl, api_error.NewBadRequestError("Cannot parse source URL"))
 	}
-	cred, err := azblob.NewSharedKeyCredential(config.StorageAccountName, config.StorageAccountKey)
-	if err != nil {
-		logger.Error("Cannot access storage account - wrong credentials", err)
-		return nil, nil, api_error.NewInternalServerError("Cannot access storage account - wrong credentials", err)
+	container, apiErr := newContainerClient(c4p.cfg, blobUrl, containerName)
+	if apiErr != nil {
+		return nil, apiErr
 	}
-
-	//serviceClient, err := azblob.NewServiceClient(blobUrl, cred, nil)
-	serviceClient, err := azblob.NewServiceClientWithSharedKey(blobUrl, cred, nil)
-
-	if err != nil {
-		logger.Error("Cannot access storage account - could not create service client", err)
-		return nil, nil, api_error.NewInternalServerError("Cannot access storage account - could not create service client", err)
-	}
-	ctx := context.Background()
-	container := serviceClient.NewContainerClient(containerName)
 	blockBlob := container.NewBlobClient(fileName)
-	get, err := blockBlob.Download(ctx, nil)
-	if err != nil {
-		logger.Error("Cannot access file on storage account", err)
-		return nil, nil, api_error.NewBadRequestError("Cannot access file on storage account")
+	result := ProcessResult{}
+	if job.TrustMetadata && len(job.DigestTypes) == 0 {
+		props, err := c4p.getProperties(ctx, blockBlob)
+		if err != nil {
+			logger.Error("Cannot access file on storage account", err, job.LogFields()...)
+			return nil, storageErr(err, domain.WithCode(domain.CodeStorageFileNotAccessible, api_error.NewBadRequestError("Cannot access file on storage account")))
+		}
+		tagsCtx, span := startStorageSpan(ctx, c4p.tracer, "azure.get_tags", blockBlob.URL())
+		tags, err := getTags(tagsCtx, blockBlob)
+		endStorageSpan(span, err)
+		if err != nil {
+			logger.Warn("Cannot read tags of file, not trusting its metadata", job.LogFields()...)
+		}
+		if c4Id, ok := trustedC4Id(props.Metadata, tags, props.ETag); ok {
+			logger.Info(fmt.Sprintf("Using C4 Id from metadata of unchanged file %v", job.SrcUrl), job.LogFields()...)
+			result.C4Id = c4Id
+			result.FromMetadata = true
+			result.setFileInfo(props.ContentLength, props.LastModified)
+		}
+	}
+	var metadata map[string]string
+	var eTag *string
+	if !result.FromMetadata {
+		props, err := c4p.getProperties(ctx, blockBlob)
+		if err != nil || props.ContentLength == nil || props.ETag == nil {
+			logger.Error("Cannot access file on storage account", err, job.LogFields()...)
+			return nil, storageErr(err, domain.WithCode(domain.CodeStorageFileNotAccessible, api_error.NewBadRequestError("Cannot access file on storage account")))
+		}
+		metadata = props.Metadata
+		eTag = props.ETag
+		result.setFileInfo(props.ContentLength, props.LastModified)
+		if apiErr := checkMaxSize(c4p.cfg, result.Size); apiErr != nil {
+			return nil, apiErr
+		}
+		if progress != nil {
+			if apiErr := progress.Start(result.Size); apiErr != nil {
+				logger.Error("Cannot start reading file", apiErr, job.LogFields()...)
+				return nil, apiErr
+			}
+		}
+		source := blobRangeSource{blob: blockBlob, eTag: eTag}
+		hashCtx, span := startStorageSpan(ctx, c4p.tracer, "azure.download", blockBlob.URL())
+		id, digests, hashed, err := hashRanges(hashCtx, c4p.cfg, &source, result.Size, *eTag, job.DigestTypes, job.Checkpoint, progress)
+		endStorageSpan(span, err)
+		if err != nil {
+			logger.Error("Cannot read file on storage account", err, job.LogFields()...)
+			return nil, storageErr(err, domain.WithCode(domain.CodeStorageFileNotAccessible, api_error.NewInternalServerError("Cannot read file on storage account", err)))
+		}
+		result.C4Id = id.String()
+		result.Digests = digests
+		result.BytesHashed = hashed
+	}
+	writeMetadata := job.WriteMetadata && !result.FromMetadata
+	if writeMetadata {
+		metadata = mergeMetadata(metadata, result.C4Id, date.GetNowUtc())
+	}
+	var tags map[string]string
+	// the ETag after writing the metadata is kept in a tag, so tags are needed for metadata as well
+	if job.WriteTags || writeMetadata {
+		tagsCtx, span := startStorageSpan(ctx, c4p.tracer, "azure.get_tags", blockBlob.URL())
+		tags, err = getTags(tagsCtx, blockBlob)
+		endStorageSpan(span, err)
+		if err != nil {
+			logger.Error("Cannot read tags of file", err, job.LogFields()...)
+			return nil, storageErr(err, domain.WithCode(domain.CodeStorageFileNotAccessible, api_error.NewInternalServerError("Cannot read tags of file", err)))
+		}
+		if job.WriteTags {
+			tags = mergeTags(tags, result.C4Id)
+		}
 	}
-	reader := get.Body(azblob.RetryReaderOptions{})
-	id := c4gen.Identify(reader)
-	c4string := id.String()
 	if rename {
 		lease, err := blockBlob.NewBlobLeaseClient(nil)
 		if err != nil {
-			logger.Error("Cannot get lease on file", err)
-			return nil, nil, api_error.NewInternalServerError("Cannot get lease on file", err)
+			logger.Error("Cannot get lease on file", err, job.LogFields()...)
+			return nil, storageErr(err, domain.WithCode(domain.CodeStorageWriteFailed, api_error.NewInternalServerError("Cannot get lease on file", err)))
 		}
 		lease.AcquireLease(ctx, &azblob.AcquireLeaseBlobOptions{})
 		defer lease.BreakLease(ctx, nil)
-		newFileName := id.String() + fileExt
+		newFileName := result.C4Id + fileExt
 		newBlockBlob := container.NewBlobClient(newFileName)
-		_, err = newBlockBlob.StartCopyFromURL(ctx, blockBlob.URL(), nil)
+		copyOptions := azblob.StartCopyBlobOptions{
+			TagsMap: tags,
+		}
+		if writeMetadata {
+			copyOptions.Metadata = metadata
+		}
+		copyCtx, span := startStorageSpan(ctx, c4p.tracer, "azure.copy", newBlockBlob.URL())
+		copyResp, err := newBlockBlob.StartCopyFromURL(copyCtx, blockBlob.URL(), &copyOptions)
+		endStorageSpan(span, err)
 		if err != nil {
-			logger.Error("Renaming of file failed", err)
-			return nil, nil, api_error.NewInternalServerError("Renaming of file failed", err)
+			logger.Error("Renaming of file failed", err, job.LogFields()...)
+			return nil, storageErr(err, domain.WithCode(domain.CodeStorageWriteFailed, api_error.NewInternalServerError("Renaming of file failed", err)))
 		}
-		_, err = blockBlob.Delete(ctx, nil)
+		// the ETag of the copy is only final once the copy completed synchronously
+		if writeMetadata && copyResp.CopyStatus != nil && *copyResp.CopyStatus == azblob.CopyStatusTypeSuccess && copyResp.ETag != nil {
+			if apiErr := c4p.setTags(ctx, newBlockBlob, withETag(tags, *copyResp.ETag), job); apiErr != nil {
+				return nil, apiErr
+			}
+		}
+		deleteCtx, span := startStorageSpan(ctx, c4p.tracer, "azure.delete", blockBlob.URL())
+		_, err = blockBlob.Delete(deleteCtx, nil)
+		endStorageSpan(span, err)
 		if err != nil {
-			logger.Error("Deleting of source file failed", err)
-			return nil, nil, api_error.NewInternalServerError("Deleting of source file failed", err)
+			logger.Error("Deleting of source file failed", err, job.LogFields()...)
+			return nil, storageErr(err, domain.WithCode(domain.CodeStorageWriteFailed, api_error.NewInternalServerError("Deleting of source file failed", err)))
 		}
-		dstUrl = blobUrl + containerName + "/" + c4string + fileExt
+		result.DstUrl = blobUrl + containerName + "/" + result.C4Id + fileExt
+		return &result, nil
 	}
-	if rename {
-		return &c4string, &dstUrl, nil
-	} else {
-		return &c4string, nil, nil
+	if writeMetadata {
+		options := azblob.SetBlobMetadataOptions{
+			ModifiedAccessConditions: &azblob.ModifiedAccessConditions{
+				IfMatch: eTag,
+			},
+		}
+		metadataCtx, span := startStorageSpan(ctx, c4p.tracer, "azure.set_metadata", blockBlob.URL())
+		metadataResp, err := blockBlob.SetMetadata(metadataCtx, metadata, &options)
+		endStorageSpan(span, err)
+		if err != nil {
+			logger.Error("Cannot write C4 Id to metadata of file", err, job.LogFields()...)
+			return nil, storageErr(err, domain.WithCode(domain.CodeStorageWriteFailed, api_error.NewInternalServerError("Cannot write C4 Id to metadata of file", err)))
+		}
+		if metadataResp.ETag != nil {
+			tags = withETag(tags, *metadataResp.ETag)
+		}
+	}
+	if job.WriteTags || writeMetadata {
+		if apiErr := c4p.setTags(ctx, blockBlob, tags, job); apiErr != nil {
+			return nil, apiErr
+		}
+	}
+	return &result, nil
+}
+
+func (c4p *c4ProviderService) setTags(ctx context.Context, blob azblob.BlobClient, tags map[string]string, job domain.Job) api_error.ApiErr {
+	ctx, span := startStorageSpan(ctx, c4p.tracer, "azure.set_tags", blob.URL())
+	_, err := blob.SetTags(ctx, &azblob.SetTagsBlobOptions{TagsMap: tags})
+	endStorageSpan(span, err)
+	if err != nil {
+		logger.Error("Cannot write C4 Id to tags of file", err, job.LogFields()...)
+		return storageErr(err, domain.WithCode(domain.CodeStorageWriteFailed, api_error.NewInternalServerError("Cannot write C4 Id to tags of file", err)))
+	}
+	return nil
+}
+
+// storageErr reports requests the storage service rejected with 401 or 403 as storage_auth_failed,
+// since they are caused by the credentials or permissions of the service, not by the job.
+// Other failures are reported as fallback.
+func storageErr(err error, fallback api_error.ApiErr) api_error.ApiErr {
+	if status := storageStatus(err); status == http.StatusUnauthorized || status == http.StatusForbidden {
+		msg := fmt.Sprintf("%v - storage account rejected the credentials", fallback.Message())
+		return domain.WithCode(domain.CodeStorageAuthFailed, api_error.NewError(msg, http.StatusBadGateway, []interface{}{err.Error()}))
+	}
+	return fallback
+}
+
+// storageStatus returns the HTTP status of a failed storage request, or 0 if there was no response
+func storageStatus(err error) int {
+	var storageError *azblob.StorageError
+	if errors.As(err, &storageError) && storageError.Response() != nil {
+		return storageError.StatusCode()
+	}
+	// responses without body, e.g. to HEAD requests, are not turned into a StorageError
+	var responseError interface{ RawResponse() *http.Response }
+	if errors.As(err, &responseError) && responseError.RawResponse() != nil {
+		return responseError.RawResponse().StatusCode
+	}
+	return 0
+}
+
+func (c4p *c4ProviderService) getProperties(ctx context.Context, blob azblob.BlobClient) (azblob.GetBlobPropertiesResponse, error) {
+	ctx, span := startStorageSpan(ctx, c4p.tracer, "azure.get_properties", blob.URL())
+	props, err := blob.GetProperties(ctx, nil)
+	endStorageSpan(span, err)
+	return props, err
+}
+
+func (r *ProcessResult) setFileInfo(size *int64, lastModified *time.Time) {
+	if size != nil {
+		r.Size = *size
+	}
+	if lastModified != nil {
+		r.LastModified = lastModified.UTC().Format(date.ApiDateLayout)
+	}
+}
+
+// checkMaxSize rejects sources larger than the configured policy maximum
+func checkMaxSize(cfg *config.AppConfig, size int64) api_error.ApiErr {
+	if cfg.PolicyMaxSize > 0 && size > cfg.PolicyMaxSize {
+		msg := fmt.Sprintf("Source size of %d bytes exceeds the maximum of %d bytes", size, cfg.PolicyMaxSize)
+		logger.Error(msg, nil)
+		return domain.WithCode(domain.CodeSourceTooLarge, api_error.NewBadRequestError(msg))
+	}
+	return nil
+}
+
+func newContainerClient(cfg *config.AppConfig, blobUrl string, containerName string) (*azblob.ContainerClient, api_error.ApiErr) {
+	serviceClient, apiErr := newServiceClient(cfg, blobUrl)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	container := serviceClient.NewContainerClient(containerName)
+	return &container, nil
+}
+
+func newServiceClient(cfg *config.AppConfig, blobUrl string) (*azblob.ServiceClient, api_error.ApiErr) {
+	cred, err := azblob.NewSharedKeyCredential(cfg.StorageAccountName, cfg.StorageAccountKey)
+	if err != nil {
+		logger.Error("Cannot access storage account - wrong credentials", err)
+		return nil, domain.WithCode(domain.CodeStorageAuthFailed, api_error.NewInternalServerError("Cannot access storage account - wrong credentials", err))
+	}
+
+	//serviceClient, err := azblob.NewServiceClient(blobUrl, cred, nil)
+	serviceClient, err := azblob.NewServiceClientWithSharedKey(blobUrl, cred, nil)
+
+	if err != nil {
+		logger.Error("Cannot access storage account - could not create service client", err)
+		return nil, domain.WithCode(domain.CodeStorageAuthFailed, api_error.NewInternalServerError("Cannot access storage account - could not create service client", err))
+	}
+	return &serviceClient, nil
+}
+
+// CheckStorage verifies that the configured storage account answers with the configured credentials
+func (c4p *c4ProviderService) CheckStorage(ctx context.Context) api_error.ApiErr {
+	if strings.TrimSpace(c4p.cfg.StorageAccountName) == "" || strings.TrimSpace(c4p.cfg.StorageAccountKey) == "" {
+		return domain.WithCode(domain.CodeStorageNotConfigured, api_error.NewInternalServerError("No storage account access credentials", nil))
+	}
+	serviceClient, apiErr := newServiceClient(c4p.cfg, fmt.Sprintf("https://%v.blob.core.windows.net/", c4p.cfg.StorageAccountName))
+	if apiErr != nil {
+		return apiErr
+	}
+	if _, err := serviceClient.GetProperties(ctx); err != nil {
+		return storageErr(err, domain.WithCode(domain.CodeStorageUnavailable, api_error.NewInternalServerError("Storage account does not answer", err)))
 	}
+	return nil
 }
@@ -1,14 +1,21 @@
 package providers
 
 import (
+	"context"
+	"errors"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"testing"
 
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
 	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
 	"github.com/johannes-kuhfuss/services_utils/logger"
 	"github.com/joho/godotenv"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/trace"
 )
 
 const (
@@ -16,85 +23,121 @@ const (
 	testUrlBad  = "https://mediajku.blob.core.windows.net/media-test/noexist.tif"
 )
 
-func initConfig() {
+var (
+	noopTracer = trace.NewNoopTracerProvider().Tracer("")
+)
+
+func initConfig() *config.AppConfig {
 	err := godotenv.Load("../.env")
 	if err != nil {
 		logger.Error("Could not open env file", err)
 	}
-	config.StorageAccountName = os.Getenv("STORAGE_ACCOUNT_NAME")
-	config.StorageAccountKey = os.Getenv("STORAGE_ACCOUNT_KEY")
+	cfg := config.New()
+	cfg.StorageAccountName = os.Getenv("STORAGE_ACCOUNT_NAME")
+	cfg.StorageAccountKey = os.Getenv("STORAGE_ACCOUNT_KEY")
+	return cfg
+}
+
+func dummyConfig() *config.AppConfig {
+	cfg := config.New()
+	cfg.StorageAccountName = "dummy"
+	cfg.StorageAccountKey = "dummy"
+	return cfg
 }
 
 func TestProcessFileNoAccessCred(t *testing.T) {
-	c4Id, dstUrl, err := C4Provider.ProcessFile("", false)
+	cfg := config.New()
+	result, err := NewC4Provider(cfg, noopTracer).ProcessFile(context.Background(), domain.Job{SrcUrl: ""}, nil)
 	assert.NotNil(t, err)
-	assert.Nil(t, c4Id)
-	assert.Nil(t, dstUrl)
+	assert.Nil(t, result)
 	assert.EqualValues(t, http.StatusInternalServerError, err.StatusCode())
 	assert.EqualValues(t, "No storage account access credentials", err.Message())
 }
 
 func TestProcessFileEmptyUrl(t *testing.T) {
-	config.StorageAccountName = "dummy"
-	config.StorageAccountKey = "dummy"
-	c4Id, dstUrl, err := C4Provider.ProcessFile("", false)
-	assert.Nil(t, c4Id)
-	assert.Nil(t, dstUrl)
+	cfg := dummyConfig()
+	result, err := NewC4Provider(cfg, noopTracer).ProcessFile(context.Background(), domain.Job{SrcUrl: ""}, nil)
+	assert.Nil(t, result)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
 	assert.EqualValues(t, "Cannot parse source URL", err.Message())
 }
 
 func TestProcessFileUrlParseError(t *testing.T) {
-	config.StorageAccountName = "dummy"
-	config.StorageAccountKey = "dummy"
+	cfg := dummyConfig()
 	dummyUrl := "abcdefg"
-	c4Id, dstUrl, err := C4Provider.ProcessFile(dummyUrl, false)
-	assert.Nil(t, c4Id)
-	assert.Nil(t, dstUrl)
+	result, err := NewC4Provider(cfg, noopTracer).ProcessFile(context.Background(), domain.Job{SrcUrl: dummyUrl}, nil)
+	assert.Nil(t, result)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
 	assert.EqualValues(t, "Cannot parse source URL", err.Message())
 }
 
 func TestProcessFileWrongCredentials(t *testing.T) {
-	config.StorageAccountName = "dummy"
-	config.StorageAccountKey = "dummy"
-	c4Id, dstUrl, err := C4Provider.ProcessFile(testUrlGood, false)
-	assert.Nil(t, c4Id)
-	assert.Nil(t, dstUrl)
+	cfg := dummyConfig()
+	result, err := NewC4Provider(cfg, noopTracer).ProcessFile(context.Background(), domain.Job{SrcUrl: testUrlGood}, nil)
+	assert.Nil(t, result)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusInternalServerError, err.StatusCode())
 	assert.EqualValues(t, "Cannot access storage account - wrong credentials", err.Message())
 }
 
 func TestProcessFileFileNotFoundError(t *testing.T) {
-	initConfig()
-	c4Id, dstUrl, err := C4Provider.ProcessFile(testUrlBad, false)
-	assert.Nil(t, c4Id)
-	assert.Nil(t, dstUrl)
+	cfg := initConfig()
+	result, err := NewC4Provider(cfg, noopTracer).ProcessFile(context.Background(), domain.Job{SrcUrl: testUrlBad}, nil)
+	assert.Nil(t, result)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
 	assert.EqualValues(t, "Cannot access file on storage account", err.Message())
 }
 
 func TestProcessFileNoErrorNoRename(t *testing.T) {
-	initConfig()
-	c4Id, dstUrl, err := C4Provider.ProcessFile(testUrlGood, false)
-	assert.NotNil(t, c4Id)
-	assert.Nil(t, dstUrl)
+	cfg := initConfig()
+	result, err := NewC4Provider(cfg, noopTracer).ProcessFile(context.Background(), domain.Job{SrcUrl: testUrlGood, Type: domain.JobTypeCreate}, nil)
+	assert.NotNil(t, result)
 	assert.Nil(t, err)
-	assert.EqualValues(t, "c42FTpMRKrEEL6sgwRVRfxbzYDsYZe4VgsNVC7D6Jkqz8ABjsSAybKLYwPLGSJexGkJ9qt3aR8sMAjZ8fhKd7GfQsB", *c4Id)
+	assert.EqualValues(t, "c42FTpMRKrEEL6sgwRVRfxbzYDsYZe4VgsNVC7D6Jkqz8ABjsSAybKLYwPLGSJexGkJ9qt3aR8sMAjZ8fhKd7GfQsB", result.C4Id)
+	assert.EqualValues(t, "", result.DstUrl)
 }
 
 /*
 func TestProcessFileNoErrorRename(t *testing.T) {
-	initConfig()
-	c4Id, dstUrl, err := C4Provider.ProcessFile(testUrlGood, true)
-	assert.NotNil(t, c4Id)
-	assert.NotNil(t, dstUrl)
+	cfg := initConfig()
+	result, err := NewC4Provider(cfg, noopTracer).ProcessFile(context.Background(), domain.Job{SrcUrl: testUrlGood, Type: domain.JobTypeCreateAndRename}, nil)
+	assert.NotNil(t, result)
 	assert.Nil(t, err)
-	assert.EqualValues(t, "c42FTpMRKrEEL6sgwRVRfxbzYDsYZe4VgsNVC7D6Jkqz8ABjsSAybKLYwPLGSJexGkJ9qt3aR8sMAjZ8fhKd7GfQsB", *c4Id)
-	assert.EqualValues(t, "https://mediajku.blob.core.windows.net/media/c42FTpMRKrEEL6sgwRVRfxbzYDsYZe4VgsNVC7D6Jkqz8ABjsSAybKLYwPLGSJexGkJ9qt3aR8sMAjZ8fhKd7GfQsB.tif", *dstUrl)
+	assert.EqualValues(t, "c42FTpMRKrEEL6sgwRVRfxbzYDsYZe4VgsNVC7D6Jkqz8ABjsSAybKLYwPLGSJexGkJ9qt3aR8sMAjZ8fhKd7GfQsB", result.C4Id)
+	assert.EqualValues(t, "https://mediajku.blob.core.windows.net/media/c42FTpMRKrEEL6sgwRVRfxbzYDsYZe4VgsNVC7D6Jkqz8ABjsSAybKLYwPLGSJexGkJ9qt3aR8sMAjZ8fhKd7GfQsB.tif", result.DstUrl)
 }
 */
+
+func TestStorageErrRejectedCredentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("x-ms-error-code", "AuthorizationPermissionMismatch")
+		w.Header().Set("Content-Type", "application/xml")
+		w.WriteHeader(http.StatusForbidden)
+		if r.Method != http.MethodHead {
+			w.Write([]byte(`<?xml version="1.0" encoding="utf-8"?><Error><Code>AuthorizationPermissionMismatch</Code><Message>not authorized</Message></Error>`))
+		}
+	}))
+	defer server.Close()
+	blob, err := azblob.NewBlobClientWithNoCredential(server.URL+"/media/file1.ext", nil)
+	assert.Nil(t, err)
+	fallback := domain.WithCode(domain.CodeStorageFileNotAccessible, api_error.NewBadRequestError("Cannot access file on storage account"))
+
+	_, err = blob.GetProperties(context.Background(), nil)
+	apiErr := storageErr(err, fallback)
+	assert.EqualValues(t, domain.CodeStorageAuthFailed, domain.ErrorCode(apiErr))
+	assert.EqualValues(t, http.StatusBadGateway, apiErr.StatusCode())
+
+	_, err = blob.SetMetadata(context.Background(), map[string]string{"c4id": testC4Id}, nil)
+	apiErr = storageErr(err, fallback)
+	assert.EqualValues(t, domain.CodeStorageAuthFailed, domain.ErrorCode(apiErr))
+	assert.EqualValues(t, "Cannot access file on storage account - storage account rejected the credentials", apiErr.Message())
+}
+
+func TestStorageErrOtherFailures(t *testing.T) {
+	fallback := domain.WithCode(domain.CodeStorageFileNotAccessible, api_error.NewBadRequestError("Cannot access file on storage account"))
+	assert.EqualValues(t, fallback, storageErr(errors.New("connection refused"), fallback))
+	assert.EqualValues(t, fallback, storageErr(nil, fallback))
+}
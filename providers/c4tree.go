@@ -0,0 +1,328 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	c4gen "github.com/Avalanche-io/c4/id"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/date"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var (
+	errOutsideTree = errors.New("symbolic link leads outside of the tree")
+)
+
+type TreeProgress interface {
+	ByteProgress
+	SizeProgress
+	Total(int)
+	FileDone(domain.TreeFile)
+}
+
+type treeSource interface {
+	list() ([]domain.TreeFile, error)
+	open(string) (io.ReadCloser, error)
+}
+
+type blobTreeSource struct {
+	ctx       context.Context
+	tracer    trace.Tracer
+	container *azblob.ContainerClient
+	prefix    string
+}
+
+type localTreeSource struct {
+	root string
+}
+
+func (c4p *c4ProviderService) ProcessTree(ctx context.Context, job domain.Job, progress TreeProgress) (*ProcessResult, api_error.ApiErr) {
+	source, apiErr := newTreeSource(ctx, c4p.cfg, c4p.tracer, job.SrcUrl)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return identifyTree(c4p.cfg, source, job, progress)
+}
+
+// identifyTree identifies the files of the source. Only the bytes actually read count as hashed,
+// so files that could not be identified do not count towards the metrics and the quota.
+func identifyTree(cfg *config.AppConfig, source treeSource, job domain.Job, progress TreeProgress) (*ProcessResult, api_error.ApiErr) {
+	files, err := source.list()
+	if err != nil {
+		logger.Error("Cannot list files", err, job.LogFields()...)
+		return nil, storageErr(err, domain.WithCode(domain.CodeStorageFileNotAccessible, api_error.NewBadRequestError("Cannot list files")))
+	}
+	if len(files) == 0 {
+		logger.Error("No files found", nil, job.LogFields()...)
+		return nil, domain.WithCode(domain.CodeNoFilesFound, api_error.NewBadRequestError("No files found"))
+	}
+	var totalSize int64
+	for _, file := range files {
+		totalSize += file.Size
+	}
+	if apiErr := checkMaxSize(cfg, totalSize); apiErr != nil {
+		return nil, apiErr
+	}
+	if apiErr := progress.Start(totalSize); apiErr != nil {
+		logger.Error("Cannot start reading files", apiErr, job.LogFields()...)
+		return nil, apiErr
+	}
+	progress.Total(len(files))
+	counter := newByteCounter(totalSize, cfg.ProgressInterval, progress)
+	var ids c4gen.Slice
+	failed := 0
+	for _, file := range files {
+		// files that could not be listed are reported with the error of the listing
+		if file.ErrorMsg != "" {
+			failed++
+			progress.FileDone(file)
+			continue
+		}
+		c4Id, digests, err := identifyTreeFile(source, file.Path, job.DigestTypes, counter)
+		if err != nil {
+			logger.Error(fmt.Sprintf("Cannot identify file %v", file.Path), err, job.LogFields()...)
+			file.ErrorMsg = err.Error()
+			failed++
+		} else {
+			file.FileC4Id = c4Id.String()
+			file.Digests = digests
+			ids.Insert(c4Id)
+		}
+		progress.FileDone(file)
+	}
+	counter.report()
+	if failed == len(files) {
+		msg := fmt.Sprintf("%d of %d files could not be identified", failed, len(files))
+		logger.Error(msg, nil, job.LogFields()...)
+		return nil, domain.WithCode(domain.CodeProcessingFailed, api_error.NewInternalServerError(msg, nil))
+	}
+	treeId := ids.ID()
+	if treeId == nil {
+		logger.Error("Cannot compute tree C4 Id", nil, job.LogFields()...)
+		return nil, domain.WithCode(domain.CodeProcessingFailed, api_error.NewInternalServerError("Cannot compute tree C4 Id", nil))
+	}
+	// the tree C4 Id of a partially failed tree covers the files that could be identified
+	if failed > 0 {
+		logger.Warn(fmt.Sprintf("%d of %d files could not be identified", failed, len(files)), job.LogFields()...)
+	}
+	return &ProcessResult{C4Id: treeId.String(), BytesHashed: counter.processed, FilesFailed: failed}, nil
+}
+
+func identifyTreeFile(source treeSource, path string, digestTypes []string, counter *byteCounter) (*c4gen.ID, map[string]string, error) {
+	reader, err := source.open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+	c4Id, digests, err := identify(counter.reader(reader), digestTypes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("file %v: %w", path, err)
+	}
+	return c4Id, digests, nil
+}
+
+func newTreeSource(ctx context.Context, cfg *config.AppConfig, tracer trace.Tracer, srcUrl string) (treeSource, api_error.ApiErr) {
+	url, err := url.Parse(srcUrl)
+	if err != nil || srcUrl == "" {
+		logger.Error("Cannot parse source URL", nil)
+		return nil, domain.WithCode(domain.CodeInvalidSourceUrl, api_error.NewBadRequestError("Cannot parse source URL"))
+	}
+	if url.Scheme == "file" {
+		return newLocalTreeSource(cfg, url.Path)
+	}
+	if strings.TrimSpace(cfg.StorageAccountName) == "" || strings.TrimSpace(cfg.StorageAccountKey) == "" {
+		logger.Error("No storage account access credentials", nil)
+		return nil, domain.WithCode(domain.CodeStorageNotConfigured, api_error.NewInternalServerError("No storage account access credentials", nil))
+	}
+	containerName, prefix := splitContainerPath(url.Path)
+	if url.Scheme == "" || url.Host == "" || containerName == "" {
+		logger.Error("Cannot parse source URL", nil)
+		return nil, domain.WithCode(domain.CodeInvalidSourceUrl, api_error.NewBadRequestError("Cannot parse source URL"))
+	}
+	blobUrl := url.Scheme + "://" + url.Host + "/"
+	container, apiErr := newContainerClient(cfg, blobUrl, containerName)
+	if apiErr != nil {
+		return nil, apiErr
+	}
+	return &blobTreeSource{
+		ctx:       ctx,
+		tracer:    tracer,
+		container: container,
+		prefix:    prefix,
+	}, nil
+}
+
+// newLocalTreeSource resolves symbolic links before checking the directory against the allowed
+// root directory, so that links cannot lead out of it
+func newLocalTreeSource(cfg *config.AppConfig, path string) (treeSource, api_error.ApiErr) {
+	if strings.TrimSpace(cfg.LocalRootDir) == "" {
+		logger.Error("Local directories are not enabled", nil)
+		return nil, domain.WithCode(domain.CodeLocalNotAllowed, api_error.NewBadRequestError("Local directories are not enabled"))
+	}
+	allowedRoot, err := filepath.EvalSymlinks(filepath.Clean(cfg.LocalRootDir))
+	if err != nil {
+		logger.Error("Cannot resolve the allowed root directory", err)
+		return nil, domain.WithCode(domain.CodeLocalNotAllowed, api_error.NewInternalServerError("Cannot resolve the allowed root directory", err))
+	}
+	root, err := filepath.EvalSymlinks(filepath.Clean(path))
+	if err != nil {
+		logger.Error("Cannot list files", err)
+		return nil, domain.WithCode(domain.CodeStorageFileNotAccessible, api_error.NewBadRequestError("Cannot list files"))
+	}
+	if !isWithin(allowedRoot, root) {
+		logger.Error("Local directory is outside of the allowed root directory", nil)
+		return nil, domain.WithCode(domain.CodeLocalNotAllowed, api_error.NewBadRequestError("Local directory is outside of the allowed root directory"))
+	}
+	return &localTreeSource{root: root}, nil
+}
+
+// isWithin reports whether path is root or lies below it
+func isWithin(root string, path string) bool {
+	rel, err := filepath.Rel(root, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(os.PathSeparator))
+}
+
+// splitContainerPath splits a URL path into the container name and an optional blob name prefix
+func splitContainerPath(path string) (string, string) {
+	path = strings.TrimLeft(path, "/")
+	parts := strings.SplitN(path, "/", 2)
+	if len(parts) == 1 {
+		return parts[0], ""
+	}
+	return parts[0], parts[1]
+}
+
+func (bs *blobTreeSource) list() ([]domain.TreeFile, error) {
+	var files []domain.TreeFile
+	options := azblob.ContainerListBlobFlatSegmentOptions{}
+	if bs.prefix != "" {
+		options.Prefix = &bs.prefix
+	}
+	ctx, span := startStorageSpan(bs.ctx, bs.tracer, "azure.list", bs.container.URL())
+	pager := bs.container.ListBlobsFlat(&options)
+	for pager.NextPage(ctx) {
+		segment := pager.PageResponse().Segment
+		if segment == nil {
+			continue
+		}
+		for _, item := range segment.BlobItems {
+			if item.Name == nil {
+				continue
+			}
+			file := domain.TreeFile{
+				Path: *item.Name,
+			}
+			if item.Properties != nil {
+				if item.Properties.ContentLength != nil {
+					file.Size = *item.Properties.ContentLength
+				}
+				if item.Properties.LastModified != nil {
+					file.LastModified = item.Properties.LastModified.UTC().Format(date.ApiDateLayout)
+				}
+			}
+			files = append(files, file)
+		}
+	}
+	err := pager.Err()
+	endStorageSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+func (bs *blobTreeSource) open(path string) (io.ReadCloser, error) {
+	blob := bs.container.NewBlobClient(path)
+	ctx, span := startStorageSpan(bs.ctx, bs.tracer, "azure.download", blob.URL())
+	get, err := blob.Download(ctx, nil)
+	endStorageSpan(span, err)
+	if err != nil {
+		return nil, err
+	}
+	return get.Body(azblob.RetryReaderOptions{}), nil
+}
+
+// list walks the directory. Files, directories and symbolic links that cannot be read are listed with
+// their error. Symbolic links are followed to files within the tree, links leading outside are skipped.
+func (ls *localTreeSource) list() ([]domain.TreeFile, error) {
+	var files []domain.TreeFile
+	err := filepath.WalkDir(ls.root, func(path string, entry fs.DirEntry, err error) error {
+		if path == ls.root && err != nil {
+			return err
+		}
+		rel, relErr := filepath.Rel(ls.root, path)
+		if relErr != nil {
+			return relErr
+		}
+		file := domain.TreeFile{Path: filepath.ToSlash(rel)}
+		if err != nil {
+			file.ErrorMsg = err.Error()
+			files = append(files, file)
+			return nil
+		}
+		if entry.Type()&fs.ModeSymlink != 0 {
+			target, err := ls.resolve(path)
+			if errors.Is(err, errOutsideTree) {
+				logger.Debug(fmt.Sprintf("Skipping symbolic link %v: %v", file.Path, err))
+				return nil
+			}
+			if err != nil {
+				file.ErrorMsg = err.Error()
+				files = append(files, file)
+				return nil
+			}
+			path = target
+		} else if !entry.Type().IsRegular() {
+			return nil
+		}
+		info, err := os.Stat(path)
+		if err != nil {
+			file.ErrorMsg = err.Error()
+			files = append(files, file)
+			return nil
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file.Size = info.Size()
+		file.LastModified = info.ModTime().UTC().Format(date.ApiDateLayout)
+		files = append(files, file)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return files, nil
+}
+
+// resolve follows the symbolic links of path and fails if they lead outside of the tree
+func (ls *localTreeSource) resolve(path string) (string, error) {
+	target, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return "", err
+	}
+	if !isWithin(ls.root, target) {
+		return "", fmt.Errorf("%v: %w", path, errOutsideTree)
+	}
+	return target, nil
+}
+
+func (ls *localTreeSource) open(path string) (io.ReadCloser, error) {
+	target, err := ls.resolve(filepath.Join(ls.root, filepath.FromSlash(path)))
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(target)
+}
@@ -0,0 +1,226 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	c4gen "github.com/Avalanche-io/c4/id"
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/stretchr/testify/assert"
+)
+
+type testTreeProgress struct {
+	total int
+	files []domain.TreeFile
+	bytes domain.JobProgress
+}
+
+func (tp *testTreeProgress) Bytes(progress domain.JobProgress) {
+	tp.bytes = progress
+}
+
+func (tp *testTreeProgress) Start(size int64) api_error.ApiErr {
+	return nil
+}
+
+func (tp *testTreeProgress) Total(total int) {
+	tp.total = total
+}
+
+func (tp *testTreeProgress) FileDone(file domain.TreeFile) {
+	tp.files = append(tp.files, file)
+}
+
+// testTreeSource lists the files given and serves their content, failing to open files without content
+type testTreeSource struct {
+	files    []domain.TreeFile
+	contents map[string]string
+}
+
+func (ts *testTreeSource) list() ([]domain.TreeFile, error) {
+	return ts.files, nil
+}
+
+func (ts *testTreeSource) open(path string) (io.ReadCloser, error) {
+	content, ok := ts.contents[path]
+	if !ok {
+		return nil, errors.New("permission denied")
+	}
+	return io.NopCloser(strings.NewReader(content)), nil
+}
+
+func createTestTree(t *testing.T) string {
+	root := t.TempDir()
+	err := os.MkdirAll(filepath.Join(root, "sub"), 0755)
+	assert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(root, "file1.txt"), []byte("file 1"), 0644)
+	assert.Nil(t, err)
+	err = os.WriteFile(filepath.Join(root, "sub", "file2.txt"), []byte("file 2"), 0644)
+	assert.Nil(t, err)
+	return root
+}
+
+func TestSplitContainerPath(t *testing.T) {
+	container, prefix := splitContainerPath("/media")
+	assert.EqualValues(t, "media", container)
+	assert.EqualValues(t, "", prefix)
+	container, prefix = splitContainerPath("/media/path1/")
+	assert.EqualValues(t, "media", container)
+	assert.EqualValues(t, "path1/", prefix)
+}
+
+func TestProcessTreeLocalNotEnabled(t *testing.T) {
+	result, err := NewC4Provider(config.New(), noopTracer).ProcessTree(context.Background(), domain.Job{SrcUrl: "file:///tmp"}, &testTreeProgress{})
+	assert.Nil(t, result)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, "Local directories are not enabled", err.Message())
+}
+
+func TestProcessTreeLocalOutsideRoot(t *testing.T) {
+	root := createTestTree(t)
+	cfg := config.New()
+	cfg.LocalRootDir = filepath.Join(root, "sub")
+	result, err := NewC4Provider(cfg, noopTracer).ProcessTree(context.Background(), domain.Job{SrcUrl: "file://" + root}, &testTreeProgress{})
+	assert.Nil(t, result)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, "Local directory is outside of the allowed root directory", err.Message())
+}
+
+func TestProcessTreeLocalNoError(t *testing.T) {
+	root := createTestTree(t)
+	cfg := config.New()
+	cfg.LocalRootDir = root
+	progress := testTreeProgress{}
+	result, err := NewC4Provider(cfg, noopTracer).ProcessTree(context.Background(), domain.Job{SrcUrl: "file://" + root, DigestTypes: []string{"md5"}}, &progress)
+	assert.Nil(t, err)
+	assert.NotNil(t, result)
+	assert.EqualValues(t, 2, progress.total)
+	assert.EqualValues(t, 2, len(progress.files))
+	assert.EqualValues(t, 12, progress.bytes.BytesTotal)
+	assert.EqualValues(t, 12, progress.bytes.BytesProcessed)
+	var ids c4gen.Slice
+	for _, content := range []string{"file 1", "file 2"} {
+		ids.Insert(c4gen.Identify(strings.NewReader(content)))
+	}
+	assert.EqualValues(t, ids.ID().String(), result.C4Id)
+	assert.EqualValues(t, "file1.txt", progress.files[0].Path)
+	assert.EqualValues(t, 6, progress.files[0].Size)
+	assert.EqualValues(t, c4gen.Identify(strings.NewReader("file 1")).String(), progress.files[0].FileC4Id)
+	assert.EqualValues(t, "sub/file2.txt", progress.files[1].Path)
+	assert.EqualValues(t, 1, len(progress.files[1].Digests))
+}
+
+func TestProcessTreeLocalTooLarge(t *testing.T) {
+	root := createTestTree(t)
+	cfg := config.New()
+	cfg.LocalRootDir = root
+	cfg.PolicyMaxSize = 10
+	result, err := NewC4Provider(cfg, noopTracer).ProcessTree(context.Background(), domain.Job{SrcUrl: "file://" + root}, &testTreeProgress{})
+	assert.Nil(t, result)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, "Source size of 12 bytes exceeds the maximum of 10 bytes", err.Message())
+}
+
+func TestProcessTreeLocalSymlinks(t *testing.T) {
+	root := createTestTree(t)
+	outside := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644))
+	assert.Nil(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "secret.txt")))
+	assert.Nil(t, os.Symlink(outside, filepath.Join(root, "outside")))
+	assert.Nil(t, os.Symlink(filepath.Join(root, "file1.txt"), filepath.Join(root, "sub", "link.txt")))
+	cfg := config.New()
+	cfg.LocalRootDir = root
+	progress := testTreeProgress{}
+	result, err := NewC4Provider(cfg, noopTracer).ProcessTree(context.Background(), domain.Job{SrcUrl: "file://" + root}, &progress)
+	assert.Nil(t, err)
+	assert.NotNil(t, result)
+	assert.EqualValues(t, 3, len(progress.files))
+	for _, file := range progress.files {
+		assert.NotEqualValues(t, "secret.txt", file.Path)
+	}
+	assert.EqualValues(t, "sub/link.txt", progress.files[2].Path)
+	assert.EqualValues(t, c4gen.Identify(strings.NewReader("file 1")).String(), progress.files[2].FileC4Id)
+}
+
+func TestProcessTreeLocalRootLinkOutside(t *testing.T) {
+	root := createTestTree(t)
+	allowed := t.TempDir()
+	assert.Nil(t, os.Symlink(root, filepath.Join(allowed, "tree")))
+	cfg := config.New()
+	cfg.LocalRootDir = allowed
+	result, err := NewC4Provider(cfg, noopTracer).ProcessTree(context.Background(), domain.Job{SrcUrl: "file://" + filepath.Join(allowed, "tree")}, &testTreeProgress{})
+	assert.Nil(t, result)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, "Local directory is outside of the allowed root directory", err.Message())
+}
+
+func TestProcessTreeLocalPartialFailure(t *testing.T) {
+	root := createTestTree(t)
+	assert.Nil(t, os.Symlink(filepath.Join(root, "noexist.txt"), filepath.Join(root, "sub", "broken.txt")))
+	cfg := config.New()
+	cfg.LocalRootDir = root
+	progress := testTreeProgress{}
+	result, err := NewC4Provider(cfg, noopTracer).ProcessTree(context.Background(), domain.Job{SrcUrl: "file://" + root}, &progress)
+	assert.Nil(t, err)
+	assert.NotNil(t, result)
+	assert.EqualValues(t, 1, result.FilesFailed)
+	var ids c4gen.Slice
+	for _, content := range []string{"file 1", "file 2"} {
+		ids.Insert(c4gen.Identify(strings.NewReader(content)))
+	}
+	assert.EqualValues(t, ids.ID().String(), result.C4Id)
+	assert.EqualValues(t, 3, len(progress.files))
+	assert.EqualValues(t, "sub/broken.txt", progress.files[1].Path)
+	assert.NotEqualValues(t, "", progress.files[1].ErrorMsg)
+}
+
+func TestIdentifyTreeFileOutsideTree(t *testing.T) {
+	root := createTestTree(t)
+	outside := t.TempDir()
+	assert.Nil(t, os.WriteFile(filepath.Join(outside, "secret.txt"), []byte("secret"), 0644))
+	cfg := config.New()
+	cfg.LocalRootDir = root
+	source, apiErr := newTreeSource(context.Background(), cfg, noopTracer, "file://"+root)
+	assert.Nil(t, apiErr)
+	assert.Nil(t, os.Symlink(filepath.Join(outside, "secret.txt"), filepath.Join(root, "file1.txt.link")))
+	_, _, err := identifyTreeFile(source, "file1.txt.link", nil, newByteCounter(0, cfg.ProgressInterval, nil))
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "leads outside of the tree")
+}
+
+func TestIdentifyTreeFileNotFound(t *testing.T) {
+	root := createTestTree(t)
+	cfg := config.New()
+	cfg.LocalRootDir = root
+	source, apiErr := newTreeSource(context.Background(), cfg, noopTracer, "file://"+root)
+	assert.Nil(t, apiErr)
+	_, _, err := identifyTreeFile(source, "noexist.txt", nil, newByteCounter(0, cfg.ProgressInterval, nil))
+	assert.NotNil(t, err)
+}
+
+func TestIdentifyTreeCountsOnlyBytesRead(t *testing.T) {
+	source := testTreeSource{
+		files: []domain.TreeFile{
+			{Path: "file1.txt", Size: 6},
+			{Path: "unreadable.txt", Size: 1000},
+		},
+		contents: map[string]string{"file1.txt": "file 1"},
+	}
+	progress := testTreeProgress{}
+	result, err := identifyTree(config.New(), &source, domain.Job{}, &progress)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 6, result.BytesHashed)
+	assert.EqualValues(t, 1, result.FilesFailed)
+	assert.EqualValues(t, "permission denied", progress.files[1].ErrorMsg)
+}
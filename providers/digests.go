@@ -0,0 +1,42 @@
+package providers
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	c4gen "github.com/Avalanche-io/c4/id"
+	"github.com/cespare/xxhash/v2"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+)
+
+func newDigest(digestType string) hash.Hash {
+	switch digestType {
+	case domain.DigestMd5:
+		return md5.New()
+	case domain.DigestSha1:
+		return sha1.New()
+	case domain.DigestSha256:
+		return sha256.New()
+	case domain.DigestXxHash64:
+		return xxhash.New()
+	case domain.DigestCrc32c:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli))
+	default:
+		return nil
+	}
+}
+
+// identify computes the C4 Id and any requested digests in a single pass over the reader
+func identify(reader io.Reader, digestTypes []string) (*c4gen.ID, map[string]string, error) {
+	state := newHashState(digestTypes)
+	if _, err := io.Copy(state, reader); err != nil {
+		return nil, nil, fmt.Errorf("could not read: %w", err)
+	}
+	c4Id, digests := state.result()
+	return c4Id, digests, nil
+}
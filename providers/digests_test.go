@@ -0,0 +1,43 @@
+package providers
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	c4gen "github.com/Avalanche-io/c4/id"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIdentifyNoDigests(t *testing.T) {
+	c4Id, digests, err := identify(strings.NewReader("hello world"), nil)
+	assert.Nil(t, err)
+	assert.NotNil(t, c4Id)
+	assert.Nil(t, digests)
+	assert.EqualValues(t, c4gen.Identify(strings.NewReader("hello world")).String(), c4Id.String())
+}
+
+func TestIdentifyAllDigests(t *testing.T) {
+	c4Id, digests, err := identify(strings.NewReader("hello world"), domain.DigestAlgorithms)
+	assert.Nil(t, err)
+	assert.NotNil(t, c4Id)
+	assert.EqualValues(t, c4gen.Identify(strings.NewReader("hello world")).String(), c4Id.String())
+	assert.EqualValues(t, 5, len(digests))
+	assert.EqualValues(t, "5eb63bbbe01eeed093cb22bb8f5acdc3", digests[domain.DigestMd5])
+	assert.EqualValues(t, "2aae6c35c94fcfb415dbe95f408b9ce91ee846ed", digests[domain.DigestSha1])
+	assert.EqualValues(t, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", digests[domain.DigestSha256])
+	assert.EqualValues(t, "45ab6734b21e6968", digests[domain.DigestXxHash64])
+	assert.EqualValues(t, "c99465aa", digests[domain.DigestCrc32c])
+}
+
+func TestIdentifyReadError(t *testing.T) {
+	readErr := errors.New("connection reset")
+	c4Id, digests, err := identify(io.MultiReader(strings.NewReader("hello"), iotest.ErrReader(readErr)), nil)
+	assert.Nil(t, c4Id)
+	assert.Nil(t, digests)
+	assert.ErrorIs(t, err, readErr)
+	assert.EqualValues(t, "could not read: connection reset", err.Error())
+}
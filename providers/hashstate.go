@@ -0,0 +1,98 @@
+package providers
+
+import (
+	"crypto/sha512"
+	"encoding"
+	"encoding/hex"
+	"fmt"
+	"hash"
+
+	c4gen "github.com/Avalanche-io/c4/id"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+)
+
+const (
+	c4StateKey = "c4"
+)
+
+// hashState feeds the data to the SHA-512 hash the C4 Id is derived from and to any
+// requested digests. All hashes implement encoding.BinaryMarshaler, so the state can be
+// checkpointed and restored to resume hashing at a later offset.
+type hashState struct {
+	c4      hash.Hash
+	digests map[string]hash.Hash
+}
+
+func newHashState(digestTypes []string) *hashState {
+	state := hashState{
+		c4:      sha512.New(),
+		digests: make(map[string]hash.Hash),
+	}
+	for _, digestType := range digestTypes {
+		if _, ok := state.digests[digestType]; ok {
+			continue
+		}
+		if digest := newDigest(digestType); digest != nil {
+			state.digests[digestType] = digest
+		}
+	}
+	return &state
+}
+
+func restoreHashState(digestTypes []string, checkpoint *domain.Checkpoint) (*hashState, error) {
+	state := newHashState(digestTypes)
+	for key, hash := range state.hashes() {
+		saved, ok := checkpoint.States[key]
+		if !ok {
+			return nil, fmt.Errorf("no saved state for %v", key)
+		}
+		if err := hash.(encoding.BinaryUnmarshaler).UnmarshalBinary(saved); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+func (hs *hashState) hashes() map[string]hash.Hash {
+	hashes := map[string]hash.Hash{c4StateKey: hs.c4}
+	for digestType, digest := range hs.digests {
+		hashes[digestType] = digest
+	}
+	return hashes
+}
+
+func (hs *hashState) Write(p []byte) (int, error) {
+	hs.c4.Write(p)
+	for _, digest := range hs.digests {
+		digest.Write(p)
+	}
+	return len(p), nil
+}
+
+func (hs *hashState) checkpoint(eTag string, offset int64) (*domain.Checkpoint, error) {
+	checkpoint := domain.Checkpoint{
+		ETag:   eTag,
+		Offset: offset,
+		States: make(map[string][]byte),
+	}
+	for key, hash := range hs.hashes() {
+		saved, err := hash.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		checkpoint.States[key] = saved
+	}
+	return &checkpoint, nil
+}
+
+func (hs *hashState) result() (*c4gen.ID, map[string]string) {
+	c4Id := c4gen.NewDigest(hs.c4.Sum(nil)).ID()
+	if len(hs.digests) == 0 {
+		return c4Id, nil
+	}
+	sums := make(map[string]string)
+	for digestType, digest := range hs.digests {
+		sums[digestType] = hex.EncodeToString(digest.Sum(nil))
+	}
+	return c4Id, sums
+}
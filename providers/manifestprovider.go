@@ -0,0 +1,72 @@
+package providers
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+type manifestProviderService struct {
+	cfg *config.AppConfig
+}
+
+type ManifestProvider interface {
+	Write(string, string, []byte) (string, api_error.ApiErr)
+}
+
+func NewManifestProvider(cfg *config.AppConfig) ManifestProvider {
+	return &manifestProviderService{
+		cfg: cfg,
+	}
+}
+
+// Write stores the content under the given name below the location, which is either a
+// file:// directory or a blob container URL with an optional prefix, and returns its URL
+func (mp *manifestProviderService) Write(location string, name string, content []byte) (string, api_error.ApiErr) {
+	url, err := url.Parse(location)
+	if err != nil || strings.TrimSpace(location) == "" {
+		logger.Error("Cannot parse manifest location", nil)
+		return "", domain.WithCode(domain.CodeManifestNotConfigured, api_error.NewInternalServerError("Cannot parse manifest location", nil))
+	}
+	if url.Scheme == "file" {
+		fileName := filepath.Join(filepath.Clean(url.Path), name)
+		err := os.WriteFile(fileName, content, 0644)
+		if err != nil {
+			logger.Error("Cannot write manifest file", err)
+			return "", domain.WithCode(domain.CodeManifestWriteFailed, api_error.NewInternalServerError("Cannot write manifest file", err))
+		}
+		return "file://" + filepath.ToSlash(fileName), nil
+	}
+	if strings.TrimSpace(mp.cfg.StorageAccountName) == "" || strings.TrimSpace(mp.cfg.StorageAccountKey) == "" {
+		logger.Error("No storage account access credentials", nil)
+		return "", domain.WithCode(domain.CodeStorageNotConfigured, api_error.NewInternalServerError("No storage account access credentials", nil))
+	}
+	containerName, prefix := splitContainerPath(url.Path)
+	if url.Scheme == "" || url.Host == "" || containerName == "" {
+		logger.Error("Cannot parse manifest location", nil)
+		return "", domain.WithCode(domain.CodeManifestNotConfigured, api_error.NewInternalServerError("Cannot parse manifest location", nil))
+	}
+	blobUrl := url.Scheme + "://" + url.Host + "/"
+	container, apiErr := newContainerClient(mp.cfg, blobUrl, containerName)
+	if apiErr != nil {
+		return "", apiErr
+	}
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix = prefix + "/"
+	}
+	blockBlob := container.NewBlockBlobClient(prefix + name)
+	_, err = blockBlob.UploadBufferToBlockBlob(context.Background(), content, azblob.HighLevelUploadToBlockBlobOption{})
+	if err != nil {
+		logger.Error("Cannot write manifest to storage account", err)
+		return "", storageErr(err, domain.WithCode(domain.CodeManifestWriteFailed, api_error.NewInternalServerError("Cannot write manifest to storage account", err)))
+	}
+	return blockBlob.URL(), nil
+}
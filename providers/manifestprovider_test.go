@@ -0,0 +1,29 @@
+package providers
+
+import (
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteManifestNoLocation(t *testing.T) {
+	location, err := NewManifestProvider(config.New()).Write("", "manifest.c4m", []byte("@c4m 1.0\n"))
+	assert.NotNil(t, err)
+	assert.EqualValues(t, "", location)
+	assert.EqualValues(t, http.StatusInternalServerError, err.StatusCode())
+	assert.EqualValues(t, "Cannot parse manifest location", err.Message())
+}
+
+func TestWriteManifestLocalNoError(t *testing.T) {
+	dir := t.TempDir()
+	location, err := NewManifestProvider(config.New()).Write("file://"+dir, "manifest.c4m", []byte("@c4m 1.0\n"))
+	assert.Nil(t, err)
+	assert.EqualValues(t, "file://"+filepath.ToSlash(filepath.Join(dir, "manifest.c4m")), location)
+	content, readErr := os.ReadFile(filepath.Join(dir, "manifest.c4m"))
+	assert.Nil(t, readErr)
+	assert.EqualValues(t, "@c4m 1.0\n", string(content))
+}
@@ -0,0 +1,77 @@
+package providers
+
+import (
+	"io"
+	"time"
+
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+)
+
+type ByteProgress interface {
+	Bytes(domain.JobProgress)
+}
+
+// SizeProgress is told the number of bytes a job is going to read before reading starts.
+// An error stops the job before anything is read.
+type SizeProgress interface {
+	Start(int64) api_error.ApiErr
+}
+
+// byteCounter accumulates the bytes read by one or more countingReaders and reports
+// progress at most once per interval. Bytes resumed from a checkpoint are counted as processed
+// when the counter starts.
+type byteCounter struct {
+	interval   time.Duration
+	total      int64
+	processed  int64
+	resumed    int64
+	start      time.Time
+	lastReport time.Time
+	progress   ByteProgress
+}
+
+type countingReader struct {
+	reader  io.Reader
+	counter *byteCounter
+}
+
+func newByteCounter(total int64, interval time.Duration, progress ByteProgress) *byteCounter {
+	now := time.Now()
+	return &byteCounter{
+		interval:   interval,
+		total:      total,
+		start:      now,
+		lastReport: now,
+		progress:   progress,
+	}
+}
+
+func (bc *byteCounter) add(n int64) {
+	bc.processed += n
+	if time.Since(bc.lastReport) >= bc.interval {
+		bc.report()
+	}
+}
+
+func (bc *byteCounter) report() {
+	bc.lastReport = time.Now()
+	if bc.progress != nil {
+		bc.progress.Bytes(domain.NewJobProgress(bc.total, bc.processed, bc.resumed, bc.lastReport.Sub(bc.start)))
+	}
+}
+
+func (bc *byteCounter) reader(reader io.Reader) io.Reader {
+	return &countingReader{
+		reader:  reader,
+		counter: bc,
+	}
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.reader.Read(p)
+	if n > 0 {
+		cr.counter.add(int64(n))
+	}
+	return n, err
+}
@@ -0,0 +1,56 @@
+package providers
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+type testByteProgress struct {
+	reports []domain.JobProgress
+}
+
+func (bp *testByteProgress) Bytes(progress domain.JobProgress) {
+	bp.reports = append(bp.reports, progress)
+}
+
+func TestCountingReaderCountsBytes(t *testing.T) {
+	progress := testByteProgress{}
+	counter := newByteCounter(10, time.Minute, &progress)
+	n, err := io.Copy(io.Discard, counter.reader(strings.NewReader("0123456789")))
+	assert.Nil(t, err)
+	assert.EqualValues(t, 10, n)
+	assert.EqualValues(t, 10, counter.processed)
+	assert.EqualValues(t, 0, len(progress.reports))
+	counter.report()
+	assert.EqualValues(t, 1, len(progress.reports))
+	assert.EqualValues(t, 10, progress.reports[0].BytesTotal)
+	assert.EqualValues(t, 10, progress.reports[0].BytesProcessed)
+}
+
+func TestCountingReaderReportsPeriodically(t *testing.T) {
+	progress := testByteProgress{}
+	counter := newByteCounter(10, 0, &progress)
+	buf := make([]byte, 4)
+	reader := counter.reader(strings.NewReader("0123456789"))
+	for {
+		if _, err := reader.Read(buf); err != nil {
+			break
+		}
+	}
+	assert.EqualValues(t, 3, len(progress.reports))
+	assert.EqualValues(t, 4, progress.reports[0].BytesProcessed)
+	assert.EqualValues(t, 10, progress.reports[2].BytesProcessed)
+}
+
+func TestByteCounterNoProgress(t *testing.T) {
+	counter := newByteCounter(10, time.Minute, nil)
+	counter.start = time.Now().Add(-time.Second)
+	counter.add(5)
+	counter.report()
+	assert.EqualValues(t, 5, counter.processed)
+}
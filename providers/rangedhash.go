@@ -0,0 +1,220 @@
+package providers
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	c4gen "github.com/Avalanche-io/c4/id"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+type FileProgress interface {
+	ByteProgress
+	SizeProgress
+	Checkpoint(*domain.Checkpoint)
+}
+
+type rangeSource interface {
+	readRange(ctx context.Context, offset int64, count int64) (io.ReadCloser, error)
+}
+
+type blobRangeSource struct {
+	blob azblob.BlobClient
+	eTag *string
+}
+
+func (bs *blobRangeSource) readRange(ctx context.Context, offset int64, count int64) (io.ReadCloser, error) {
+	options := azblob.DownloadBlobOptions{
+		Offset: &offset,
+		Count:  &count,
+		BlobAccessConditions: &azblob.BlobAccessConditions{
+			ModifiedAccessConditions: &azblob.ModifiedAccessConditions{
+				IfMatch: bs.eTag,
+			},
+		},
+	}
+	get, err := bs.blob.Download(ctx, &options)
+	if err != nil {
+		return nil, err
+	}
+	return get.Body(azblob.RetryReaderOptions{}), nil
+}
+
+// rangeChunk is one range of the blob, fetched in the background. done is closed once
+// data has been filled or err has been set.
+type rangeChunk struct {
+	offset int64
+	data   []byte
+	err    error
+	done   chan struct{}
+}
+
+// rangeFetcher downloads up to cfg.ChunkParallelism ranges concurrently and hands them out
+// in offset order. One extra buffer lets the next range download while the consumer hashes;
+// a buffer is only reused after the consumer has released its chunk.
+type rangeFetcher struct {
+	buffers chan []byte
+	slots   chan struct{}
+	chunks  chan *rangeChunk
+	wg      sync.WaitGroup
+}
+
+func startRangeFetcher(ctx context.Context, cfg *config.AppConfig, source rangeSource, offset int64, size int64) *rangeFetcher {
+	parallelism := cfg.ChunkParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	chunkSize := cfg.ChunkSize
+	if chunkSize < 1 {
+		chunkSize = 1
+	}
+	if chunkSize > size-offset {
+		chunkSize = size - offset
+	}
+	rf := rangeFetcher{
+		buffers: make(chan []byte, parallelism+1),
+		slots:   make(chan struct{}, parallelism),
+		chunks:  make(chan *rangeChunk, parallelism),
+	}
+	for i := 0; i < parallelism+1; i++ {
+		rf.buffers <- make([]byte, chunkSize)
+	}
+	rf.wg.Add(1)
+	go func() {
+		defer rf.wg.Done()
+		defer close(rf.chunks)
+		for ; offset < size; offset += chunkSize {
+			var buf []byte
+			select {
+			case buf = <-rf.buffers:
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case rf.slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+			if remaining := size - offset; remaining < chunkSize {
+				buf = buf[:remaining]
+			}
+			chunk := rangeChunk{
+				offset: offset,
+				data:   buf,
+				done:   make(chan struct{}),
+			}
+			rf.wg.Add(1)
+			go func() {
+				defer rf.wg.Done()
+				chunk.err = readRangeWithRetries(ctx, cfg, source, chunk.offset, chunk.data)
+				<-rf.slots
+				close(chunk.done)
+			}()
+			select {
+			case rf.chunks <- &chunk:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return &rf
+}
+
+// stop cancels outstanding downloads and waits for them to finish
+func (rf *rangeFetcher) stop(cancel context.CancelFunc) {
+	cancel()
+	rf.wg.Wait()
+}
+
+func (rf *rangeFetcher) release(chunk *rangeChunk) {
+	rf.buffers <- chunk.data[:cap(chunk.data)]
+}
+
+// hashRanges hashes size bytes from the source in chunks of cfg.ChunkSize, fetching up to
+// cfg.ChunkParallelism chunks concurrently and retrying each up to cfg.ChunkRetries times.
+// Chunks are hashed in order; after each chunk the hasher state is handed to progress as a
+// checkpoint. A checkpoint for the same ETag resumes hashing at its offset. Besides the C4 Id and
+// digests, the number of bytes hashed by this call is returned, which excludes resumed bytes.
+func hashRanges(ctx context.Context, cfg *config.AppConfig, source rangeSource, size int64, eTag string, digestTypes []string, checkpoint *domain.Checkpoint, progress FileProgress) (*c4gen.ID, map[string]string, int64, error) {
+	state, offset := resumeHashState(size, eTag, digestTypes, checkpoint)
+	resumed := offset
+	counter := newByteCounter(size, cfg.ProgressInterval, progress)
+	counter.processed = offset
+	counter.resumed = offset
+	ctx, cancel := context.WithCancel(ctx)
+	fetcher := startRangeFetcher(ctx, cfg, source, offset, size)
+	defer fetcher.stop(cancel)
+	for chunk := range fetcher.chunks {
+		<-chunk.done
+		if chunk.err != nil {
+			return nil, nil, 0, chunk.err
+		}
+		state.Write(chunk.data)
+		offset += int64(len(chunk.data))
+		counter.add(int64(len(chunk.data)))
+		fetcher.release(chunk)
+		if progress != nil {
+			saved, err := state.checkpoint(eTag, offset)
+			if err != nil {
+				return nil, nil, 0, err
+			}
+			progress.Checkpoint(saved)
+		}
+	}
+	if offset < size {
+		return nil, nil, 0, fmt.Errorf("download stopped at offset %d of %d", offset, size)
+	}
+	counter.report()
+	c4Id, digests := state.result()
+	return c4Id, digests, size - resumed, nil
+}
+
+func resumeHashState(size int64, eTag string, digestTypes []string, checkpoint *domain.Checkpoint) (*hashState, int64) {
+	if checkpoint == nil || checkpoint.ETag != eTag || checkpoint.Offset > size {
+		return newHashState(digestTypes), 0
+	}
+	state, err := restoreHashState(digestTypes, checkpoint)
+	if err != nil {
+		logger.Error("Cannot restore checkpoint, starting from the beginning", err)
+		return newHashState(digestTypes), 0
+	}
+	logger.Info(fmt.Sprintf("Resuming from checkpoint at offset %d", checkpoint.Offset))
+	return state, checkpoint.Offset
+}
+
+func readRangeWithRetries(ctx context.Context, cfg *config.AppConfig, source rangeSource, offset int64, chunk []byte) error {
+	var err error
+	for attempt := 0; attempt <= cfg.ChunkRetries; attempt++ {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if attempt > 0 {
+			logger.Warn(fmt.Sprintf("Retrying range at offset %d (attempt %d of %d)", offset, attempt, cfg.ChunkRetries))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(cfg.ChunkRetryWait * time.Duration(attempt)):
+			}
+		}
+		if err = readRange(ctx, source, offset, chunk); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func readRange(ctx context.Context, source rangeSource, offset int64, chunk []byte) error {
+	body, err := source.readRange(ctx, offset, int64(len(chunk)))
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	_, err = io.ReadFull(body, chunk)
+	return err
+}
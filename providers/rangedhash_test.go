@@ -0,0 +1,211 @@
+package providers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	c4gen "github.com/Avalanche-io/c4/id"
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/stretchr/testify/assert"
+)
+
+type testRangeSource struct {
+	mu       sync.Mutex
+	data     []byte
+	failAt   map[int64]int
+	requests []int64
+}
+
+func (rs *testRangeSource) readRange(ctx context.Context, offset int64, count int64) (io.ReadCloser, error) {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	rs.requests = append(rs.requests, offset)
+	if rs.failAt[offset] > 0 {
+		rs.failAt[offset]--
+		return nil, errors.New("connection reset")
+	}
+	return io.NopCloser(bytes.NewReader(rs.data[offset : offset+count])), nil
+}
+
+type testFileProgress struct {
+	testByteProgress
+	checkpoints []*domain.Checkpoint
+}
+
+func (fp *testFileProgress) Start(size int64) api_error.ApiErr {
+	return nil
+}
+
+func (fp *testFileProgress) Checkpoint(checkpoint *domain.Checkpoint) {
+	fp.checkpoints = append(fp.checkpoints, checkpoint)
+}
+
+// latencyRangeSource stands in for a blob, adding a fixed latency to every range request
+type latencyRangeSource struct {
+	data    []byte
+	latency time.Duration
+}
+
+func (rs *latencyRangeSource) readRange(ctx context.Context, offset int64, count int64) (io.ReadCloser, error) {
+	time.Sleep(rs.latency)
+	return io.NopCloser(bytes.NewReader(rs.data[offset : offset+count])), nil
+}
+
+func chunkConfig(chunkSize int64, parallelism int, retries int) *config.AppConfig {
+	cfg := config.New()
+	cfg.ChunkSize, cfg.ChunkParallelism, cfg.ChunkRetries, cfg.ChunkRetryWait = chunkSize, parallelism, retries, 0
+	return cfg
+}
+
+func TestHashRangesMatchesIdentify(t *testing.T) {
+	cfg := chunkConfig(4, 1, 0)
+	data := []byte("hello world, hashed in ranges")
+	source := testRangeSource{data: data}
+	progress := testFileProgress{}
+	c4Id, digests, _, err := hashRanges(context.Background(), cfg, &source, int64(len(data)), "etag", []string{domain.DigestMd5}, nil, &progress)
+	assert.Nil(t, err)
+	expectedId, expectedDigests, _ := identify(bytes.NewReader(data), []string{domain.DigestMd5})
+	assert.EqualValues(t, expectedId.String(), c4Id.String())
+	assert.EqualValues(t, expectedDigests, digests)
+	assert.EqualValues(t, 8, len(source.requests))
+	assert.EqualValues(t, 8, len(progress.checkpoints))
+	assert.EqualValues(t, len(data), progress.checkpoints[7].Offset)
+	assert.EqualValues(t, len(data), progress.reports[len(progress.reports)-1].BytesProcessed)
+}
+
+func TestHashRangesEmptyFile(t *testing.T) {
+	cfg := config.New()
+	c4Id, _, _, err := hashRanges(context.Background(), cfg, &testRangeSource{}, 0, "etag", nil, nil, nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, c4gen.Identify(bytes.NewReader(nil)).String(), c4Id.String())
+}
+
+func TestHashRangesRetriesFailedRange(t *testing.T) {
+	cfg := chunkConfig(4, 1, 2)
+	data := []byte("0123456789")
+	source := testRangeSource{data: data, failAt: map[int64]int{4: 2}}
+	c4Id, _, _, err := hashRanges(context.Background(), cfg, &source, int64(len(data)), "etag", nil, nil, nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, c4gen.Identify(bytes.NewReader(data)).String(), c4Id.String())
+	assert.EqualValues(t, []int64{0, 4, 4, 4, 8}, source.requests)
+}
+
+func TestHashRangesResumesFromCheckpoint(t *testing.T) {
+	cfg := chunkConfig(4, 1, 0)
+	data := []byte("0123456789abcdef")
+	source := testRangeSource{data: data, failAt: map[int64]int{12: 1}}
+	progress := testFileProgress{}
+	_, _, _, err := hashRanges(context.Background(), cfg, &source, int64(len(data)), "etag", []string{domain.DigestSha256}, nil, &progress)
+	assert.NotNil(t, err)
+	checkpoint := progress.checkpoints[len(progress.checkpoints)-1]
+	assert.EqualValues(t, 12, checkpoint.Offset)
+
+	source.requests = nil
+	resumed := testFileProgress{}
+	c4Id, digests, hashed, err := hashRanges(context.Background(), cfg, &source, int64(len(data)), "etag", []string{domain.DigestSha256}, checkpoint, &resumed)
+	assert.Nil(t, err)
+	assert.EqualValues(t, []int64{12}, source.requests)
+	assert.EqualValues(t, 4, hashed)
+	last := resumed.reports[len(resumed.reports)-1]
+	assert.EqualValues(t, 16, last.BytesProcessed)
+	assert.EqualValues(t, 12, last.BytesResumed)
+	expectedId, expectedDigests, _ := identify(bytes.NewReader(data), []string{domain.DigestSha256})
+	assert.EqualValues(t, expectedId.String(), c4Id.String())
+	assert.EqualValues(t, expectedDigests, digests)
+}
+
+func TestHashRangesIgnoresCheckpointOfChangedBlob(t *testing.T) {
+	cfg := chunkConfig(4, 1, 0)
+	data := []byte("0123456789")
+	source := testRangeSource{data: data}
+	checkpoint, err := newHashState(nil).checkpoint("old-etag", 8)
+	assert.Nil(t, err)
+	c4Id, _, _, err := hashRanges(context.Background(), cfg, &source, int64(len(data)), "etag", nil, checkpoint, nil)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, source.requests[0])
+	assert.EqualValues(t, c4gen.Identify(bytes.NewReader(data)).String(), c4Id.String())
+}
+
+func TestRestoreHashStateMissingDigest(t *testing.T) {
+	checkpoint, err := newHashState(nil).checkpoint("etag", 0)
+	assert.Nil(t, err)
+	state, err := restoreHashState([]string{domain.DigestMd5}, checkpoint)
+	assert.Nil(t, state)
+	assert.NotNil(t, err)
+}
+
+func TestHashRangesParallelKeepsOrder(t *testing.T) {
+	cfg := chunkConfig(3, 4, 1)
+	data := []byte("the quick brown fox jumps over the lazy dog")
+	source := testRangeSource{data: data, failAt: map[int64]int{3: 1, 9: 1}}
+	progress := testFileProgress{}
+	c4Id, digests, _, err := hashRanges(context.Background(), cfg, &source, int64(len(data)), "etag", domain.DigestAlgorithms, nil, &progress)
+	assert.Nil(t, err)
+	expectedId, expectedDigests, _ := identify(bytes.NewReader(data), domain.DigestAlgorithms)
+	assert.EqualValues(t, expectedId.String(), c4Id.String())
+	assert.EqualValues(t, expectedDigests, digests)
+	assert.EqualValues(t, 17, len(source.requests))
+	for i, checkpoint := range progress.checkpoints {
+		expected := int64(i+1) * 3
+		if expected > int64(len(data)) {
+			expected = int64(len(data))
+		}
+		assert.EqualValues(t, expected, checkpoint.Offset)
+	}
+}
+
+func TestHashRangesParallelFailure(t *testing.T) {
+	cfg := chunkConfig(4, 4, 0)
+	data := []byte("0123456789abcdef")
+	source := testRangeSource{data: data, failAt: map[int64]int{8: 1}}
+	progress := testFileProgress{}
+	c4Id, _, _, err := hashRanges(context.Background(), cfg, &source, int64(len(data)), "etag", nil, nil, &progress)
+	assert.Nil(t, c4Id)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, 8, progress.checkpoints[len(progress.checkpoints)-1].Offset)
+}
+
+func benchmarkHashRanges(b *testing.B, parallelism int) {
+	cfg := chunkConfig(1024*1024, parallelism, 0)
+	data := bytes.Repeat([]byte("c4svc"), 16*1024*1024/5)
+	source := latencyRangeSource{data: data, latency: 5 * time.Millisecond}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, _, _, err := hashRanges(context.Background(), cfg, &source, int64(len(data)), "etag", nil, nil, nil); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkHashRangesSequential(b *testing.B) {
+	benchmarkHashRanges(b, 1)
+}
+
+func BenchmarkHashRangesParallel4(b *testing.B) {
+	benchmarkHashRanges(b, 4)
+}
+
+func BenchmarkHashRangesParallel8(b *testing.B) {
+	benchmarkHashRanges(b, 8)
+}
+
+func TestReadRangeWithRetriesStopsWaitingOnCancel(t *testing.T) {
+	cfg := chunkConfig(4, 1, 3)
+	cfg.ChunkRetryWait = time.Minute
+	data := []byte("0123")
+	source := testRangeSource{data: data, failAt: map[int64]int{0: 1}}
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(10*time.Millisecond, cancel)
+	start := time.Now()
+	err := readRangeWithRetries(ctx, cfg, &source, 0, make([]byte, len(data)))
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Less(t, time.Since(start), time.Second)
+}
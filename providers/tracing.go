@@ -0,0 +1,23 @@
+package providers
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// startStorageSpan starts a span for one call to the storage account
+func startStorageSpan(ctx context.Context, tracer trace.Tracer, name string, url string) (context.Context, trace.Span) {
+	return tracer.Start(ctx, name, trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(attribute.String("blob.url", url)))
+}
+
+// endStorageSpan records err on the span, if any, and ends it
+func endStorageSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
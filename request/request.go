@@ -0,0 +1,73 @@
+package request
+
+import (
+	"fmt"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+	"github.com/segmentio/ksuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	IdHeader           = "X-Request-ID"
+	ProblemContentType = "application/problem+json"
+	idKey              = "request_id"
+)
+
+var (
+	validId = regexp.MustCompile(`^[A-Za-z0-9._:-]{1,128}$`)
+)
+
+// AssignId identifies each request by the Id sent by the client, or a new one if the client did
+// not send a valid Id, and returns it in the response and on the span of the request
+func AssignId(c *gin.Context) {
+	id := c.GetHeader(IdHeader)
+	if !validId.MatchString(id) {
+		id = ksuid.New().String()
+	}
+	c.Set(idKey, id)
+	c.Header(IdHeader, id)
+	trace.SpanFromContext(c.Request.Context()).SetAttributes(attribute.String("request.id", id))
+	c.Next()
+}
+
+// GetId returns the Id of the request, or "" if none was assigned
+func GetId(c *gin.Context) string {
+	return c.GetString(idKey)
+}
+
+// LogField identifies the request in log lines
+func LogField(c *gin.Context) logger.Field {
+	return logger.Field{Key: idKey, Value: GetId(c)}
+}
+
+// LogFormatter formats access log lines like gin's default formatter, followed by the request Id
+func LogFormatter(param gin.LogFormatterParams) string {
+	id, _ := param.Keys[idKey].(string)
+	return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v | %v\n%s",
+		param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+		param.StatusCode,
+		param.Latency,
+		param.ClientIP,
+		param.Method,
+		param.Path,
+		id,
+		param.ErrorMessage,
+	)
+}
+
+// Fail aborts the request and responds with err as problem details
+func Fail(c *gin.Context, err api_error.ApiErr) {
+	FailProblem(c, domain.NewProblem(err, c.Request.URL.Path, GetId(c)))
+}
+
+// FailProblem aborts the request and responds with the problem details, which may carry extension members
+func FailProblem(c *gin.Context, problem *domain.Problem) {
+	c.Header("Content-Type", ProblemContentType)
+	c.AbortWithStatusJSON(problem.Status, problem)
+}
@@ -0,0 +1,52 @@
+package request
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(AssignId)
+	router.GET("/", func(c *gin.Context) {
+		c.String(http.StatusOK, GetId(c))
+	})
+	return router
+}
+
+func TestAssignIdKeepsClientId(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set(IdHeader, "abc-123")
+	w := httptest.NewRecorder()
+	newTestRouter().ServeHTTP(w, req)
+	assert.EqualValues(t, "abc-123", w.Body.String())
+	assert.EqualValues(t, "abc-123", w.Header().Get(IdHeader))
+}
+
+func TestAssignIdReplacesInvalidId(t *testing.T) {
+	for _, id := range []string{"", "has space", strings.Repeat("a", 129)} {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(IdHeader, id)
+		w := httptest.NewRecorder()
+		newTestRouter().ServeHTTP(w, req)
+		assert.NotEqualValues(t, id, w.Body.String())
+		assert.EqualValues(t, 27, len(w.Body.String()))
+		assert.EqualValues(t, w.Body.String(), w.Header().Get(IdHeader))
+	}
+}
+
+func TestLogFormatterIncludesId(t *testing.T) {
+	line := LogFormatter(gin.LogFormatterParams{
+		StatusCode: http.StatusOK,
+		Method:     http.MethodGet,
+		Path:       "/api/v1/jobs/",
+		Keys:       map[string]interface{}{idKey: "abc-123"},
+	})
+	assert.Contains(t, line, `"/api/v1/jobs/" | abc-123`)
+}
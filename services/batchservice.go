@@ -0,0 +1,103 @@
+package services
+
+import (
+	"fmt"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/date"
+	"github.com/segmentio/ksuid"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type batchService struct {
+	cfg          *config.AppConfig
+	jobDao       domain.JobDao
+	batchDao     domain.BatchDao
+	quotaService QuotaService
+	policy       domain.SourcePolicy
+	tracer       trace.Tracer
+}
+
+type BatchService interface {
+	Create([]domain.Job, domain.Caller) (*domain.BatchResult, api_error.ApiErr)
+	Get(string, domain.Caller) (*domain.BatchSummary, api_error.ApiErr)
+}
+
+func NewBatchService(cfg *config.AppConfig, jobDao domain.JobDao, batchDao domain.BatchDao, quotaService QuotaService, tracer trace.Tracer) BatchService {
+	return &batchService{
+		cfg:          cfg,
+		jobDao:       jobDao,
+		batchDao:     batchDao,
+		quotaService: quotaService,
+		policy:       newSourcePolicy(cfg),
+		tracer:       tracer,
+	}
+}
+
+func (bs *batchService) Create(inputJobs []domain.Job, caller domain.Caller) (*domain.BatchResult, api_error.ApiErr) {
+	if len(inputJobs) == 0 {
+		return nil, domain.WithCode(domain.CodeBatchEmpty, api_error.NewBadRequestError("batch contains no jobs"))
+	}
+	if len(inputJobs) > bs.cfg.MaxBatchSize {
+		return nil, domain.WithCode(domain.CodeBatchTooLarge, api_error.NewBadRequestError(fmt.Sprintf("batch contains more than %d jobs", bs.cfg.MaxBatchSize)))
+	}
+	if err := checkQueue(bs.cfg, bs.jobDao, len(inputJobs)); err != nil {
+		return nil, err
+	}
+	release, err := bs.quotaService.Reserve(caller, len(inputJobs))
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	batch := domain.Batch{
+		Id:        ksuid.New().String(),
+		CreatedAt: date.GetNowUtcString(),
+		CreatedBy: caller.Name,
+	}
+	result := domain.BatchResult{
+		Id:    batch.Id,
+		Items: make([]domain.BatchItem, 0, len(inputJobs)),
+	}
+	for i, inputJob := range inputJobs {
+		item := domain.BatchItem{Index: i}
+		newJob, err := createJob(bs.jobDao, bs.policy, bs.tracer, inputJob, batch.Id, caller)
+		if err != nil {
+			item.Error = domain.NewProblem(err, "", "")
+			result.Failed++
+		} else {
+			item.Job = newJob
+			batch.JobIds = append(batch.JobIds, newJob.Id)
+			result.Created++
+		}
+		result.Items = append(result.Items, item)
+	}
+	// a batch without jobs is not kept, the caller only gets the errors of its items
+	if result.Created == 0 {
+		return &result, nil
+	}
+	if err := bs.batchDao.Save(batch); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Get summarizes a batch. Batches of other users are reported as not existing, unless the caller is an admin.
+func (bs *batchService) Get(batchId string, caller domain.Caller) (*domain.BatchSummary, api_error.ApiErr) {
+	batch, err := bs.batchDao.Get(batchId)
+	if err != nil {
+		return nil, err
+	}
+	if !owns(batch.CreatedBy, caller) {
+		return nil, domain.WithCode(domain.CodeBatchNotFound, api_error.NewNotFoundError(fmt.Sprintf("batch with Id %v does not exist", batchId)))
+	}
+	statuses := make(map[string]domain.JobStatus)
+	for _, jobId := range batch.JobIds {
+		if job, err := bs.jobDao.Get(jobId); err == nil {
+			statuses[jobId] = job.Status
+		}
+	}
+	summary := batch.Summarize(statuses)
+	return &summary, nil
+}
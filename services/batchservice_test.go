@@ -0,0 +1,132 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestBatchService() (*jobsDaoMock, BatchService) {
+	m := &jobsDaoMock{}
+	return m, NewBatchService(config.New(), m, domain.NewBatchDao(), NewQuotaService(config.New(), m), noopTracer)
+}
+
+func TestCreateBatchNoJobs(t *testing.T) {
+	_, bs := newTestBatchService()
+	result, err := bs.Create([]domain.Job{}, domain.Caller{})
+	assert.Nil(t, result)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, "batch contains no jobs", err.Message())
+}
+
+func TestCreateBatchPerItemResults(t *testing.T) {
+	m, bs := newTestBatchService()
+	savedJobs := make(map[string]domain.Job)
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+		savedJobs[newJob.Id] = newJob
+		return &newJob, nil
+	}
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		if job, ok := savedJobs[jobId]; ok {
+			return &job, nil
+		}
+		return nil, api_error.NewNotFoundError("job not found")
+	}
+	inputJobs := []domain.Job{
+		{Type: "Create", SrcUrl: "http://server/path/file1.ext"},
+		{Type: "invalid_Type", SrcUrl: "http://server/path/file2.ext"},
+		{Type: "Create", SrcUrl: "http://server/path/file3.ext"},
+	}
+	result, err := bs.Create(inputJobs, domain.Caller{})
+	assert.Nil(t, err)
+	assert.NotNil(t, result)
+	assert.EqualValues(t, 2, result.Created)
+	assert.EqualValues(t, 1, result.Failed)
+	assert.EqualValues(t, 3, len(result.Items))
+	assert.EqualValues(t, result.Id, result.Items[0].Job.BatchId)
+	assert.Nil(t, result.Items[1].Job)
+	assert.EqualValues(t, "invalid job type", result.Items[1].Error.Detail)
+	assert.EqualValues(t, domain.CodeInvalidJobType, result.Items[1].Error.Code)
+	assert.EqualValues(t, 2, result.Items[2].Index)
+
+	summary, err := bs.Get(result.Id, domain.Caller{})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, summary.Total)
+	assert.EqualValues(t, 2, summary.Status[domain.JobStatusCreated])
+	assert.EqualValues(t, 0, summary.Progress)
+}
+
+func TestGetBatchNotFound(t *testing.T) {
+	_, bs := newTestBatchService()
+	summary, err := bs.Get("X", domain.Caller{})
+	assert.Nil(t, summary)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+}
+
+func TestGetBatchOfOtherUser(t *testing.T) {
+	m, bs := newTestBatchService()
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+		return &newJob, nil
+	}
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		return nil, api_error.NewNotFoundError("job not found")
+	}
+	result, err := bs.Create([]domain.Job{{Type: "Create", SrcUrl: "http://server/path/file1.ext"}}, domain.Caller{Name: "user A"})
+	assert.Nil(t, err)
+	summary, err := bs.Get(result.Id, domain.Caller{Name: "user B"})
+	assert.Nil(t, summary)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+	summary, err = bs.Get(result.Id, testAdmin)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, summary.Total)
+}
+
+func TestUpdateKeepsJobInBatch(t *testing.T) {
+	cfg := config.New()
+	jobDao := domain.NewJobDao()
+	quotaService := NewQuotaService(cfg, jobDao)
+	bs := NewBatchService(cfg, jobDao, domain.NewBatchDao(), quotaService, noopTracer)
+	js := NewJobService(cfg, jobDao, domain.NewIdempotencyDao(), quotaService, noopTracer)
+	result, err := bs.Create([]domain.Job{
+		{Type: "Create", SrcUrl: "http://server/path/file1.ext"},
+		{Type: "Create", SrcUrl: "http://server/path/file2.ext"},
+	}, domain.Caller{})
+	assert.Nil(t, err)
+	jobId := result.Items[0].Job.Id
+	checkpoint := &domain.Checkpoint{ETag: "etag", Offset: 4, States: map[string][]byte{}}
+	assert.Nil(t, jobDao.SetCheckpoint(jobId, checkpoint))
+	assert.Nil(t, jobDao.SetFileInfo(jobId, 12, "2022-01-01T00:00:00Z"))
+
+	updated, err := js.Update(jobId, domain.Job{Name: "renamed"}, true, domain.JobFlags{}, domain.Caller{})
+	assert.Nil(t, err)
+	assert.EqualValues(t, "renamed", updated.Name)
+	assert.EqualValues(t, result.Id, updated.BatchId)
+	stored, _ := jobDao.Get(jobId)
+	assert.EqualValues(t, result.Id, stored.BatchId)
+	assert.EqualValues(t, 12, stored.FileSize)
+	assert.EqualValues(t, checkpoint, stored.Checkpoint)
+
+	summary, err := bs.Get(result.Id, domain.Caller{})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, summary.Total)
+	assert.EqualValues(t, 2, summary.Status[domain.JobStatusCreated])
+	assert.EqualValues(t, 0, summary.Status[domain.BatchStatusRemoved])
+}
+
+func TestCreateBatchAllFailedIsNotKept(t *testing.T) {
+	_, bs := newTestBatchService()
+	result, err := bs.Create([]domain.Job{{Type: "invalid_Type", SrcUrl: "http://server/path/file1.ext"}}, domain.Caller{})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 0, result.Created)
+	assert.EqualValues(t, 1, result.Failed)
+	_, err = bs.Get(result.Id, domain.Caller{})
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+}
@@ -0,0 +1,71 @@
+package services
+
+import (
+	"fmt"
+
+	c4gen "github.com/Avalanche-io/c4/id"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/date"
+)
+
+type c4IndexService struct {
+	c4IndexDao domain.C4IndexDao
+}
+
+type C4IndexService interface {
+	AddJob(domain.Job) api_error.ApiErr
+	Get(string, domain.Caller) (*domain.C4Locations, api_error.ApiErr)
+}
+
+func NewC4IndexService(c4IndexDao domain.C4IndexDao) C4IndexService {
+	return &c4IndexService{
+		c4IndexDao: c4IndexDao,
+	}
+}
+
+// AddJob indexes the files of a finished job, including each file found by a tree job
+func (cs *c4IndexService) AddJob(job domain.Job) api_error.ApiErr {
+	if job.Status != domain.JobStatusFinished {
+		return domain.WithCode(domain.CodeJobNotFinished, api_error.NewBadRequestError("only finished jobs can be indexed"))
+	}
+	seenAt := date.GetNowUtcString()
+	for _, entry := range manifestEntries(job) {
+		indexEntry := domain.C4IndexEntry{
+			FileC4Id:  entry.FileC4Id,
+			Url:       entry.Path,
+			SrcUrl:    job.SrcUrl,
+			DstUrl:    job.DstUrl,
+			Size:      entry.Size,
+			JobId:     job.Id,
+			CreatedBy: job.CreatedBy,
+			SeenAt:    seenAt,
+		}
+		if err := cs.c4IndexDao.Add(indexEntry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Get returns where content with the C4 Id has been seen by jobs of the caller, or of all users for admins
+func (cs *c4IndexService) Get(c4Id string, caller domain.Caller) (*domain.C4Locations, api_error.ApiErr) {
+	if _, err := c4gen.Parse(c4Id); err != nil {
+		return nil, domain.WithCode(domain.CodeInvalidC4Id, api_error.NewBadRequestError("invalid C4 Id"))
+	}
+	locations, err := cs.c4IndexDao.Get(c4Id)
+	if err != nil {
+		return nil, err
+	}
+	owned := make([]domain.C4IndexEntry, 0, len(locations.Locations))
+	for _, entry := range locations.Locations {
+		if owns(entry.CreatedBy, caller) {
+			owned = append(owned, entry)
+		}
+	}
+	if len(owned) == 0 {
+		return nil, domain.WithCode(domain.CodeC4IdNotFound, api_error.NewNotFoundError(fmt.Sprintf("C4 Id %v has not been seen", c4Id)))
+	}
+	locations.Locations = owned
+	return locations, nil
+}
@@ -0,0 +1,78 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testC4Id = "c42FTpMRKrEEL6sgwRVRfxbzYDsYZe4VgsNVC7D6Jkqz8ABjsSAybKLYwPLGSJexGkJ9qt3aR8sMAjZ8fhKd7GfQsB"
+)
+
+func TestC4IndexAddJobNotFinished(t *testing.T) {
+	cs := NewC4IndexService(domain.NewC4IndexDao())
+	err := cs.AddJob(domain.Job{Status: "Running"})
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, "only finished jobs can be indexed", err.Message())
+}
+
+func TestC4IndexGetInvalidC4Id(t *testing.T) {
+	cs := NewC4IndexService(domain.NewC4IndexDao())
+	locations, err := cs.Get("abcdefg", domain.Caller{})
+	assert.Nil(t, locations)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, "invalid C4 Id", err.Message())
+}
+
+func TestC4IndexAddJobNoError(t *testing.T) {
+	cs := NewC4IndexService(domain.NewC4IndexDao())
+	job := domain.Job{
+		Id:       "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+		SrcUrl:   "https://server/media/file1.ext",
+		DstUrl:   "https://server/media/" + testC4Id + ".ext",
+		Type:     "CreateAndRename",
+		Status:   "Finished",
+		FileC4Id: testC4Id,
+		FileSize: 12,
+	}
+	err := cs.AddJob(job)
+	assert.Nil(t, err)
+	locations, err := cs.Get(testC4Id, domain.Caller{})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(locations.Locations))
+	assert.EqualValues(t, job.DstUrl, locations.Locations[0].Url)
+	assert.EqualValues(t, job.SrcUrl, locations.Locations[0].SrcUrl)
+	assert.EqualValues(t, job.Id, locations.Locations[0].JobId)
+	assert.EqualValues(t, 12, locations.Locations[0].Size)
+}
+
+func TestC4IndexGetOnlyOwnLocations(t *testing.T) {
+	cs := NewC4IndexService(domain.NewC4IndexDao())
+	job := domain.Job{
+		Id:        "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+		SrcUrl:    "https://server/media/file1.ext",
+		Status:    "Finished",
+		FileC4Id:  testC4Id,
+		CreatedBy: "user A",
+	}
+	assert.Nil(t, cs.AddJob(job))
+	job.Id = "1zXgBZNnBG1msmF1ARQK9ZphbdO"
+	job.CreatedBy = "user B"
+	assert.Nil(t, cs.AddJob(job))
+	locations, err := cs.Get(testC4Id, domain.Caller{Name: "user A"})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 1, len(locations.Locations))
+	assert.EqualValues(t, "1zXgBZNnBG1msmF1ARQK9ZphbbO", locations.Locations[0].JobId)
+	locations, err = cs.Get(testC4Id, domain.Caller{Name: "user C"})
+	assert.Nil(t, locations)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+	locations, err = cs.Get(testC4Id, testAdmin)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, len(locations.Locations))
+}
@@ -0,0 +1,156 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/providers"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/date"
+)
+
+const (
+	HeartbeatProcessor = "processor"
+	HeartbeatCleanup   = "cleanup"
+)
+
+// ProcessorHeartbeat names the heartbeat of one job processor, so that a hung processor is not
+// hidden by the others
+func ProcessorHeartbeat(worker int) string {
+	return fmt.Sprintf("%s-%d", HeartbeatProcessor, worker)
+}
+
+type healthService struct {
+	cfg        *config.AppConfig
+	jobDao     domain.JobDao
+	c4Provider providers.C4Provider
+	mu         sync.Mutex
+	beats      map[string]time.Time
+}
+
+type HealthService interface {
+	Beat(string)
+	Live() domain.HealthReport
+	Ready() domain.HealthReport
+}
+
+func NewHealthService(cfg *config.AppConfig, jobDao domain.JobDao, c4Provider providers.C4Provider) HealthService {
+	return &healthService{
+		cfg:        cfg,
+		jobDao:     jobDao,
+		c4Provider: c4Provider,
+		beats:      make(map[string]time.Time),
+	}
+}
+
+// Beat records that the named background loop is still alive
+func (hs *healthService) Beat(name string) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.beats[name] = time.Now()
+}
+
+func (hs *healthService) Live() domain.HealthReport {
+	report := domain.HealthReport{}
+	report.Add(HeartbeatProcessor, hs.checkProcessors(hs.cfg.NoJobWaitTime+hs.cfg.HeartbeatTimeout))
+	report.Add(HeartbeatCleanup, hs.checkHeartbeat(HeartbeatCleanup, hs.cfg.CleanupWaitTime+hs.cfg.HeartbeatTimeout))
+	return report
+}
+
+func (hs *healthService) Ready() domain.HealthReport {
+	report := hs.Live()
+	report.Add("job_store", checkWithTimeout(hs.cfg.HealthCheckTimeout, func(ctx context.Context) api_error.ApiErr {
+		return hs.jobDao.Ping()
+	}))
+	if limits := NewQueueLimits(hs.cfg); limits.Enabled() {
+		report.Add("queue", checkQueueLimits(limits, hs.jobDao.Stats()))
+	}
+	report.Add("storage_credentials", checkStorageCredentials(hs.cfg))
+	if hs.cfg.HealthCheckStorage {
+		report.Add("storage_account", checkWithTimeout(hs.cfg.HealthCheckTimeout, hs.c4Provider.CheckStorage))
+	}
+	return report
+}
+
+// checkProcessors reports the processors as down if any of them missed its heartbeat, and otherwise
+// with the oldest heartbeat of all processors
+func (hs *healthService) checkProcessors(maxAge time.Duration) domain.HealthCheck {
+	workers := hs.cfg.WorkerCount
+	if workers < 1 {
+		workers = 1
+	}
+	var oldest domain.HealthCheck
+	for i := 0; i < workers; i++ {
+		check := hs.checkHeartbeat(ProcessorHeartbeat(i), maxAge)
+		if check.Status != domain.HealthStatusUp {
+			check.Message = fmt.Sprintf("processor %d: %v", i, check.Message)
+			return check
+		}
+		if i == 0 || check.LastSeen < oldest.LastSeen {
+			oldest = check
+		}
+	}
+	return oldest
+}
+
+func (hs *healthService) checkHeartbeat(name string, maxAge time.Duration) domain.HealthCheck {
+	hs.mu.Lock()
+	last, ok := hs.beats[name]
+	hs.mu.Unlock()
+	if !ok {
+		return domain.HealthCheck{Status: domain.HealthStatusDown, Message: "no heartbeat yet"}
+	}
+	check := domain.HealthCheck{
+		Status:   domain.HealthStatusUp,
+		LastSeen: last.UTC().Format(date.ApiDateLayout),
+	}
+	if age := time.Since(last); age > maxAge {
+		check.Status = domain.HealthStatusDown
+		check.Message = fmt.Sprintf("no heartbeat for %v", age.Round(time.Second))
+	}
+	return check
+}
+
+func checkStorageCredentials(cfg *config.AppConfig) domain.HealthCheck {
+	if strings.TrimSpace(cfg.StorageAccountName) != "" && strings.TrimSpace(cfg.StorageAccountKey) != "" {
+		return domain.HealthCheck{Status: domain.HealthStatusUp}
+	}
+	if strings.TrimSpace(cfg.LocalRootDir) != "" {
+		return domain.HealthCheck{Status: domain.HealthStatusUp, Message: "no storage account access credentials, only local directories can be processed"}
+	}
+	return domain.HealthCheck{Status: domain.HealthStatusDown, Message: "no storage account access credentials"}
+}
+
+// checkQueueLimits reports whether the queue can take another job. A saturated queue does not make
+// the instance unready: only new jobs are rejected, while clients still need to poll, fetch results
+// and delete jobs to relieve the pressure.
+func checkQueueLimits(limits domain.QueueLimits, stats domain.QueueStats) domain.HealthCheck {
+	if reason := limits.Full(stats, 1); reason != "" {
+		return domain.HealthCheck{Status: domain.HealthStatusUp, Message: reason + ", new jobs are rejected"}
+	}
+	return domain.HealthCheck{Status: domain.HealthStatusUp}
+}
+
+// checkWithTimeout runs a dependency check, reporting it as down if it does not return within timeout
+func checkWithTimeout(timeout time.Duration, check func(context.Context) api_error.ApiErr) domain.HealthCheck {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	result := make(chan api_error.ApiErr, 1)
+	go func() {
+		result <- check(ctx)
+	}()
+	select {
+	case err := <-result:
+		if err != nil {
+			return domain.HealthCheck{Status: domain.HealthStatusDown, Message: err.Message()}
+		}
+		return domain.HealthCheck{Status: domain.HealthStatusUp}
+	case <-ctx.Done():
+		return domain.HealthCheck{Status: domain.HealthStatusDown, Message: "check timed out"}
+	}
+}
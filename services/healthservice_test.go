@@ -0,0 +1,107 @@
+package services
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestHealthService() (*jobsDaoMock, *healthService) {
+	m := &jobsDaoMock{}
+	return m, NewHealthService(config.New(), m, nil).(*healthService)
+}
+
+func TestLiveNoHeartbeat(t *testing.T) {
+	_, hs := newTestHealthService()
+	report := hs.Live()
+	assert.False(t, report.Up())
+	assert.EqualValues(t, domain.HealthStatusDown, report.Checks[HeartbeatProcessor].Status)
+	assert.EqualValues(t, "processor 0: no heartbeat yet", report.Checks[HeartbeatProcessor].Message)
+}
+
+func TestLiveStaleHeartbeat(t *testing.T) {
+	_, hs := newTestHealthService()
+	hs.Beat(ProcessorHeartbeat(0))
+	hs.Beat(HeartbeatCleanup)
+	hs.beats[ProcessorHeartbeat(0)] = time.Now().Add(-(hs.cfg.NoJobWaitTime + hs.cfg.HeartbeatTimeout + time.Minute))
+	report := hs.Live()
+	assert.False(t, report.Up())
+	assert.EqualValues(t, domain.HealthStatusDown, report.Checks[HeartbeatProcessor].Status)
+	assert.EqualValues(t, domain.HealthStatusUp, report.Checks[HeartbeatCleanup].Status)
+}
+
+func TestLiveOneProcessorStale(t *testing.T) {
+	_, hs := newTestHealthService()
+	hs.cfg.WorkerCount = 2
+	hs.Beat(ProcessorHeartbeat(0))
+	hs.Beat(ProcessorHeartbeat(1))
+	hs.Beat(HeartbeatCleanup)
+	report := hs.Live()
+	assert.True(t, report.Up())
+	hs.beats[ProcessorHeartbeat(1)] = time.Now().Add(-(hs.cfg.NoJobWaitTime + hs.cfg.HeartbeatTimeout + time.Minute))
+	hs.Beat(ProcessorHeartbeat(0))
+	report = hs.Live()
+	assert.False(t, report.Up())
+	assert.EqualValues(t, domain.HealthStatusDown, report.Checks[HeartbeatProcessor].Status)
+	assert.True(t, strings.HasPrefix(report.Checks[HeartbeatProcessor].Message, "processor 1: no heartbeat for"))
+}
+
+func TestReadyNoError(t *testing.T) {
+	m, hs := newTestHealthService()
+	m.pingFunction = func() api_error.ApiErr {
+		return nil
+	}
+	hs.cfg.StorageAccountName, hs.cfg.StorageAccountKey = "account", "key"
+	hs.Beat(ProcessorHeartbeat(0))
+	hs.Beat(HeartbeatCleanup)
+	report := hs.Ready()
+	assert.True(t, report.Up())
+	assert.EqualValues(t, 4, len(report.Checks))
+}
+
+func TestReadyJobStoreDown(t *testing.T) {
+	m, hs := newTestHealthService()
+	m.pingFunction = func() api_error.ApiErr {
+		return api_error.NewInternalServerError("job store not available", nil)
+	}
+	hs.Beat(ProcessorHeartbeat(0))
+	hs.Beat(HeartbeatCleanup)
+	report := hs.Ready()
+	assert.False(t, report.Up())
+	assert.EqualValues(t, "job store not available", report.Checks["job_store"].Message)
+	assert.EqualValues(t, "no storage account access credentials", report.Checks["storage_credentials"].Message)
+}
+
+func TestReadyQueueSaturated(t *testing.T) {
+	m, hs := newTestHealthService()
+	m.pingFunction = func() api_error.ApiErr {
+		return nil
+	}
+	m.statsFunction = func() domain.QueueStats {
+		return domain.QueueStats{Queued: 3, Memory: 4096}
+	}
+	hs.cfg.StorageAccountName, hs.cfg.StorageAccountKey = "account", "key"
+	hs.cfg.QueueMaxMemory = 4096
+	hs.Beat(ProcessorHeartbeat(0))
+	hs.Beat(HeartbeatCleanup)
+	report := hs.Ready()
+	assert.True(t, report.Up())
+	assert.EqualValues(t, domain.HealthStatusUp, report.Checks["queue"].Status)
+	assert.EqualValues(t, "job store is full, 4096 of 4096 bytes used, new jobs are rejected", report.Checks["queue"].Message)
+}
+
+func TestCheckWithTimeout(t *testing.T) {
+	check := checkWithTimeout(time.Millisecond, func(ctx context.Context) api_error.ApiErr {
+		<-ctx.Done()
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+	assert.EqualValues(t, domain.HealthStatusDown, check.Status)
+	assert.EqualValues(t, "check timed out", check.Message)
+}
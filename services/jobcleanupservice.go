@@ -1,32 +1,82 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/johannes-kuhfuss/c4svc/config"
 	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/metrics"
 	"github.com/johannes-kuhfuss/services_utils/logger"
 )
 
-var (
-	JobCleanupService jobCleanupServiceInterface = &jobCleanupService{}
-)
+type jobCleanupService struct {
+	cfg            *config.AppConfig
+	jobDao         domain.JobDao
+	idempotencyDao domain.IdempotencyDao
+	batchDao       domain.BatchDao
+	c4IndexDao     domain.C4IndexDao
+	healthService  HealthService
+	metrics        *metrics.Metrics
+}
 
-type jobCleanupService struct{}
+type JobCleanupService interface {
+	Cleanup(context.Context)
+}
 
-type jobCleanupServiceInterface interface {
-	Cleanup()
+func NewJobCleanupService(cfg *config.AppConfig, jobDao domain.JobDao, idempotencyDao domain.IdempotencyDao, batchDao domain.BatchDao, c4IndexDao domain.C4IndexDao, healthService HealthService, metrics *metrics.Metrics) JobCleanupService {
+	return &jobCleanupService{
+		cfg:            cfg,
+		jobDao:         jobDao,
+		idempotencyDao: idempotencyDao,
+		batchDao:       batchDao,
+		c4IndexDao:     c4IndexDao,
+		healthService:  healthService,
+		metrics:        metrics,
+	}
 }
 
-func (jc *jobCleanupService) Cleanup() {
-	for !config.ShutDown {
-		time.Sleep(config.CleanupWaitTime)
-		jobsCleaned, err := domain.JobDao.CleanJobs(config.DeleteFinishedAge, config.DeleteFailedAge)
+// Cleanup removes expired jobs, idempotency keys and batches, and the C4 index entries of removed jobs, until ctx is done
+func (jc *jobCleanupService) Cleanup(ctx context.Context) {
+	for {
+		jc.healthService.Beat(HeartbeatCleanup)
+		if !sleep(ctx, jc.cfg.CleanupWaitTime) {
+			return
+		}
+		jobsCleaned, err := jc.jobDao.CleanJobs(jc.cfg.DeleteFinishedAge, jc.cfg.DeleteFailedAge)
 		if err != nil {
 			logger.Info(err.Message())
 		} else {
 			logger.Info(fmt.Sprintf("Removed %d jobs in state Finished or Failed", jobsCleaned))
+			jc.metrics.CleanupDeletions.WithLabelValues("jobs").Add(float64(jobsCleaned))
 		}
+		entriesCleaned := jc.c4IndexDao.CleanEntries(func(jobId string) bool {
+			_, err := jc.jobDao.Get(jobId)
+			return err == nil
+		})
+		logger.Info(fmt.Sprintf("Removed %d C4 index entries of removed jobs", entriesCleaned))
+		jc.metrics.CleanupDeletions.WithLabelValues("c4_index_entries").Add(float64(entriesCleaned))
+		keysCleaned := jc.idempotencyDao.CleanKeys(jc.cfg.IdempotencyAge)
+		logger.Info(fmt.Sprintf("Removed %d expired idempotency keys", keysCleaned))
+		jc.metrics.CleanupDeletions.WithLabelValues("idempotency_keys").Add(float64(keysCleaned))
+		batchesCleaned := jc.batchDao.CleanBatches(jc.cfg.DeleteBatchAge, func(jobId string) bool {
+			job, err := jc.jobDao.Get(jobId)
+			return err != nil || job.Status == domain.JobStatusFinished || job.Status == domain.JobStatusFailed || job.Status == domain.JobStatusPartial
+		})
+		logger.Info(fmt.Sprintf("Removed %d expired batches of which all jobs are done", batchesCleaned))
+		jc.metrics.CleanupDeletions.WithLabelValues("batches").Add(float64(batchesCleaned))
+	}
+}
+
+// sleep waits for d and reports false if ctx was done before
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
 }
@@ -1,63 +1,269 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"time"
 
 	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/metrics"
 	"github.com/johannes-kuhfuss/c4svc/providers"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/date"
 	"github.com/johannes-kuhfuss/services_utils/logger"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
-	JobProcService jobProcServiceInterface = &jobProcService{}
+	// processorCaller is the identity of the job processor, which works on jobs of all users
+	processorCaller = domain.Caller{Roles: []string{domain.RoleAdmin}}
 )
 
-type jobProcService struct{}
+type jobProcService struct {
+	cfg            *config.AppConfig
+	jobService     JobService
+	c4IndexService C4IndexService
+	healthService  HealthService
+	quotaService   QuotaService
+	c4Provider     providers.C4Provider
+	metrics        *metrics.Metrics
+	tracer         trace.Tracer
+}
+
+type JobProcService interface {
+	Process(context.Context, int)
+}
+
+func NewJobProcService(cfg *config.AppConfig, jobService JobService, c4IndexService C4IndexService, healthService HealthService, quotaService QuotaService, c4Provider providers.C4Provider, metrics *metrics.Metrics, tracer trace.Tracer) JobProcService {
+	return &jobProcService{
+		cfg:            cfg,
+		jobService:     jobService,
+		c4IndexService: c4IndexService,
+		healthService:  healthService,
+		quotaService:   quotaService,
+		c4Provider:     c4Provider,
+		metrics:        metrics,
+		tracer:         tracer,
+	}
+}
+
+type jobProgress struct {
+	job            *domain.Job
+	jobService     JobService
+	quotaService   QuotaService
+	bytesProcessed int64
+	releaseBytes   func()
+}
 
-type jobProcServiceInterface interface {
-	Process()
+// Start reserves the bytes of the source against the daily quota of the job's owner before they are read
+func (tp *jobProgress) Start(size int64) api_error.ApiErr {
+	release, err := tp.quotaService.ReserveBytes(tp.job.CreatedBy, size)
+	if err != nil {
+		return err
+	}
+	tp.releaseBytes = release
+	return nil
+}
+
+// release frees the reserved bytes, once the bytes actually hashed have been added to the quota
+func (tp *jobProgress) release() {
+	if tp.releaseBytes != nil {
+		tp.releaseBytes()
+	}
 }
 
-func (jp *jobProcService) Process() {
-	for !config.ShutDown {
-		curJob, err := JobService.GetNext()
+func (tp *jobProgress) Bytes(progress domain.JobProgress) {
+	tp.bytesProcessed = progress.BytesProcessed - progress.BytesResumed
+	err := tp.jobService.SetProgress(tp.job.Id, progress)
+	if err != nil {
+		logger.Error("could not set progress", err, tp.job.LogFields()...)
+	}
+}
+
+func (tp *jobProgress) Checkpoint(checkpoint *domain.Checkpoint) {
+	err := tp.jobService.SetCheckpoint(tp.job.Id, checkpoint)
+	if err != nil {
+		logger.Error("could not save checkpoint", err, tp.job.LogFields()...)
+	}
+}
+
+func (tp *jobProgress) Total(total int) {
+	err := tp.jobService.SetFilesTotal(tp.job.Id, total)
+	if err != nil {
+		logger.Error("could not set number of files", err, tp.job.LogFields()...)
+	}
+}
+
+func (tp *jobProgress) FileDone(file domain.TreeFile) {
+	err := tp.jobService.AddFile(tp.job.Id, file)
+	if err != nil {
+		logger.Error("could not add file", err, tp.job.LogFields()...)
+	}
+}
+
+// keepBeating beats the heartbeat of the processor while a job runs, also when the provider reports
+// no progress for a while, until the returned function is called
+func (jp *jobProcService) keepBeating(heartbeat string) func() {
+	interval := jp.cfg.HeartbeatTimeout / 2
+	if interval <= 0 {
+		interval = time.Second
+	}
+	done := make(chan struct{})
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				jp.healthService.Beat(heartbeat)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() {
+		close(done)
+	}
+}
+
+// Process runs jobs from the queue until ctx is done. Each worker beats its own heartbeat.
+func (jp *jobProcService) Process(ctx context.Context, worker int) {
+	heartbeat := ProcessorHeartbeat(worker)
+	for ctx.Err() == nil {
+		jp.healthService.Beat(heartbeat)
+		curJob, err := jp.jobService.GetNext()
 		if err == nil {
-			logger.Info(fmt.Sprintf("Found job with Id %v to process", curJob.Id))
-			rename := curJob.Type == "CreateAndRename"
-			c4Id, dstUrl, err := providers.C4Provider.ProcessFile(curJob.SrcUrl, rename)
+			logger.Info(fmt.Sprintf("Found job with Id %v to process", curJob.Id), curJob.LogFields()...)
+			// not derived from ctx, so a job that has started is finished on shutdown
+			spanCtx := curJob.SpanContext(context.Background())
+			jp.traceQueueWait(spanCtx, curJob)
+			spanCtx, span := jp.tracer.Start(spanCtx, "job.process", trace.WithAttributes(jobAttributes(curJob)...))
+			start := time.Now()
+			jp.metrics.WorkerBusy.Inc()
+			var result *providers.ProcessResult
+			progress := jobProgress{job: curJob, jobService: jp.jobService, quotaService: jp.quotaService}
+			stopBeating := jp.keepBeating(heartbeat)
+			if curJob.Type == domain.JobTypeTree {
+				result, err = jp.c4Provider.ProcessTree(spanCtx, *curJob, &progress)
+			} else {
+				result, err = jp.c4Provider.ProcessFile(spanCtx, *curJob, &progress)
+			}
+			stopBeating()
+			processErr := err
+			elapsed := time.Since(start).Seconds()
+			jp.metrics.WorkerBusy.Dec()
+			jp.metrics.WorkerBusySeconds.Add(elapsed)
+			jp.metrics.ProcessingDuration.WithLabelValues(string(curJob.Type)).Observe(elapsed)
 			if err != nil {
-				logger.Error("could process file", err)
-				err = JobService.SetErrMsg(curJob.Id, fmt.Sprintf("Could not process file: %s", err.Message()))
+				jp.metrics.JobsFailed.WithLabelValues(string(curJob.Type), metrics.ErrorClass(err)).Inc()
+				logger.Error("could process file", err, curJob.LogFields()...)
+				// bytes hashed before the failure count towards the quota as well
+				if progress.bytesProcessed > 0 {
+					jp.quotaService.AddBytes(curJob.CreatedBy, progress.bytesProcessed)
+				}
+				progress.release()
+				err = jp.jobService.SetErrMsg(curJob.Id, domain.ErrorCode(err), fmt.Sprintf("Could not process file: %s", err.Message()))
 				if err != nil {
-					logger.Error("could not set error message", err)
+					logger.Error("could not set error message", err, curJob.LogFields()...)
 				}
-				err = JobService.ChangeStatus(curJob.Id, "Failed")
+				err = jp.jobService.ChangeStatus(curJob.Id, "Failed")
 				if err != nil {
-					logger.Error("could not change job status", err)
+					logger.Error("could not change job status", err, curJob.LogFields()...)
 				}
 			} else {
-				err = JobService.SetC4Id(curJob.Id, *c4Id)
+				jp.metrics.JobsProcessed.WithLabelValues(string(curJob.Type)).Inc()
+				if result.BytesHashed > 0 {
+					jp.metrics.BytesHashed.WithLabelValues(string(curJob.Type)).Observe(float64(result.BytesHashed))
+					jp.quotaService.AddBytes(curJob.CreatedBy, result.BytesHashed)
+				}
+				progress.release()
+				err = jp.jobService.SetC4Id(curJob.Id, result.C4Id)
 				if err != nil {
-					logger.Error("could not set C4 Id", err)
+					logger.Error("could not set C4 Id", err, curJob.LogFields()...)
+				}
+				if result.DstUrl != "" {
+					err = jp.jobService.SetDstUrl(curJob.Id, result.DstUrl)
+					if err != nil {
+						logger.Error("could not set destination URL", err, curJob.LogFields()...)
+					}
+				}
+				if result.LastModified != "" {
+					err = jp.jobService.SetFileInfo(curJob.Id, result.Size, result.LastModified)
+					if err != nil {
+						logger.Error("could not set file info", err, curJob.LogFields()...)
+					}
+				}
+				if len(result.Digests) > 0 {
+					err = jp.jobService.SetDigests(curJob.Id, result.Digests)
+					if err != nil {
+						logger.Error("could not set digests", err, curJob.LogFields()...)
+					}
+				}
+				if result.FromMetadata {
+					err = jp.jobService.SetFromMetadata(curJob.Id, true)
+					if err != nil {
+						logger.Error("could not set metadata flag", err, curJob.LogFields()...)
+					}
 				}
-				if rename {
-					err = JobService.SetDstUrl(curJob.Id, *dstUrl)
+				if curJob.Type != domain.JobTypeTree {
+					err = jp.jobService.SetCheckpoint(curJob.Id, nil)
 					if err != nil {
-						logger.Error("could not set destination URL", err)
+						logger.Error("could not clear checkpoint", err, curJob.LogFields()...)
 					}
 				}
-				err = JobService.ChangeStatus(curJob.Id, "Finished")
+				status := "Finished"
+				if result.FilesFailed > 0 {
+					status = "Partial"
+					err = jp.jobService.SetErrMsg(curJob.Id, domain.CodePartiallyFailed, fmt.Sprintf("%d files could not be identified", result.FilesFailed))
+					if err != nil {
+						logger.Error("could not set error message", err, curJob.LogFields()...)
+					}
+				}
+				err = jp.jobService.ChangeStatus(curJob.Id, status)
 				if err != nil {
-					logger.Error("could not change job status", err)
+					logger.Error("could not change job status", err, curJob.LogFields()...)
+				}
+				// partially failed trees are not indexed, their tree C4 Id does not cover all files
+				if result.FilesFailed == 0 {
+					finishedJob, err := jp.jobService.Get(curJob.Id, processorCaller)
+					if err == nil {
+						err = jp.c4IndexService.AddJob(*finishedJob)
+					}
+					if err != nil {
+						logger.Error("could not add job to C4 Id index", err, curJob.LogFields()...)
+					}
 				}
 			}
-
-			logger.Info(fmt.Sprintf("Done processing job with Id %v", curJob.Id))
+			endSpan(span, processErr)
+			logger.Info(fmt.Sprintf("Done processing job with Id %v", curJob.Id), curJob.LogFields()...)
 		} else {
 			logger.Debug("no job found. Sleeping...")
-			time.Sleep(time.Second * time.Duration(config.NoJobWaitTime))
+			sleep(ctx, jp.cfg.NoJobWaitTime)
 		}
 
 	}
 }
+
+// traceQueueWait records the time the job waited in the queue since it was created or retried
+func (jp *jobProcService) traceQueueWait(ctx context.Context, job *domain.Job) {
+	queuedAt := job.QueuedAt
+	if queuedAt == "" {
+		queuedAt = job.CreatedAt
+	}
+	start, err := time.Parse(date.ApiDateLayout, queuedAt)
+	if err != nil {
+		return
+	}
+	_, span := jp.tracer.Start(ctx, "job.queue_wait", trace.WithTimestamp(start), trace.WithAttributes(jobAttributes(job)...))
+	span.End()
+}
+
+func jobAttributes(job *domain.Job) []attribute.KeyValue {
+	return []attribute.KeyValue{
+		attribute.String("job.id", job.Id),
+		attribute.String("job.type", string(job.Type)),
+		attribute.String("request.id", job.RequestId),
+	}
+}
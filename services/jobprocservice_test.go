@@ -0,0 +1,231 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/metrics"
+	"github.com/johannes-kuhfuss/c4svc/providers"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/date"
+	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+type c4ProviderMock struct {
+	processFileFunction func(ctx context.Context, job domain.Job, progress providers.FileProgress) (*providers.ProcessResult, api_error.ApiErr)
+	processTreeFunction func(ctx context.Context, job domain.Job, progress providers.TreeProgress) (*providers.ProcessResult, api_error.ApiErr)
+}
+
+func (m *c4ProviderMock) ProcessFile(ctx context.Context, job domain.Job, progress providers.FileProgress) (*providers.ProcessResult, api_error.ApiErr) {
+	return m.processFileFunction(ctx, job, progress)
+}
+
+func (m *c4ProviderMock) ProcessTree(ctx context.Context, job domain.Job, progress providers.TreeProgress) (*providers.ProcessResult, api_error.ApiErr) {
+	return m.processTreeFunction(ctx, job, progress)
+}
+
+func (m *c4ProviderMock) CheckStorage(ctx context.Context) api_error.ApiErr {
+	return nil
+}
+
+// newTestJobProcService processes the jobs of a real job store with the mocked provider and records the spans
+func newTestJobProcService(cfg *config.AppConfig, provider providers.C4Provider) (domain.JobDao, JobProcService, *tracetest.SpanRecorder) {
+	recorder := tracetest.NewSpanRecorder()
+	tracer := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("")
+	jobDao := domain.NewJobDao()
+	quotaService := NewQuotaService(cfg, jobDao)
+	jobService := NewJobService(cfg, jobDao, domain.NewIdempotencyDao(), quotaService, tracer)
+	healthService := NewHealthService(cfg, jobDao, provider)
+	jp := NewJobProcService(cfg, jobService, NewC4IndexService(domain.NewC4IndexDao()), healthService, quotaService, provider, metrics.New(jobDao, NewQueueLimits(cfg)), tracer)
+	return jobDao, jp, recorder
+}
+
+func endedSpan(recorder *tracetest.SpanRecorder, name string) sdktrace.ReadOnlySpan {
+	for _, span := range recorder.Ended() {
+		if span.Name() == name {
+			return span
+		}
+	}
+	return nil
+}
+
+func TestProcessTracesQueueWaitAndProcessing(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var providerSpan trace.SpanContext
+	provider := &c4ProviderMock{
+		processFileFunction: func(spanCtx context.Context, job domain.Job, progress providers.FileProgress) (*providers.ProcessResult, api_error.ApiErr) {
+			providerSpan = trace.SpanContextFromContext(spanCtx)
+			cancel()
+			return &providers.ProcessResult{C4Id: testC4Id}, nil
+		},
+	}
+	jobDao, jp, recorder := newTestJobProcService(config.New(), provider)
+	queuedAt := date.GetNowUtc().Add(-time.Minute)
+	jobDao.Save(domain.Job{
+		Id:           "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+		Type:         domain.JobTypeCreate,
+		SrcUrl:       "https://server/media/file1.ext",
+		Status:       domain.JobStatusCreated,
+		CreatedAt:    queuedAt.Format(date.ApiDateLayout),
+		QueuedAt:     queuedAt.Format(date.ApiDateLayout),
+		TraceContext: map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}, false)
+
+	jp.Process(ctx, 0)
+
+	job, err := jobDao.Get("1zXgBZNnBG1msmF1ARQK9ZphbbO")
+	assert.Nil(t, err)
+	assert.EqualValues(t, domain.JobStatusFinished, job.Status)
+	queueWait := endedSpan(recorder, "job.queue_wait")
+	assert.NotNil(t, queueWait)
+	assert.True(t, queueWait.EndTime().Sub(queueWait.StartTime()) >= time.Minute)
+	assert.EqualValues(t, "4bf92f3577b34da6a3ce929d0e0e4736", queueWait.SpanContext().TraceID().String())
+	process := endedSpan(recorder, "job.process")
+	assert.NotNil(t, process)
+	assert.EqualValues(t, "00f067aa0ba902b7", process.Parent().SpanID().String())
+	assert.EqualValues(t, process.SpanContext().SpanID(), providerSpan.SpanID())
+}
+
+func TestProcessRecordsFailureOnSpan(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	provider := &c4ProviderMock{
+		processFileFunction: func(spanCtx context.Context, job domain.Job, progress providers.FileProgress) (*providers.ProcessResult, api_error.ApiErr) {
+			cancel()
+			return nil, domain.WithCode(domain.CodeStorageFileNotAccessible, api_error.NewBadRequestError("Cannot access file on storage account"))
+		},
+	}
+	jobDao, jp, recorder := newTestJobProcService(config.New(), provider)
+	jobDao.Save(domain.Job{
+		Id:        "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+		Type:      domain.JobTypeCreate,
+		SrcUrl:    "https://server/media/file1.ext",
+		Status:    domain.JobStatusCreated,
+		CreatedAt: date.GetNowUtcString(),
+	}, false)
+
+	jp.Process(ctx, 0)
+
+	job, _ := jobDao.Get("1zXgBZNnBG1msmF1ARQK9ZphbbO")
+	assert.EqualValues(t, domain.JobStatusFailed, job.Status)
+	assert.EqualValues(t, domain.CodeStorageFileNotAccessible, job.ErrorCode)
+	process := endedSpan(recorder, "job.process")
+	assert.NotNil(t, process)
+	assert.EqualValues(t, "Cannot access file on storage account", process.Status().Description)
+}
+
+func TestProcessBeatsWhileJobRuns(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := config.New()
+	cfg.NoJobWaitTime = 0
+	cfg.HeartbeatTimeout = 20 * time.Millisecond
+	jobDao := domain.NewJobDao()
+	quotaService := NewQuotaService(cfg, jobDao)
+	jobService := NewJobService(cfg, jobDao, domain.NewIdempotencyDao(), quotaService, noopTracer)
+	var live domain.HealthReport
+	provider := &c4ProviderMock{}
+	healthService := NewHealthService(cfg, jobDao, provider)
+	provider.processFileFunction = func(spanCtx context.Context, job domain.Job, progress providers.FileProgress) (*providers.ProcessResult, api_error.ApiErr) {
+		// no progress is reported for longer than the heartbeat timeout
+		time.Sleep(100 * time.Millisecond)
+		live = healthService.Live()
+		cancel()
+		return &providers.ProcessResult{C4Id: testC4Id}, nil
+	}
+	jp := NewJobProcService(cfg, jobService, NewC4IndexService(domain.NewC4IndexDao()), healthService, quotaService, provider, metrics.New(jobDao, NewQueueLimits(cfg)), noopTracer)
+	jobDao.Save(domain.Job{
+		Id:        "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+		Type:      domain.JobTypeCreate,
+		SrcUrl:    "https://server/media/file1.ext",
+		Status:    domain.JobStatusCreated,
+		CreatedAt: date.GetNowUtcString(),
+	}, false)
+
+	jp.Process(ctx, 0)
+
+	assert.EqualValues(t, domain.HealthStatusUp, live.Checks[HeartbeatProcessor].Status)
+}
+
+func TestProcessEnforcesBytesQuotaBeforeReading(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	cfg := config.New()
+	cfg.QuotaMaxBytesDay = 100
+	cfg.NoJobWaitTime = 0
+	read := 0
+	calls := 0
+	provider := &c4ProviderMock{
+		processFileFunction: func(spanCtx context.Context, job domain.Job, progress providers.FileProgress) (*providers.ProcessResult, api_error.ApiErr) {
+			calls++
+			if calls == 3 {
+				cancel()
+			}
+			if err := progress.Start(60); err != nil {
+				return nil, err
+			}
+			read++
+			return &providers.ProcessResult{C4Id: testC4Id, BytesHashed: 60}, nil
+		},
+	}
+	jobDao, jp, _ := newTestJobProcService(cfg, provider)
+	ids := []string{"1zXgBZNnBG1msmF1ARQK9ZphbbO", "1zXgBZNnBG1msmF1ARQK9ZphbcO", "1zXgBZNnBG1msmF1ARQK9ZphbdO"}
+	for i, id := range ids {
+		queuedAt := date.GetNowUtc().Add(time.Duration(i-len(ids)) * time.Minute).Format(date.ApiDateLayout)
+		jobDao.Save(domain.Job{
+			Id:        id,
+			Type:      domain.JobTypeCreate,
+			SrcUrl:    "https://server/media/file1.ext",
+			Status:    domain.JobStatusCreated,
+			CreatedAt: queuedAt,
+			QueuedAt:  queuedAt,
+			CreatedBy: "user A",
+		}, false)
+	}
+
+	jp.Process(ctx, 0)
+
+	assert.EqualValues(t, 3, calls)
+	assert.EqualValues(t, 1, read)
+	job, _ := jobDao.Get(ids[0])
+	assert.EqualValues(t, domain.JobStatusFinished, job.Status)
+	for _, id := range ids[1:] {
+		job, _ := jobDao.Get(id)
+		assert.EqualValues(t, domain.JobStatusFailed, job.Status)
+		assert.EqualValues(t, domain.CodeQuotaExceeded, job.ErrorCode)
+	}
+}
+
+func TestProcessFinishesPartiallyFailedTree(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	provider := &c4ProviderMock{
+		processTreeFunction: func(spanCtx context.Context, job domain.Job, progress providers.TreeProgress) (*providers.ProcessResult, api_error.ApiErr) {
+			cancel()
+			return &providers.ProcessResult{C4Id: testC4Id, FilesFailed: 2}, nil
+		},
+	}
+	jobDao, jp, _ := newTestJobProcService(config.New(), provider)
+	jobDao.Save(domain.Job{
+		Id:        "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+		Type:      domain.JobTypeTree,
+		SrcUrl:    "file:///data/tree",
+		Status:    domain.JobStatusCreated,
+		CreatedAt: date.GetNowUtcString(),
+	}, false)
+
+	jp.Process(ctx, 0)
+
+	job, _ := jobDao.Get("1zXgBZNnBG1msmF1ARQK9ZphbbO")
+	assert.EqualValues(t, domain.JobStatusPartial, job.Status)
+	assert.EqualValues(t, testC4Id, job.FileC4Id)
+	assert.EqualValues(t, domain.CodePartiallyFailed, job.ErrorCode)
+	assert.EqualValues(t, "2 files could not be identified", job.ErrorMsg)
+}
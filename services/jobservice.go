@@ -1,38 +1,106 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"strings"
+	"sync"
 
+	"github.com/johannes-kuhfuss/c4svc/config"
 	"github.com/johannes-kuhfuss/c4svc/domain"
 	"github.com/johannes-kuhfuss/services_utils/api_error"
 	"github.com/johannes-kuhfuss/services_utils/date"
 	"github.com/segmentio/ksuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
-	JobService jobServiceInterface = &jobService{}
-)
-
-type jobService struct{}
+type jobService struct {
+	cfg            *config.AppConfig
+	jobDao         domain.JobDao
+	idempotencyDao domain.IdempotencyDao
+	quotaService   QuotaService
+	policy         domain.SourcePolicy
+	tracer         trace.Tracer
+	createMu       sync.Mutex
+}
 
-type jobServiceInterface interface {
-	Create(domain.Job) (*domain.Job, api_error.ApiErr)
-	Get(string) (*domain.Job, api_error.ApiErr)
-	Delete(string) api_error.ApiErr
-	Update(string, domain.Job, bool) (*domain.Job, api_error.ApiErr)
+type JobService interface {
+	Create(domain.Job, domain.Caller) (*domain.Job, api_error.ApiErr)
+	CreateIdempotent(domain.Job, string, domain.Caller) (*domain.Job, bool, api_error.ApiErr)
+	Get(string, domain.Caller) (*domain.Job, api_error.ApiErr)
+	Delete(string, domain.Caller) api_error.ApiErr
+	Retry(string, domain.Caller) (*domain.Job, api_error.ApiErr)
+	Update(string, domain.Job, bool, domain.JobFlags, domain.Caller) (*domain.Job, api_error.ApiErr)
 	GetNext() (*domain.Job, api_error.ApiErr)
 	ChangeStatus(string, string) api_error.ApiErr
 	SetC4Id(string, string) api_error.ApiErr
 	SetDstUrl(string, string) api_error.ApiErr
-	SetErrMsg(string, string) api_error.ApiErr
-	GetAll() (*domain.Jobs, api_error.ApiErr)
+	SetErrMsg(string, string, string) api_error.ApiErr
+	SetFromMetadata(string, bool) api_error.ApiErr
+	SetFileInfo(string, int64, string) api_error.ApiErr
+	SetDigests(string, map[string]string) api_error.ApiErr
+	SetFilesTotal(string, int) api_error.ApiErr
+	AddFile(string, domain.TreeFile) api_error.ApiErr
+	SetProgress(string, domain.JobProgress) api_error.ApiErr
+	SetCheckpoint(string, *domain.Checkpoint) api_error.ApiErr
+	GetAll(domain.Caller) (*domain.Jobs, api_error.ApiErr)
+}
+
+func NewJobService(cfg *config.AppConfig, jobDao domain.JobDao, idempotencyDao domain.IdempotencyDao, quotaService QuotaService, tracer trace.Tracer) JobService {
+	return &jobService{
+		cfg:            cfg,
+		jobDao:         jobDao,
+		idempotencyDao: idempotencyDao,
+		quotaService:   quotaService,
+		policy:         newSourcePolicy(cfg),
+		tracer:         tracer,
+	}
+}
+
+func newSourcePolicy(cfg *config.AppConfig) domain.SourcePolicy {
+	return domain.SourcePolicy{
+		Schemes:               config.SplitList(cfg.PolicySchemes),
+		Accounts:              config.SplitList(cfg.PolicyAccounts),
+		Containers:            config.SplitList(cfg.PolicyContainers),
+		AllowedPrefixes:       domain.NormalizePrefixes(config.SplitList(cfg.PolicyAllowed)),
+		DeniedPrefixes:        domain.NormalizePrefixes(config.SplitList(cfg.PolicyDenied)),
+		DestructivePrincipals: config.SplitList(cfg.PolicyDestructive),
+	}
+}
+
+// Create creates a job on behalf of the caller. CreatedBy is taken from the caller, not from the input job.
+func (j *jobService) Create(inputJob domain.Job, caller domain.Caller) (*domain.Job, api_error.ApiErr) {
+	if err := checkQueue(j.cfg, j.jobDao, 1); err != nil {
+		return nil, err
+	}
+	release, err := j.quotaService.Reserve(caller, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	return createJob(j.jobDao, j.policy, j.tracer, inputJob, "", caller)
 }
 
-func (j *jobService) Create(inputJob domain.Job) (*domain.Job, api_error.ApiErr) {
+// createJob queues a new job. The job.enqueue span continues the trace stored on the input job,
+// and its context is stored on the new job for the spans of its processing.
+func createJob(jobDao domain.JobDao, policy domain.SourcePolicy, tracer trace.Tracer, inputJob domain.Job, batchId string, caller domain.Caller) (savedJob *domain.Job, err api_error.ApiErr) {
+	ctx, span := tracer.Start(inputJob.SpanContext(context.Background()), "job.enqueue", trace.WithAttributes(
+		attribute.String("job.type", string(inputJob.Type)),
+		attribute.String("request.id", inputJob.RequestId),
+	))
+	defer func() {
+		if savedJob != nil {
+			span.SetAttributes(attribute.String("job.id", savedJob.Id))
+		}
+		endSpan(span, err)
+	}()
 	if err := inputJob.Validate(); err != nil {
 		return nil, err
 	}
+	if err := policy.Check(inputJob, caller); err != nil {
+		return nil, err
+	}
 	request := domain.Job{}
 	request.Id = ksuid.New().String()
 	if strings.TrimSpace(inputJob.Name) != "" {
@@ -41,48 +109,177 @@ func (j *jobService) Create(inputJob domain.Job) (*domain.Job, api_error.ApiErr)
 		request.Name = fmt.Sprintf("Job @ %s", date.GetNowUtcString())
 	}
 	request.CreatedAt = date.GetNowUtcString()
+	request.QueuedAt = request.CreatedAt
+	request.CreatedBy = caller.Name
 	request.SrcUrl = inputJob.SrcUrl
 	request.DstUrl = ""
 	request.Type = inputJob.Type
 	request.Status = domain.JobStatusCreated
-	savedJob, err := domain.JobDao.Save(request, false)
+	request.WriteMetadata = inputJob.WriteMetadata
+	request.WriteTags = inputJob.WriteTags
+	request.TrustMetadata = inputJob.TrustMetadata
+	request.DigestTypes = inputJob.DigestTypes
+	request.BatchId = batchId
+	request.RequestId = inputJob.RequestId
+	request.TraceContext = domain.NewTraceContext(ctx)
+	return jobDao.Save(request, false)
+}
+
+// CreateIdempotent returns the job originally created for a repeated idempotency key or, when
+// coalescing is enabled, a pending job of the caller for the same source and type. The flag reports
+// whether an existing job was returned instead of creating a new one. Idempotency keys are scoped
+// to the caller, so callers cannot see each other's jobs through them.
+func (j *jobService) CreateIdempotent(inputJob domain.Job, idempotencyKey string, caller domain.Caller) (*domain.Job, bool, api_error.ApiErr) {
+	j.createMu.Lock()
+	defer j.createMu.Unlock()
+	scopedKey := ""
+	if key := strings.TrimSpace(idempotencyKey); key != "" {
+		scopedKey = scopeIdempotencyKey(caller, key)
+		entry, err := j.idempotencyDao.Get(scopedKey, j.cfg.IdempotencyAge)
+		if err == nil {
+			if !entry.SameRequest(inputJob) {
+				return nil, false, domain.WithCode(domain.CodeIdempotencyKeyConflict, api_error.NewProcessingConflictError("idempotency key was already used for a different job"))
+			}
+			job, err := j.jobDao.Get(entry.JobId)
+			if err == nil && ownsJob(job, caller) {
+				return job, true, nil
+			}
+		}
+	}
+	if err := inputJob.Validate(); err != nil {
+		return nil, false, err
+	}
+	if err := j.policy.Check(inputJob, caller); err != nil {
+		return nil, false, err
+	}
+	if j.cfg.CoalesceJobs {
+		if job := findPendingJob(j.jobDao, inputJob, caller); job != nil {
+			return job, true, nil
+		}
+	}
+	newJob, err := j.Create(inputJob, caller)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
-	return savedJob, nil
+	if scopedKey != "" {
+		entry := domain.IdempotencyKey{
+			Key:       scopedKey,
+			JobId:     newJob.Id,
+			SrcUrl:    newJob.SrcUrl,
+			Type:      newJob.Type,
+			CreatedAt: newJob.CreatedAt,
+		}
+		if err := j.idempotencyDao.Save(entry); err != nil {
+			return nil, false, err
+		}
+	}
+	return newJob, false, nil
+}
+
+// scopeIdempotencyKey makes the keys of different callers independent of each other. The length
+// of the name keeps names and keys containing the separator apart.
+func scopeIdempotencyKey(caller domain.Caller, key string) string {
+	return fmt.Sprintf("%d:%s:%s", len(caller.Name), caller.Name, key)
+}
+
+// findPendingJob returns a job created by the caller for the same source and type that has not
+// finished yet. Jobs of other users are never coalesced, not even for admins.
+func findPendingJob(jobDao domain.JobDao, inputJob domain.Job, caller domain.Caller) *domain.Job {
+	jobs, err := jobDao.GetAll()
+	if err != nil {
+		return nil
+	}
+	for _, job := range *jobs {
+		if (job.Status == domain.JobStatusCreated || job.Status == domain.JobStatusRunning) && job.SrcUrl == inputJob.SrcUrl && job.Type == inputJob.Type && job.CreatedBy == caller.Name {
+			return &job
+		}
+	}
+	return nil
+}
+
+// ownsJob reports whether the caller may see and change the job. Admins may access every job.
+func ownsJob(job *domain.Job, caller domain.Caller) bool {
+	return owns(job.CreatedBy, caller)
+}
+
+// owns reports whether the caller may access what was created by createdBy. Admins may access everything.
+func owns(createdBy string, caller domain.Caller) bool {
+	return createdBy == caller.Name || caller.HasRole(domain.RoleAdmin)
 }
 
-func (j *jobService) Get(jobId string) (*domain.Job, api_error.ApiErr) {
-	job, err := domain.JobDao.Get(jobId)
+// Get returns a job. Jobs of other users are reported as not existing, unless the caller is an admin.
+func (j *jobService) Get(jobId string, caller domain.Caller) (*domain.Job, api_error.ApiErr) {
+	job, err := j.jobDao.Get(jobId)
 	if err != nil {
 		return nil, err
 	}
+	if !ownsJob(job, caller) {
+		return nil, domain.WithCode(domain.CodeJobNotFound, api_error.NewNotFoundError(fmt.Sprintf("job with Id %v does not exist", jobId)))
+	}
 	return job, nil
 }
 
-func (j *jobService) Delete(jobId string) api_error.ApiErr {
-	job, err := domain.JobDao.Get(jobId)
+func (j *jobService) Delete(jobId string, caller domain.Caller) api_error.ApiErr {
+	job, err := j.jobDao.Get(jobId)
 	if err != nil {
 		return err
 	}
+	if !ownsJob(job, caller) {
+		return domain.WithCode(domain.CodeJobNotOwned, api_error.NewUnauthorizedError("Cannot delete job of another user"))
+	}
 	if job.Status == domain.JobStatusRunning {
-		statusErr := api_error.NewProcessingConflictError("Cannot delete job in status running")
+		statusErr := domain.WithCode(domain.CodeJobConflictStatus, api_error.NewProcessingConflictError("Cannot delete job in status running"))
 		return statusErr
 	}
-	deleteErr := domain.JobDao.Delete(jobId)
+	deleteErr := j.jobDao.Delete(jobId)
 	if deleteErr != nil {
 		return deleteErr
 	}
 	return nil
 }
 
-func (j *jobService) Update(jobId string, inputJob domain.Job, partial bool) (*domain.Job, api_error.ApiErr) {
-	job, err := domain.JobDao.Get(jobId)
+// Retry puts a failed or partially failed job back into the queue. A checkpoint saved by the
+// failed run is kept, so processing resumes where it stopped.
+func (j *jobService) Retry(jobId string, caller domain.Caller) (*domain.Job, api_error.ApiErr) {
+	job, err := j.jobDao.Get(jobId)
+	if err != nil {
+		return nil, err
+	}
+	if !ownsJob(job, caller) {
+		return nil, domain.WithCode(domain.CodeJobNotOwned, api_error.NewUnauthorizedError("Cannot retry job of another user"))
+	}
+	if job.Status != domain.JobStatusFailed && job.Status != domain.JobStatusPartial {
+		statusErr := domain.WithCode(domain.CodeJobConflictStatus, api_error.NewProcessingConflictError("Cannot retry job in status other than failed or partial"))
+		return nil, statusErr
+	}
+	if err := checkQueue(j.cfg, j.jobDao, 1); err != nil {
+		return nil, err
+	}
+	// the quota of the job's owner applies, also when an admin retries the job
+	release, err := j.quotaService.Reserve(domain.Caller{Name: job.CreatedBy}, 1)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	if err := j.jobDao.SetErrMsg(jobId, "", ""); err != nil {
+		return nil, err
+	}
+	if err := j.jobDao.ChangeStatus(jobId, domain.JobStatusCreated); err != nil {
+		return nil, err
+	}
+	return j.jobDao.Get(jobId)
+}
+
+func (j *jobService) Update(jobId string, inputJob domain.Job, partial bool, flags domain.JobFlags, caller domain.Caller) (*domain.Job, api_error.ApiErr) {
+	job, err := j.jobDao.Get(jobId)
 	if err != nil {
 		return nil, err
 	}
+	if !ownsJob(job, caller) {
+		return nil, domain.WithCode(domain.CodeJobNotOwned, api_error.NewUnauthorizedError("Cannot modify job of another user"))
+	}
 	if job.Status != domain.JobStatusCreated {
-		statusErr := api_error.NewProcessingConflictError("Cannot modify job in status other than created")
+		statusErr := domain.WithCode(domain.CodeJobConflictStatus, api_error.NewProcessingConflictError("Cannot modify job in status other than created"))
 		return nil, statusErr
 	}
 	if !partial {
@@ -90,13 +287,10 @@ func (j *jobService) Update(jobId string, inputJob domain.Job, partial bool) (*d
 			return nil, err
 		}
 	}
-	request := domain.Job{}
-	request.Id = job.Id
-	request.CreatedAt = job.CreatedAt
-	request.CreatedBy = job.CreatedBy
+	// server-managed fields such as the batch, the file info and a checkpoint of a retried job are kept
+	request := *job
 	request.ModifiedAt = date.GetNowUtcString()
-	request.Status = job.Status
-	request.FileC4Id = job.FileC4Id
+	request.ModifiedBy = caller.Name
 	if partial && strings.TrimSpace(inputJob.Name) == "" {
 		request.Name = job.Name
 	} else {
@@ -117,16 +311,38 @@ func (j *jobService) Update(jobId string, inputJob domain.Job, partial bool) (*d
 	} else {
 		request.Type = inputJob.Type
 	}
+	request.WriteMetadata = updateFlag(partial, flags.WriteMetadata, job.WriteMetadata, inputJob.WriteMetadata)
+	request.WriteTags = updateFlag(partial, flags.WriteTags, job.WriteTags, inputJob.WriteTags)
+	request.TrustMetadata = updateFlag(partial, flags.TrustMetadata, job.TrustMetadata, inputJob.TrustMetadata)
+	if partial && len(inputJob.DigestTypes) == 0 {
+		request.DigestTypes = job.DigestTypes
+	} else {
+		request.DigestTypes = inputJob.DigestTypes
+	}
 
-	savedJob, err := domain.JobDao.Save(request, true)
+	if err := j.policy.Check(request, caller); err != nil {
+		return nil, err
+	}
+	savedJob, err := j.jobDao.Save(request, true)
 	if err != nil {
 		return nil, err
 	}
 	return savedJob, nil
 }
 
+// updateFlag keeps the current value of a flag missing from a partial update
+func updateFlag(partial bool, given *bool, current bool, input bool) bool {
+	if !partial {
+		return input
+	}
+	if given == nil {
+		return current
+	}
+	return *given
+}
+
 func (j *jobService) GetNext() (*domain.Job, api_error.ApiErr) {
-	job, err := domain.JobDao.GetNext()
+	job, err := j.jobDao.GetNext()
 	if err != nil {
 		return nil, err
 	}
@@ -134,7 +350,7 @@ func (j *jobService) GetNext() (*domain.Job, api_error.ApiErr) {
 }
 
 func (j *jobService) ChangeStatus(jobId string, newStatus string) api_error.ApiErr {
-	err := domain.JobDao.ChangeStatus(jobId, newStatus)
+	err := j.jobDao.ChangeStatus(jobId, newStatus)
 	if err != nil {
 		return err
 	}
@@ -142,7 +358,7 @@ func (j *jobService) ChangeStatus(jobId string, newStatus string) api_error.ApiE
 }
 
 func (j *jobService) SetC4Id(jobId string, c4Id string) api_error.ApiErr {
-	err := domain.JobDao.SetC4Id(jobId, c4Id)
+	err := j.jobDao.SetC4Id(jobId, c4Id)
 	if err != nil {
 		return err
 	}
@@ -150,25 +366,94 @@ func (j *jobService) SetC4Id(jobId string, c4Id string) api_error.ApiErr {
 }
 
 func (j *jobService) SetDstUrl(jobId string, dstUrl string) api_error.ApiErr {
-	err := domain.JobDao.SetDstUrl(jobId, dstUrl)
+	err := j.jobDao.SetDstUrl(jobId, dstUrl)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (j *jobService) SetErrMsg(jobId string, errMsg string) api_error.ApiErr {
-	err := domain.JobDao.SetErrMsg(jobId, errMsg)
+func (j *jobService) SetErrMsg(jobId string, errCode string, errMsg string) api_error.ApiErr {
+	err := j.jobDao.SetErrMsg(jobId, errCode, errMsg)
 	if err != nil {
 		return err
 	}
 	return nil
 }
 
-func (j *jobService) GetAll() (*domain.Jobs, api_error.ApiErr) {
-	jobs, err := domain.JobDao.GetAll()
+func (j *jobService) SetFromMetadata(jobId string, fromMetadata bool) api_error.ApiErr {
+	err := j.jobDao.SetFromMetadata(jobId, fromMetadata)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (j *jobService) SetFileInfo(jobId string, fileSize int64, fileModified string) api_error.ApiErr {
+	err := j.jobDao.SetFileInfo(jobId, fileSize, fileModified)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (j *jobService) SetDigests(jobId string, digests map[string]string) api_error.ApiErr {
+	err := j.jobDao.SetDigests(jobId, digests)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (j *jobService) SetFilesTotal(jobId string, filesTotal int) api_error.ApiErr {
+	err := j.jobDao.SetFilesTotal(jobId, filesTotal)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (j *jobService) AddFile(jobId string, file domain.TreeFile) api_error.ApiErr {
+	err := j.jobDao.AddFile(jobId, file)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (j *jobService) SetProgress(jobId string, progress domain.JobProgress) api_error.ApiErr {
+	err := j.jobDao.SetProgress(jobId, progress)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+func (j *jobService) SetCheckpoint(jobId string, checkpoint *domain.Checkpoint) api_error.ApiErr {
+	err := j.jobDao.SetCheckpoint(jobId, checkpoint)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// GetAll returns all jobs for admins and the caller's own jobs for everyone else
+func (j *jobService) GetAll(caller domain.Caller) (*domain.Jobs, api_error.ApiErr) {
+	jobs, err := j.jobDao.GetAll()
 	if err != nil {
 		return nil, err
 	}
-	return jobs, nil
+	if caller.HasRole(domain.RoleAdmin) {
+		return jobs, nil
+	}
+	owned := make(domain.Jobs, 0)
+	for _, job := range *jobs {
+		if ownsJob(&job, caller) {
+			owned = append(owned, job)
+		}
+	}
+	if len(owned) == 0 {
+		return nil, domain.WithCode(domain.CodeJobNotFound, api_error.NewNotFoundError("no jobs in list"))
+	}
+	return &owned, nil
 }
@@ -1,19 +1,25 @@
 package services
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/johannes-kuhfuss/c4svc/config"
 	"github.com/johannes-kuhfuss/c4svc/domain"
 	"github.com/johannes-kuhfuss/services_utils/api_error"
 	"github.com/johannes-kuhfuss/services_utils/date"
 	"github.com/segmentio/ksuid"
 	"github.com/stretchr/testify/assert"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
 )
 
-var (
+type jobsDaoMock struct {
 	getJobFunction       func(jobId string) (*domain.Job, api_error.ApiErr)
 	saveJobFunction      func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr)
 	deleteJobFunction    func(jobId string) api_error.ApiErr
@@ -22,61 +28,111 @@ var (
 	cleanJobsFunction    func(finishedTime time.Duration, failedTime time.Duration) (int, api_error.ApiErr)
 	setC4IdFunction      func(jobId string, c4Id string) api_error.ApiErr
 	setDstUrlFunction    func(jobId string, dstUrl string) api_error.ApiErr
-	setErrMsgFunction    func(jobId string, errMsg string) api_error.ApiErr
+	setErrMsgFunction    func(jobId string, errCode string, errMsg string) api_error.ApiErr
+	setFromMetaFunction  func(jobId string, fromMetadata bool) api_error.ApiErr
+	setFileInfoFunction  func(jobId string, fileSize int64, fileModified string) api_error.ApiErr
+	setDigestsFunction   func(jobId string, digests map[string]string) api_error.ApiErr
+	setFilesTotalFunc    func(jobId string, filesTotal int) api_error.ApiErr
+	addFileFunction      func(jobId string, file domain.TreeFile) api_error.ApiErr
+	setProgressFunction  func(jobId string, progress domain.JobProgress) api_error.ApiErr
+	setCheckpointFunc    func(jobId string, checkpoint *domain.Checkpoint) api_error.ApiErr
 	getAllFunction       func() (*domain.Jobs, api_error.ApiErr)
-)
+	statsFunction        func() domain.QueueStats
+	pingFunction         func() api_error.ApiErr
+}
 
-type jobsDaoMock struct{}
+var (
+	testAdmin  = domain.Caller{Name: "admin", Roles: []string{domain.RoleAdmin}}
+	noopTracer = trace.NewNoopTracerProvider().Tracer("")
+)
 
-func init() {
-	domain.JobDao = &jobsDaoMock{}
+func newTestJobService() (*jobsDaoMock, *jobService) {
+	m := &jobsDaoMock{}
+	return m, NewJobService(config.New(), m, domain.NewIdempotencyDao(), NewQuotaService(config.New(), m), noopTracer).(*jobService)
 }
 
 func (m *jobsDaoMock) Get(jobId string) (*domain.Job, api_error.ApiErr) {
-	return getJobFunction(jobId)
+	return m.getJobFunction(jobId)
 }
 
 func (m *jobsDaoMock) Save(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
-	return saveJobFunction(newJob, overwrite)
+	return m.saveJobFunction(newJob, overwrite)
 }
 
 func (m *jobsDaoMock) Delete(jobId string) api_error.ApiErr {
-	return deleteJobFunction(jobId)
+	return m.deleteJobFunction(jobId)
 }
 
 func (m *jobsDaoMock) GetNext() (*domain.Job, api_error.ApiErr) {
-	return getNextJobFunction()
+	return m.getNextJobFunction()
 }
 
 func (m *jobsDaoMock) ChangeStatus(jobId string, newStatus string) api_error.ApiErr {
-	return changeStatusFunction(jobId, newStatus)
+	return m.changeStatusFunction(jobId, newStatus)
 }
 
 func (m *jobsDaoMock) CleanJobs(finishedTime time.Duration, failedTime time.Duration) (int, api_error.ApiErr) {
-	return cleanJobsFunction(finishedTime, failedTime)
+	return m.cleanJobsFunction(finishedTime, failedTime)
 }
 
 func (m *jobsDaoMock) SetC4Id(jobId string, c4Id string) api_error.ApiErr {
-	return setC4IdFunction(jobId, c4Id)
+	return m.setC4IdFunction(jobId, c4Id)
 }
 
 func (m *jobsDaoMock) SetDstUrl(jobId string, dstUrl string) api_error.ApiErr {
-	return setDstUrlFunction(jobId, dstUrl)
+	return m.setDstUrlFunction(jobId, dstUrl)
+}
+
+func (m *jobsDaoMock) SetErrMsg(jobId string, errCode string, errMsg string) api_error.ApiErr {
+	return m.setErrMsgFunction(jobId, errCode, errMsg)
+}
+
+func (m *jobsDaoMock) SetFromMetadata(jobId string, fromMetadata bool) api_error.ApiErr {
+	return m.setFromMetaFunction(jobId, fromMetadata)
+}
+
+func (m *jobsDaoMock) SetFileInfo(jobId string, fileSize int64, fileModified string) api_error.ApiErr {
+	return m.setFileInfoFunction(jobId, fileSize, fileModified)
+}
+
+func (m *jobsDaoMock) SetDigests(jobId string, digests map[string]string) api_error.ApiErr {
+	return m.setDigestsFunction(jobId, digests)
+}
+
+func (m *jobsDaoMock) SetFilesTotal(jobId string, filesTotal int) api_error.ApiErr {
+	return m.setFilesTotalFunc(jobId, filesTotal)
+}
+
+func (m *jobsDaoMock) AddFile(jobId string, file domain.TreeFile) api_error.ApiErr {
+	return m.addFileFunction(jobId, file)
+}
+
+func (m *jobsDaoMock) SetProgress(jobId string, progress domain.JobProgress) api_error.ApiErr {
+	return m.setProgressFunction(jobId, progress)
 }
 
-func (m *jobsDaoMock) SetErrMsg(jobId string, errMsg string) api_error.ApiErr {
-	return setErrMsgFunction(jobId, errMsg)
+func (m *jobsDaoMock) SetCheckpoint(jobId string, checkpoint *domain.Checkpoint) api_error.ApiErr {
+	return m.setCheckpointFunc(jobId, checkpoint)
 }
 
 func (m *jobsDaoMock) GetAll() (*domain.Jobs, api_error.ApiErr) {
-	return getAllFunction()
+	return m.getAllFunction()
+}
+
+func (m *jobsDaoMock) Stats() domain.QueueStats {
+	return m.statsFunction()
+}
+
+func (m *jobsDaoMock) Ping() api_error.ApiErr {
+	return m.pingFunction()
 }
 
 func TestGetJobNotFound(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return nil, api_error.NewNotFoundError("job with Id X does not exist")
 	}
-	user, err := JobService.Get("X")
+	user, err := js.Get("X", testAdmin)
 	assert.Nil(t, user)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
@@ -84,7 +140,8 @@ func TestGetJobNotFound(t *testing.T) {
 }
 
 func TestGetJobNoError(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return &domain.Job{
 			Id:         jobId,
 			Name:       "Job 1",
@@ -100,17 +157,18 @@ func TestGetJobNoError(t *testing.T) {
 		}, nil
 	}
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbbO"
-	user, err := JobService.Get(id)
+	user, err := js.Get(id, testAdmin)
 	assert.NotNil(t, user)
 	assert.Nil(t, err)
 	assert.EqualValues(t, user.Id, id)
 }
 
 func TestCreateJobInvalidJobType(t *testing.T) {
+	_, js := newTestJobService()
 	newJob := domain.Job{
 		Type: "invalid_Type",
 	}
-	createJob, err := JobService.Create(newJob)
+	createJob, err := js.Create(newJob, domain.Caller{})
 	assert.Nil(t, createJob)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
@@ -118,11 +176,12 @@ func TestCreateJobInvalidJobType(t *testing.T) {
 }
 
 func TestCreateJobInvalidSrcUrl(t *testing.T) {
+	_, js := newTestJobService()
 	newJob := domain.Job{
 		Type:   "Create",
 		SrcUrl: "",
 	}
-	createJob, err := JobService.Create(newJob)
+	createJob, err := js.Create(newJob, domain.Caller{})
 	assert.Nil(t, createJob)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
@@ -130,20 +189,25 @@ func TestCreateJobInvalidSrcUrl(t *testing.T) {
 }
 
 func TestCreateJobNameGivenNoDstUrlNoError(t *testing.T) {
-	saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
 		return &newJob, nil
 	}
 	newJob := domain.Job{
-		Type:   "Create",
-		SrcUrl: "http://server/path/file.ext",
-		Name:   "myJob",
+		Type:      "Create",
+		SrcUrl:    "http://server/path/file.ext",
+		Name:      "myJob",
+		CreatedBy: "someone else",
+		RequestId: "req-1",
 	}
-	createJob, err := JobService.Create(newJob)
+	createJob, err := js.Create(newJob, domain.Caller{Name: "user A"})
 	assert.NotNil(t, createJob)
 	assert.Nil(t, err)
 	_, parseErr := ksuid.Parse(createJob.Id)
 	assert.True(t, parseErr == nil)
 	assert.EqualValues(t, "myJob", createJob.Name)
+	assert.EqualValues(t, "user A", createJob.CreatedBy)
+	assert.EqualValues(t, "req-1", createJob.RequestId)
 	_, parseErr = time.Parse(date.ApiDateLayout, createJob.CreatedAt)
 	assert.True(t, parseErr == nil)
 	assert.EqualValues(t, newJob.SrcUrl, createJob.SrcUrl)
@@ -151,8 +215,32 @@ func TestCreateJobNameGivenNoDstUrlNoError(t *testing.T) {
 	assert.EqualValues(t, "", createJob.DstUrl)
 }
 
+func TestCreateJobContinuesTraceOfRequest(t *testing.T) {
+	m, js := newTestJobService()
+	recorder := tracetest.NewSpanRecorder()
+	js.tracer = sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)).Tracer("")
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+		return &newJob, nil
+	}
+	newJob := domain.Job{
+		Type:         "Create",
+		SrcUrl:       "http://server/path/file.ext",
+		TraceContext: map[string]string{"traceparent": "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"},
+	}
+	createJob, err := js.Create(newJob, domain.Caller{Name: "user A"})
+	assert.Nil(t, err)
+	spans := recorder.Ended()
+	assert.EqualValues(t, 1, len(spans))
+	assert.EqualValues(t, "job.enqueue", spans[0].Name())
+	assert.EqualValues(t, "4bf92f3577b34da6a3ce929d0e0e4736", spans[0].SpanContext().TraceID().String())
+	assert.EqualValues(t, "00f067aa0ba902b7", spans[0].Parent().SpanID().String())
+	stored := trace.SpanContextFromContext(createJob.SpanContext(context.Background()))
+	assert.EqualValues(t, spans[0].SpanContext().SpanID(), stored.SpanID())
+}
+
 func TestCreateJobNoNameGivenWithDstUrlNoError(t *testing.T) {
-	saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
 		return &newJob, nil
 	}
 	newJob := domain.Job{
@@ -160,7 +248,7 @@ func TestCreateJobNoNameGivenWithDstUrlNoError(t *testing.T) {
 		SrcUrl: "http://server/path/file.ext",
 		DstUrl: "http://server2/path2/file.ext",
 	}
-	createJob, err := JobService.Create(newJob)
+	createJob, err := js.Create(newJob, domain.Caller{})
 	assert.NotNil(t, createJob)
 	assert.Nil(t, err)
 	assert.Contains(t, createJob.Name, "Job @ ")
@@ -169,7 +257,8 @@ func TestCreateJobNoNameGivenWithDstUrlNoError(t *testing.T) {
 }
 
 func TestCreateJobSaveError(t *testing.T) {
-	saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
 		return nil, api_error.NewBadRequestError("could not save job")
 	}
 	newJob := domain.Job{
@@ -177,28 +266,66 @@ func TestCreateJobSaveError(t *testing.T) {
 		SrcUrl: "http://server/path/file.ext",
 		Name:   "myJob",
 	}
-	createJob, err := JobService.Create(newJob)
+	createJob, err := js.Create(newJob, domain.Caller{})
 	assert.Nil(t, createJob)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
 	assert.EqualValues(t, "could not save job", err.Message())
 }
 
+func TestCreateJobPolicyDenied(t *testing.T) {
+	cfg := config.New()
+	cfg.PolicyContainers = "media"
+	m := &jobsDaoMock{}
+	js := NewJobService(cfg, m, domain.NewIdempotencyDao(), NewQuotaService(cfg, m), noopTracer)
+	newJob := domain.Job{
+		Type:   "Create",
+		SrcUrl: "https://account.blob.core.windows.net/private/file.ext",
+	}
+	createJob, err := js.Create(newJob, domain.Caller{})
+	assert.Nil(t, createJob)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusForbidden, err.StatusCode())
+	assert.EqualValues(t, "container private is not allowed", err.Message())
+}
+
+func TestCreateJobQueueFull(t *testing.T) {
+	cfg := config.New()
+	cfg.QueueMaxJobs = 2
+	m := &jobsDaoMock{}
+	m.statsFunction = func() domain.QueueStats {
+		return domain.QueueStats{Queued: 2}
+	}
+	js := NewJobService(cfg, m, domain.NewIdempotencyDao(), NewQuotaService(cfg, m), noopTracer)
+	newJob := domain.Job{
+		Type:   "Create",
+		SrcUrl: "https://server/path/file.ext",
+	}
+	createJob, err := js.Create(newJob, domain.Caller{})
+	assert.Nil(t, createJob)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusServiceUnavailable, err.StatusCode())
+	assert.EqualValues(t, "queue is full, 2 of 2 jobs queued", err.Message())
+	assert.EqualValues(t, cfg.QueueRetryAfter, err.(RetryAfterErr).RetryAfter())
+}
+
 func TestDeleteJobNotFound(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return nil, api_error.NewNotFoundError("job with Id 1zXgBZNnBG1msmF1ARQK9ZphbbO does not exist")
 	}
-	deleteJobFunction = func(jobId string) api_error.ApiErr {
+	m.deleteJobFunction = func(jobId string) api_error.ApiErr {
 		return api_error.NewNotFoundError(fmt.Sprintf("job with Id %v does not exist", jobId))
 	}
-	deleteErr := JobService.Delete("1zXgBZNnBG1msmF1ARQK9ZphbbO")
+	deleteErr := js.Delete("1zXgBZNnBG1msmF1ARQK9ZphbbO", domain.Caller{Name: "user A"})
 	assert.NotNil(t, deleteErr)
 	assert.EqualValues(t, http.StatusNotFound, deleteErr.StatusCode())
 	assert.EqualValues(t, "job with Id 1zXgBZNnBG1msmF1ARQK9ZphbbO does not exist", deleteErr.Message())
 }
 
 func TestDeleteJobStatusError(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return &domain.Job{
 			Id:         jobId,
 			Name:       "Job 1",
@@ -213,17 +340,18 @@ func TestDeleteJobStatusError(t *testing.T) {
 			FileC4Id:   "abcdefg",
 		}, nil
 	}
-	deleteJobFunction = func(jobId string) api_error.ApiErr {
+	m.deleteJobFunction = func(jobId string) api_error.ApiErr {
 		return nil
 	}
-	deleteErr := JobService.Delete("1zXgBZNnBG1msmF1ARQK9ZphbbO")
+	deleteErr := js.Delete("1zXgBZNnBG1msmF1ARQK9ZphbbO", domain.Caller{Name: "user A"})
 	assert.NotNil(t, deleteErr)
 	assert.EqualValues(t, http.StatusConflict, deleteErr.StatusCode())
 	assert.EqualValues(t, "Cannot delete job in status running", deleteErr.Message())
 }
 
 func TestDeleteDeleteError(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return &domain.Job{
 			Id:         jobId,
 			Name:       "Job 1",
@@ -238,17 +366,18 @@ func TestDeleteDeleteError(t *testing.T) {
 			FileC4Id:   "abcdefg",
 		}, nil
 	}
-	deleteJobFunction = func(jobId string) api_error.ApiErr {
+	m.deleteJobFunction = func(jobId string) api_error.ApiErr {
 		return api_error.NewInternalServerError("could not delete job", nil)
 	}
-	deleteErr := JobService.Delete("1zXgBZNnBG1msmF1ARQK9ZphbbO")
+	deleteErr := js.Delete("1zXgBZNnBG1msmF1ARQK9ZphbbO", domain.Caller{Name: "user A"})
 	assert.NotNil(t, deleteErr)
 	assert.EqualValues(t, http.StatusInternalServerError, deleteErr.StatusCode())
 	assert.EqualValues(t, "could not delete job", deleteErr.Message())
 }
 
 func TestDeleteJobNoError(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return &domain.Job{
 			Id:         jobId,
 			Name:       "Job 1",
@@ -263,19 +392,36 @@ func TestDeleteJobNoError(t *testing.T) {
 			FileC4Id:   "abcdefg",
 		}, nil
 	}
-	deleteJobFunction = func(jobId string) api_error.ApiErr {
+	m.deleteJobFunction = func(jobId string) api_error.ApiErr {
+		return nil
+	}
+	deleteErr := js.Delete("1zXgBZNnBG1msmF1ARQK9ZphbbO", domain.Caller{Name: "user A"})
+	assert.Nil(t, deleteErr)
+}
+
+func TestDeleteJobOfOtherUser(t *testing.T) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		return &domain.Job{Id: jobId, CreatedBy: "user A", Status: "Created"}, nil
+	}
+	m.deleteJobFunction = func(jobId string) api_error.ApiErr {
 		return nil
 	}
-	deleteErr := JobService.Delete("1zXgBZNnBG1msmF1ARQK9ZphbbO")
+	deleteErr := js.Delete("1zXgBZNnBG1msmF1ARQK9ZphbbO", domain.Caller{Name: "user B", Roles: []string{domain.RoleSubmitter}})
+	assert.NotNil(t, deleteErr)
+	assert.EqualValues(t, http.StatusForbidden, deleteErr.StatusCode())
+	assert.EqualValues(t, "Cannot delete job of another user", deleteErr.Message())
+	deleteErr = js.Delete("1zXgBZNnBG1msmF1ARQK9ZphbbO", domain.Caller{Name: "user C", Roles: []string{domain.RoleAdmin}})
 	assert.Nil(t, deleteErr)
 }
 
 func TestUpdateJobNotFound(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return nil, api_error.NewNotFoundError("job not found")
 	}
 	inputJob := domain.Job{}
-	updateJob, err := JobService.Update("", inputJob, false)
+	updateJob, err := js.Update("", inputJob, false, domain.JobFlags{}, testAdmin)
 	assert.Nil(t, updateJob)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
@@ -283,7 +429,8 @@ func TestUpdateJobNotFound(t *testing.T) {
 }
 
 func TestUpdateJobValidateFailure(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return &domain.Job{
 			Id:         jobId,
 			Name:       "Job 1",
@@ -311,7 +458,7 @@ func TestUpdateJobValidateFailure(t *testing.T) {
 		FileC4Id:   "xyz",
 	}
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbbO"
-	updateJob, err := JobService.Update(id, inputJob, false)
+	updateJob, err := js.Update(id, inputJob, false, domain.JobFlags{}, testAdmin)
 	assert.Nil(t, updateJob)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
@@ -319,7 +466,8 @@ func TestUpdateJobValidateFailure(t *testing.T) {
 }
 
 func TestUpdateJobStatusError(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return &domain.Job{
 			Id:         jobId,
 			Name:       "Job 1",
@@ -347,7 +495,7 @@ func TestUpdateJobStatusError(t *testing.T) {
 		FileC4Id:   "xyz",
 	}
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbbO"
-	updateJob, err := JobService.Update(id, inputJob, false)
+	updateJob, err := js.Update(id, inputJob, false, domain.JobFlags{}, testAdmin)
 	assert.Nil(t, updateJob)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusConflict, err.StatusCode())
@@ -355,7 +503,8 @@ func TestUpdateJobStatusError(t *testing.T) {
 }
 
 func TestUpdateJobFullUpdate(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return &domain.Job{
 			Id:         jobId,
 			Name:       "Job 1",
@@ -382,11 +531,11 @@ func TestUpdateJobFullUpdate(t *testing.T) {
 		Status:     "Running",
 		FileC4Id:   "xyz",
 	}
-	saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
 		return &newJob, nil
 	}
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbbO"
-	updateJob, err := JobService.Update(id, inputJob, false)
+	updateJob, err := js.Update(id, inputJob, false, domain.JobFlags{}, domain.Caller{Name: "user A", Roles: []string{domain.RoleSubmitter}})
 	assert.NotNil(t, updateJob)
 	assert.Nil(t, err)
 	assert.EqualValues(t, id, updateJob.Id)
@@ -394,6 +543,7 @@ func TestUpdateJobFullUpdate(t *testing.T) {
 	assert.EqualValues(t, "2021-10-15T15:00:00Z", updateJob.CreatedAt)
 	assert.EqualValues(t, "user A", updateJob.CreatedBy)
 	assert.NotEqualValues(t, "", updateJob.ModifiedAt)
+	assert.EqualValues(t, "user A", updateJob.ModifiedBy)
 	assert.EqualValues(t, "http://server3/path3/file3.ext", updateJob.SrcUrl)
 	assert.EqualValues(t, "http://server2/path2/file2.ext", updateJob.DstUrl)
 	assert.EqualValues(t, "CreateAndRename", updateJob.Type)
@@ -402,7 +552,8 @@ func TestUpdateJobFullUpdate(t *testing.T) {
 }
 
 func TestUpdateJobPartialUpdate(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return &domain.Job{
 			Id:         jobId,
 			Name:       "Job 1",
@@ -421,11 +572,11 @@ func TestUpdateJobPartialUpdate(t *testing.T) {
 		Name:   "",
 		DstUrl: "",
 	}
-	saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
 		return &newJob, nil
 	}
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbbO"
-	updateJob, err := JobService.Update(id, inputJob, true)
+	updateJob, err := js.Update(id, inputJob, true, domain.JobFlags{}, testAdmin)
 	assert.NotNil(t, updateJob)
 	assert.Nil(t, err)
 	assert.EqualValues(t, id, updateJob.Id)
@@ -441,7 +592,8 @@ func TestUpdateJobPartialUpdate(t *testing.T) {
 }
 
 func TestUpdateJobSaveError(t *testing.T) {
-	getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
 		return &domain.Job{
 			Id:         jobId,
 			Name:       "Job 1",
@@ -460,11 +612,11 @@ func TestUpdateJobSaveError(t *testing.T) {
 		Name:   "",
 		DstUrl: "",
 	}
-	saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
 		return nil, api_error.NewNotFoundError("could not save job")
 	}
 	id := "1zXgBZNnBG1msmF1ARQK9ZphbbO"
-	updateJob, err := JobService.Update(id, inputJob, true)
+	updateJob, err := js.Update(id, inputJob, true, domain.JobFlags{}, testAdmin)
 	assert.Nil(t, updateJob)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
@@ -472,10 +624,11 @@ func TestUpdateJobSaveError(t *testing.T) {
 }
 
 func TestGetNextNoJob(t *testing.T) {
-	getNextJobFunction = func() (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getNextJobFunction = func() (*domain.Job, api_error.ApiErr) {
 		return nil, api_error.NewNotFoundError("no jobs in list")
 	}
-	nextJob, err := JobService.GetNext()
+	nextJob, err := js.GetNext()
 	assert.Nil(t, nextJob)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
@@ -483,7 +636,8 @@ func TestGetNextNoJob(t *testing.T) {
 }
 
 func TestGetNextNoError(t *testing.T) {
-	getNextJobFunction = func() (*domain.Job, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getNextJobFunction = func() (*domain.Job, api_error.ApiErr) {
 		return &domain.Job{
 			Id:         "1zXgBZNnBG1msmF1ARQK9ZphbbO",
 			Name:       "Job 1",
@@ -498,7 +652,7 @@ func TestGetNextNoError(t *testing.T) {
 			FileC4Id:   "abcdefg",
 		}, nil
 	}
-	nextJob, err := JobService.GetNext()
+	nextJob, err := js.GetNext()
 	assert.NotNil(t, nextJob)
 	assert.Nil(t, err)
 	assert.EqualValues(t, "1zXgBZNnBG1msmF1ARQK9ZphbbO", nextJob.Id)
@@ -506,81 +660,130 @@ func TestGetNextNoError(t *testing.T) {
 }
 
 func TestChangeStatusError(t *testing.T) {
-	changeStatusFunction = func(jobId string, newStatus string) api_error.ApiErr {
+	m, js := newTestJobService()
+	m.changeStatusFunction = func(jobId string, newStatus string) api_error.ApiErr {
 		return api_error.NewBadRequestError("invalid status value")
 	}
-	err := JobService.ChangeStatus("id", "invalid status")
+	err := js.ChangeStatus("id", "invalid status")
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
 	assert.EqualValues(t, "invalid status value", err.Message())
 }
 
 func TestChangeStatusNoError(t *testing.T) {
-	changeStatusFunction = func(jobId string, newStatus string) api_error.ApiErr {
+	m, js := newTestJobService()
+	m.changeStatusFunction = func(jobId string, newStatus string) api_error.ApiErr {
 		return nil
 	}
-	err := JobService.ChangeStatus("id", "valid status")
+	err := js.ChangeStatus("id", "valid status")
 	assert.Nil(t, err)
 }
 func TestSetC4IdError(t *testing.T) {
-	setC4IdFunction = func(jobId string, c4Id string) api_error.ApiErr {
+	m, js := newTestJobService()
+	m.setC4IdFunction = func(jobId string, c4Id string) api_error.ApiErr {
 		return api_error.NewBadRequestError("could not set C4 Id")
 	}
-	err := JobService.SetC4Id("id", "invalid Id")
+	err := js.SetC4Id("id", "invalid Id")
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
 	assert.EqualValues(t, "could not set C4 Id", err.Message())
 }
 
 func TestSetC4IdNoError(t *testing.T) {
-	setC4IdFunction = func(jobId string, c4Id string) api_error.ApiErr {
+	m, js := newTestJobService()
+	m.setC4IdFunction = func(jobId string, c4Id string) api_error.ApiErr {
 		return nil
 	}
-	err := JobService.SetC4Id("id", "valid status")
+	err := js.SetC4Id("id", "valid status")
 	assert.Nil(t, err)
 }
 
 func TestSetDstUrlIdError(t *testing.T) {
-	setDstUrlFunction = func(jobId string, dstUrl string) api_error.ApiErr {
+	m, js := newTestJobService()
+	m.setDstUrlFunction = func(jobId string, dstUrl string) api_error.ApiErr {
 		return api_error.NewBadRequestError("could not set destination URL")
 	}
-	err := JobService.SetDstUrl("id", "new Url")
+	err := js.SetDstUrl("id", "new Url")
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
 	assert.EqualValues(t, "could not set destination URL", err.Message())
 }
 
 func TestSetDstUrlNoError(t *testing.T) {
-	setDstUrlFunction = func(jobId string, dstUrl string) api_error.ApiErr {
+	m, js := newTestJobService()
+	m.setDstUrlFunction = func(jobId string, dstUrl string) api_error.ApiErr {
 		return nil
 	}
-	err := JobService.SetDstUrl("id", "new Url")
+	err := js.SetDstUrl("id", "new Url")
 	assert.Nil(t, err)
 }
 
 func TestSetErrMsgIdError(t *testing.T) {
-	setErrMsgFunction = func(jobId string, errMsg string) api_error.ApiErr {
+	m, js := newTestJobService()
+	m.setErrMsgFunction = func(jobId string, errCode string, errMsg string) api_error.ApiErr {
 		return api_error.NewBadRequestError("could not set error message")
 	}
-	err := JobService.SetErrMsg("id", "new error message")
+	err := js.SetErrMsg("id", domain.CodeProcessingFailed, "new error message")
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
 	assert.EqualValues(t, "could not set error message", err.Message())
 }
 
 func TestSetErrMsgNoError(t *testing.T) {
-	setErrMsgFunction = func(jobId string, errMsg string) api_error.ApiErr {
+	m, js := newTestJobService()
+	m.setErrMsgFunction = func(jobId string, errCode string, errMsg string) api_error.ApiErr {
+		return nil
+	}
+	err := js.SetErrMsg("id", domain.CodeProcessingFailed, "new error message")
+	assert.Nil(t, err)
+}
+
+func TestSetFromMetadataError(t *testing.T) {
+	m, js := newTestJobService()
+	m.setFromMetaFunction = func(jobId string, fromMetadata bool) api_error.ApiErr {
+		return api_error.NewNotFoundError("job with Id id does not exist")
+	}
+	err := js.SetFromMetadata("id", true)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+	assert.EqualValues(t, "job with Id id does not exist", err.Message())
+}
+
+func TestSetFromMetadataNoError(t *testing.T) {
+	m, js := newTestJobService()
+	m.setFromMetaFunction = func(jobId string, fromMetadata bool) api_error.ApiErr {
+		return nil
+	}
+	err := js.SetFromMetadata("id", true)
+	assert.Nil(t, err)
+}
+
+func TestSetFilesTotalNoError(t *testing.T) {
+	m, js := newTestJobService()
+	m.setFilesTotalFunc = func(jobId string, filesTotal int) api_error.ApiErr {
 		return nil
 	}
-	err := JobService.SetErrMsg("id", "new error message")
+	err := js.SetFilesTotal("id", 5)
 	assert.Nil(t, err)
 }
 
+func TestAddFileError(t *testing.T) {
+	m, js := newTestJobService()
+	m.addFileFunction = func(jobId string, file domain.TreeFile) api_error.ApiErr {
+		return api_error.NewNotFoundError("job with Id id does not exist")
+	}
+	err := js.AddFile("id", domain.TreeFile{Path: "file.ext"})
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+	assert.EqualValues(t, "job with Id id does not exist", err.Message())
+}
+
 func TestGetAllNoJobsError(t *testing.T) {
-	getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
 		return nil, api_error.NewNotFoundError("no jobs in list")
 	}
-	jobs, err := JobService.GetAll()
+	jobs, err := js.GetAll(testAdmin)
 	assert.Nil(t, jobs)
 	assert.NotNil(t, err)
 	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
@@ -588,7 +791,8 @@ func TestGetAllNoJobsError(t *testing.T) {
 }
 
 func TestGetAllNoError(t *testing.T) {
-	getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+	m, js := newTestJobService()
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
 		newJob := domain.Job{
 			Id:         "1zXgBZNnBG1msmF1ARQK9ZphbbO",
 			Name:       "Job 1",
@@ -606,8 +810,294 @@ func TestGetAllNoError(t *testing.T) {
 		jobList = append(jobList, newJob)
 		return &jobList, nil
 	}
-	jobs, err := JobService.GetAll()
+	jobs, err := js.GetAll(testAdmin)
 	assert.NotNil(t, jobs)
 	assert.Nil(t, err)
 	assert.EqualValues(t, 1, len(*jobs))
 }
+
+func TestCreateIdempotentReturnsOriginalJob(t *testing.T) {
+	m, js := newTestJobService()
+	var savedJob domain.Job
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+		savedJob = newJob
+		return &newJob, nil
+	}
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		if jobId == savedJob.Id {
+			return &savedJob, nil
+		}
+		return nil, api_error.NewNotFoundError("job not found")
+	}
+	newJob := domain.Job{
+		Type:   "Create",
+		SrcUrl: "http://server/path/idempotent.ext",
+	}
+	createJob, existing, err := js.CreateIdempotent(newJob, "retry-key-1", domain.Caller{})
+	assert.Nil(t, err)
+	assert.False(t, existing)
+	repeatJob, existing, err := js.CreateIdempotent(newJob, "retry-key-1", domain.Caller{})
+	assert.Nil(t, err)
+	assert.True(t, existing)
+	assert.EqualValues(t, createJob.Id, repeatJob.Id)
+}
+
+func TestCreateIdempotentKeyReusedForOtherJob(t *testing.T) {
+	m, js := newTestJobService()
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+		return &newJob, nil
+	}
+	newJob := domain.Job{
+		Type:   "Create",
+		SrcUrl: "http://server/path/idempotent.ext",
+	}
+	_, _, err := js.CreateIdempotent(newJob, "retry-key-2", domain.Caller{})
+	assert.Nil(t, err)
+	newJob.SrcUrl = "http://server/path/other.ext"
+	createJob, existing, err := js.CreateIdempotent(newJob, "retry-key-2", domain.Caller{})
+	assert.Nil(t, createJob)
+	assert.False(t, existing)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusConflict, err.StatusCode())
+	assert.EqualValues(t, "idempotency key was already used for a different job", err.Message())
+}
+
+func TestCreateIdempotentCoalescesPendingJob(t *testing.T) {
+	m, js := newTestJobService()
+	js.cfg.CoalesceJobs = true
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+		jobList := domain.Jobs{
+			{
+				Id:     "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+				SrcUrl: "http://server/path/file.ext",
+				Type:   "Create",
+				Status: "Running",
+			},
+		}
+		return &jobList, nil
+	}
+	newJob := domain.Job{
+		Type:   "Create",
+		SrcUrl: "http://server/path/file.ext",
+	}
+	createJob, existing, err := js.CreateIdempotent(newJob, "", domain.Caller{})
+	assert.Nil(t, err)
+	assert.True(t, existing)
+	assert.EqualValues(t, "1zXgBZNnBG1msmF1ARQK9ZphbbO", createJob.Id)
+}
+
+func TestCreateIdempotentKeyOfOtherUser(t *testing.T) {
+	m, js := newTestJobService()
+	savedJobs := make(map[string]domain.Job)
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+		savedJobs[newJob.Id] = newJob
+		return &newJob, nil
+	}
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		if job, ok := savedJobs[jobId]; ok {
+			return &job, nil
+		}
+		return nil, api_error.NewNotFoundError("job not found")
+	}
+	newJob := domain.Job{
+		Type:   "Create",
+		SrcUrl: "http://server/path/idempotent.ext",
+	}
+	userJob, _, err := js.CreateIdempotent(newJob, "shared-key", domain.Caller{Name: "user A"})
+	assert.Nil(t, err)
+	otherJob, existing, err := js.CreateIdempotent(newJob, "shared-key", domain.Caller{Name: "user B"})
+	assert.Nil(t, err)
+	assert.False(t, existing)
+	assert.NotEqualValues(t, userJob.Id, otherJob.Id)
+	assert.EqualValues(t, "user B", otherJob.CreatedBy)
+}
+
+func TestCreateIdempotentDoesNotCoalesceJobOfOtherUser(t *testing.T) {
+	m, js := newTestJobService()
+	js.cfg.CoalesceJobs = true
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+		jobList := domain.Jobs{
+			{
+				Id:        "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+				SrcUrl:    "http://server/path/file.ext",
+				Type:      "Create",
+				Status:    "Running",
+				CreatedBy: "user A",
+			},
+		}
+		return &jobList, nil
+	}
+	m.saveJobFunction = func(newJob domain.Job, overwrite bool) (*domain.Job, api_error.ApiErr) {
+		return &newJob, nil
+	}
+	newJob := domain.Job{
+		Type:   "Create",
+		SrcUrl: "http://server/path/file.ext",
+	}
+	createJob, existing, err := js.CreateIdempotent(newJob, "", testAdmin)
+	assert.Nil(t, err)
+	assert.False(t, existing)
+	assert.NotEqualValues(t, "1zXgBZNnBG1msmF1ARQK9ZphbbO", createJob.Id)
+}
+
+func TestSetProgressNoError(t *testing.T) {
+	m, js := newTestJobService()
+	var setProgress domain.JobProgress
+	m.setProgressFunction = func(jobId string, progress domain.JobProgress) api_error.ApiErr {
+		setProgress = progress
+		return nil
+	}
+	err := js.SetProgress("id", domain.JobProgress{BytesTotal: 100, BytesProcessed: 50})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 50, setProgress.BytesProcessed)
+}
+
+func TestRetryNotFailed(t *testing.T) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		return &domain.Job{Id: jobId, Status: domain.JobStatusRunning}, nil
+	}
+	job, err := js.Retry("id", testAdmin)
+	assert.Nil(t, job)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusConflict, err.StatusCode())
+	assert.EqualValues(t, "Cannot retry job in status other than failed or partial", err.Message())
+}
+
+func TestRetryKeepsCheckpoint(t *testing.T) {
+	m, js := newTestJobService()
+	failedJob := domain.Job{Id: "id", Status: domain.JobStatusFailed, ErrorMsg: "Could not process file", Checkpoint: &domain.Checkpoint{Offset: 100}}
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		job := failedJob
+		return &job, nil
+	}
+	m.setErrMsgFunction = func(jobId string, errCode string, errMsg string) api_error.ApiErr {
+		failedJob.ErrorMsg = errMsg
+		return nil
+	}
+	m.changeStatusFunction = func(jobId string, newStatus string) api_error.ApiErr {
+		failedJob.Status = domain.JobStatus(newStatus)
+		return nil
+	}
+	job, err := js.Retry("id", testAdmin)
+	assert.Nil(t, err)
+	assert.NotNil(t, job)
+	assert.EqualValues(t, domain.JobStatusCreated, job.Status)
+	assert.EqualValues(t, "", job.ErrorMsg)
+	assert.EqualValues(t, 100, job.Checkpoint.Offset)
+}
+
+func TestRetryQueueFull(t *testing.T) {
+	m, js := newTestJobService()
+	js.cfg.QueueMaxJobs = 1
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		return &domain.Job{Id: jobId, Status: domain.JobStatusFailed}, nil
+	}
+	m.statsFunction = func() domain.QueueStats {
+		return domain.QueueStats{Queued: 1}
+	}
+	job, err := js.Retry("id", testAdmin)
+	assert.Nil(t, job)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusServiceUnavailable, err.StatusCode())
+	assert.EqualValues(t, domain.CodeQueueFull, domain.ErrorCode(err))
+}
+
+func TestRetryQuotaOfOwner(t *testing.T) {
+	m, js := newTestJobService()
+	js.cfg.QuotaMaxQueued = 1
+	js.quotaService = NewQuotaService(js.cfg, m)
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		return &domain.Job{Id: jobId, CreatedBy: "user A", Status: domain.JobStatusFailed}, nil
+	}
+	m.statsFunction = func() domain.QueueStats {
+		return domain.QueueStats{}
+	}
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+		return &domain.Jobs{{CreatedBy: "user A", Status: domain.JobStatusCreated}}, nil
+	}
+	job, err := js.Retry("X", testAdmin)
+	assert.Nil(t, job)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusTooManyRequests, err.StatusCode())
+	assert.EqualValues(t, domain.CodeQuotaExceeded, domain.ErrorCode(err))
+}
+
+func TestConcurrentCreatesKeepQueuedQuota(t *testing.T) {
+	cfg := config.New()
+	cfg.QuotaMaxQueued = 5
+	jobDao := domain.NewJobDao()
+	quotaService := NewQuotaService(cfg, jobDao)
+	js := NewJobService(cfg, jobDao, domain.NewIdempotencyDao(), quotaService, noopTracer)
+	bs := NewBatchService(cfg, jobDao, domain.NewBatchDao(), quotaService, noopTracer)
+	caller := domain.Caller{Name: "user A", Roles: []string{domain.RoleSubmitter}}
+	inputJob := domain.Job{Type: domain.JobTypeCreate, SrcUrl: "https://server/media/file1.ext"}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			js.Create(inputJob, caller)
+		}()
+		go func() {
+			defer wg.Done()
+			bs.Create([]domain.Job{inputJob, inputJob}, caller)
+		}()
+	}
+	wg.Wait()
+	assert.True(t, jobDao.Stats().Queued <= 5)
+}
+
+func TestGetJobOfOtherUser(t *testing.T) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		return &domain.Job{Id: jobId, CreatedBy: "user A"}, nil
+	}
+	job, err := js.Get("X", domain.Caller{Name: "user A", Roles: []string{domain.RoleViewer}})
+	assert.NotNil(t, job)
+	assert.Nil(t, err)
+	job, err = js.Get("X", domain.Caller{Name: "user B", Roles: []string{domain.RoleViewer}})
+	assert.Nil(t, job)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+	assert.EqualValues(t, "job with Id X does not exist", err.Message())
+}
+
+func TestGetAllOwnJobsOnly(t *testing.T) {
+	m, js := newTestJobService()
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+		return &domain.Jobs{{Id: "1", CreatedBy: "user A"}, {Id: "2", CreatedBy: "user B"}, {Id: "3", CreatedBy: "user A"}}, nil
+	}
+	jobs, err := js.GetAll(domain.Caller{Name: "user A", Roles: []string{domain.RoleViewer}})
+	assert.Nil(t, err)
+	assert.EqualValues(t, 2, len(*jobs))
+	assert.EqualValues(t, "3", (*jobs)[1].Id)
+	jobs, err = js.GetAll(testAdmin)
+	assert.Nil(t, err)
+	assert.EqualValues(t, 3, len(*jobs))
+	jobs, err = js.GetAll(domain.Caller{Name: "user C", Roles: []string{domain.RoleViewer}})
+	assert.Nil(t, jobs)
+	assert.EqualValues(t, http.StatusNotFound, err.StatusCode())
+}
+
+func TestUpdateJobOfOtherUser(t *testing.T) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		return &domain.Job{Id: jobId, CreatedBy: "user A", Status: domain.JobStatusCreated}, nil
+	}
+	job, err := js.Update("X", domain.Job{Name: "renamed"}, true, domain.JobFlags{}, domain.Caller{Name: "user B", Roles: []string{domain.RoleSubmitter}})
+	assert.Nil(t, job)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusForbidden, err.StatusCode())
+	assert.EqualValues(t, "Cannot modify job of another user", err.Message())
+}
+
+func TestRetryJobOfOtherUser(t *testing.T) {
+	m, js := newTestJobService()
+	m.getJobFunction = func(jobId string) (*domain.Job, api_error.ApiErr) {
+		return &domain.Job{Id: jobId, CreatedBy: "user A", Status: domain.JobStatusFailed}, nil
+	}
+	job, err := js.Retry("X", domain.Caller{Name: "user B", Roles: []string{domain.RoleSubmitter}})
+	assert.Nil(t, job)
+	assert.EqualValues(t, http.StatusForbidden, err.StatusCode())
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/providers"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/date"
+	"github.com/segmentio/ksuid"
+)
+
+type manifestService struct {
+	cfg              *config.AppConfig
+	jobService       JobService
+	manifestProvider providers.ManifestProvider
+}
+
+type ManifestService interface {
+	Create(domain.JobFilter, domain.Caller) (*domain.Manifest, api_error.ApiErr)
+	Write(domain.JobFilter, domain.Caller) (*domain.Manifest, api_error.ApiErr)
+}
+
+func NewManifestService(cfg *config.AppConfig, jobService JobService, manifestProvider providers.ManifestProvider) ManifestService {
+	return &manifestService{
+		cfg:              cfg,
+		jobService:       jobService,
+		manifestProvider: manifestProvider,
+	}
+}
+
+// Create lists the finished jobs visible to the caller matching the filter
+func (ms *manifestService) Create(filter domain.JobFilter, caller domain.Caller) (*domain.Manifest, api_error.ApiErr) {
+	manifest := domain.Manifest{
+		CreatedAt: date.GetNowUtcString(),
+		Entries:   []domain.ManifestEntry{},
+	}
+	jobs, err := ms.jobService.GetAll(caller)
+	if err != nil && err.StatusCode() != http.StatusNotFound {
+		return nil, err
+	}
+	if jobs != nil {
+		for _, job := range *jobs {
+			if job.Status != domain.JobStatusFinished || !filter.Matches(job) {
+				continue
+			}
+			manifest.Entries = append(manifest.Entries, manifestEntries(job)...)
+		}
+	}
+	sort.Slice(manifest.Entries, func(i, j int) bool {
+		return manifest.Entries[i].Path < manifest.Entries[j].Path
+	})
+	return &manifest, nil
+}
+
+// Write creates the manifest like Create and writes it to the configured location, which only admins may do
+func (ms *manifestService) Write(filter domain.JobFilter, caller domain.Caller) (*domain.Manifest, api_error.ApiErr) {
+	if !caller.HasRole(domain.RoleAdmin) {
+		return nil, domain.WithCode(domain.CodeMissingRole, api_error.NewUnauthorizedError("writing a manifest requires role admin"))
+	}
+	if strings.TrimSpace(ms.cfg.ManifestLocation) == "" {
+		return nil, domain.WithCode(domain.CodeManifestNotConfigured, api_error.NewBadRequestError("no manifest location configured"))
+	}
+	manifest, err := ms.Create(filter, caller)
+	if err != nil {
+		return nil, err
+	}
+	name := fmt.Sprintf("manifest-%s.c4m", ksuid.New().String())
+	location, err := ms.manifestProvider.Write(ms.cfg.ManifestLocation, name, []byte(manifest.Text()))
+	if err != nil {
+		return nil, err
+	}
+	manifest.Location = location
+	return manifest, nil
+}
+
+func manifestEntries(job domain.Job) []domain.ManifestEntry {
+	if job.Type != domain.JobTypeTree {
+		path := job.SrcUrl
+		if job.DstUrl != "" {
+			path = job.DstUrl
+		}
+		return []domain.ManifestEntry{{
+			Path:         path,
+			Size:         job.FileSize,
+			LastModified: job.FileModified,
+			FileC4Id:     job.FileC4Id,
+			JobId:        job.Id,
+		}}
+	}
+	var entries []domain.ManifestEntry
+	var treeSize int64
+	for _, file := range job.Files {
+		treeSize += file.Size
+		entries = append(entries, domain.ManifestEntry{
+			Path:         treeFileUrl(job.SrcUrl, file.Path),
+			Size:         file.Size,
+			LastModified: file.LastModified,
+			FileC4Id:     file.FileC4Id,
+			JobId:        job.Id,
+		})
+	}
+	entries = append(entries, domain.ManifestEntry{
+		Path:         strings.TrimSuffix(job.SrcUrl, "/") + "/",
+		Size:         treeSize,
+		LastModified: job.ModifiedAt,
+		FileC4Id:     job.FileC4Id,
+		JobId:        job.Id,
+	})
+	return entries
+}
+
+// treeFileUrl builds the URL of a file found by a tree job. Paths of local files are relative
+// to the source directory, while blob names are relative to the container.
+func treeFileUrl(srcUrl string, path string) string {
+	u, err := url.Parse(srcUrl)
+	if err != nil || u.Scheme == "file" {
+		return strings.TrimSuffix(srcUrl, "/") + "/" + path
+	}
+	containerName := strings.SplitN(strings.TrimLeft(u.Path, "/"), "/", 2)[0]
+	return u.Scheme + "://" + u.Host + "/" + containerName + "/" + path
+}
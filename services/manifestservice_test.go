@@ -0,0 +1,109 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/c4svc/providers"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestManifestService() (*jobsDaoMock, *manifestService) {
+	m := &jobsDaoMock{}
+	cfg := config.New()
+	jobService := NewJobService(cfg, m, domain.NewIdempotencyDao(), NewQuotaService(cfg, m), noopTracer)
+	return m, NewManifestService(cfg, jobService, providers.NewManifestProvider(cfg)).(*manifestService)
+}
+
+func TestManifestWriteNoLocation(t *testing.T) {
+	_, ms := newTestManifestService()
+	manifest, err := ms.Write(domain.JobFilter{}, testAdmin)
+	assert.Nil(t, manifest)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusBadRequest, err.StatusCode())
+	assert.EqualValues(t, "no manifest location configured", err.Message())
+}
+
+func TestManifestWriteRequiresAdmin(t *testing.T) {
+	_, ms := newTestManifestService()
+	ms.cfg.ManifestLocation = "file://" + t.TempDir()
+	manifest, err := ms.Write(domain.JobFilter{}, domain.Caller{Name: "alice", Roles: []string{domain.RoleSubmitter, domain.RoleViewer}})
+	assert.Nil(t, manifest)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusForbidden, err.StatusCode())
+	assert.EqualValues(t, "writing a manifest requires role admin", err.Message())
+}
+
+func TestManifestNoJobs(t *testing.T) {
+	m, ms := newTestManifestService()
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+		return nil, api_error.NewNotFoundError("no jobs in list")
+	}
+	manifest, err := ms.Create(domain.JobFilter{}, testAdmin)
+	assert.Nil(t, err)
+	assert.NotNil(t, manifest)
+	assert.EqualValues(t, 0, len(manifest.Entries))
+}
+
+func TestManifestFinishedJobsOnly(t *testing.T) {
+	m, ms := newTestManifestService()
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+		jobList := domain.Jobs{
+			{
+				Id:           "1zXgBZNnBG1msmF1ARQK9ZphbbO",
+				SrcUrl:       "https://server/media/file1.ext",
+				DstUrl:       "https://server/media/c4abc.ext",
+				Type:         "CreateAndRename",
+				Status:       "Finished",
+				FileC4Id:     "c4abc",
+				FileSize:     12,
+				FileModified: "2021-10-15T15:00:00Z",
+			},
+			{
+				Id:     "1zXgBZNnBG1msmF1ARQK9ZphbcO",
+				SrcUrl: "https://server/media/file2.ext",
+				Type:   "Create",
+				Status: "Running",
+			},
+			{
+				Id:         "1zXgBZNnBG1msmF1ARQK9ZphbdO",
+				SrcUrl:     "https://server/tree/path1",
+				Type:       "Tree",
+				Status:     "Finished",
+				FileC4Id:   "c4tree",
+				ModifiedAt: "2021-10-15T16:00:00Z",
+				Files: []domain.TreeFile{
+					{Path: "path1/a.ext", Size: 1, FileC4Id: "c4a"},
+					{Path: "path1/b.ext", Size: 2, FileC4Id: "c4b"},
+				},
+			},
+		}
+		return &jobList, nil
+	}
+	manifest, err := ms.Create(domain.JobFilter{}, testAdmin)
+	assert.Nil(t, err)
+	assert.NotNil(t, manifest)
+	assert.EqualValues(t, 4, len(manifest.Entries))
+	assert.EqualValues(t, "https://server/media/c4abc.ext", manifest.Entries[0].Path)
+	assert.EqualValues(t, 12, manifest.Entries[0].Size)
+	assert.EqualValues(t, "https://server/tree/path1/", manifest.Entries[1].Path)
+	assert.EqualValues(t, 3, manifest.Entries[1].Size)
+	assert.EqualValues(t, "c4tree", manifest.Entries[1].FileC4Id)
+	assert.EqualValues(t, "https://server/tree/path1/a.ext", manifest.Entries[2].Path)
+	assert.EqualValues(t, "https://server/tree/path1/b.ext", manifest.Entries[3].Path)
+}
+
+func TestManifestWriteLocal(t *testing.T) {
+	m, ms := newTestManifestService()
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+		return nil, api_error.NewNotFoundError("no jobs in list")
+	}
+	ms.cfg.ManifestLocation = "file://" + t.TempDir()
+	manifest, err := ms.Write(domain.JobFilter{}, testAdmin)
+	assert.Nil(t, err)
+	assert.NotNil(t, manifest)
+	assert.Contains(t, manifest.Location, ms.cfg.ManifestLocation+"/manifest-")
+}
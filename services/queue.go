@@ -0,0 +1,28 @@
+package services
+
+import (
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+func NewQueueLimits(cfg *config.AppConfig) domain.QueueLimits {
+	return domain.QueueLimits{
+		MaxQueued: cfg.QueueMaxJobs,
+		MaxMemory: cfg.QueueMaxMemory,
+	}
+}
+
+// checkQueue rejects adding jobs while the job store is saturated, asking the client to retry later
+func checkQueue(cfg *config.AppConfig, jobDao domain.JobDao, adding int) api_error.ApiErr {
+	limits := NewQueueLimits(cfg)
+	if !limits.Enabled() {
+		return nil
+	}
+	if reason := limits.Full(jobDao.Stats(), adding); reason != "" {
+		logger.Warn(reason)
+		return NewServiceUnavailableError(domain.CodeQueueFull, reason, cfg.QueueRetryAfter)
+	}
+	return nil
+}
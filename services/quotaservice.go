@@ -0,0 +1,301 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/johannes-kuhfuss/services_utils/logger"
+)
+
+const (
+	// maxIdleBuckets is the number of token buckets kept before full buckets are dropped
+	maxIdleBuckets = 10000
+	// usageRetention is how long the counters of a client are kept after its last activity
+	usageRetention = 48 * time.Hour
+)
+
+type QuotaService interface {
+	Allow(string) api_error.ApiErr
+	Reserve(domain.Caller, int) (func(), api_error.ApiErr)
+	ReserveBytes(string, int64) (func(), api_error.ApiErr)
+	AddBytes(string, int64)
+	Report() domain.QuotaReport
+}
+
+type quotaService struct {
+	cfg     *config.AppConfig
+	jobDao  domain.JobDao
+	now     func() time.Time
+	mu      sync.Mutex
+	buckets map[string]*tokenBucket
+	usage   map[string]*clientUsage
+	// reserved counts jobs that passed the quota check but have not been saved yet
+	reserved map[string]int
+	// reservedBytes counts bytes of running jobs that passed the quota check but have not been added yet
+	reservedBytes map[string]int64
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type clientUsage struct {
+	day       string
+	bytes     int64
+	throttled int64
+	rejected  int64
+	last      time.Time
+}
+
+func NewQuotaService(cfg *config.AppConfig, jobDao domain.JobDao) QuotaService {
+	return &quotaService{
+		cfg:           cfg,
+		jobDao:        jobDao,
+		now:           time.Now,
+		buckets:       make(map[string]*tokenBucket),
+		usage:         make(map[string]*clientUsage),
+		reserved:      make(map[string]int),
+		reservedBytes: make(map[string]int64),
+	}
+}
+
+// Allow takes a token from the bucket of the client. Buckets hold up to RateBurst tokens and
+// are refilled with RateLimit tokens per minute.
+func (qs *quotaService) Allow(client string) api_error.ApiErr {
+	if qs.cfg.RateLimit <= 0 {
+		return nil
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	now := qs.now()
+	burst := float64(qs.cfg.RateBurst)
+	rate := float64(qs.cfg.RateLimit) / time.Minute.Seconds()
+	bucket, ok := qs.buckets[client]
+	if !ok {
+		if len(qs.buckets) >= maxIdleBuckets {
+			qs.dropFullBuckets(now, rate, burst)
+		}
+		bucket = &tokenBucket{tokens: burst, last: now}
+		qs.buckets[client] = bucket
+	}
+	bucket.refill(now, rate, burst)
+	if bucket.tokens >= 1 {
+		bucket.tokens--
+		return nil
+	}
+	qs.clientUsage(client, now).throttled++
+	wait := time.Duration((1 - bucket.tokens) / rate * float64(time.Second))
+	logger.Info(fmt.Sprintf("Rate limit exceeded for %v", client))
+	return NewTooManyRequestsError(domain.CodeRateLimited, "rate limit exceeded", wait)
+}
+
+func (b *tokenBucket) refill(now time.Time, rate float64, burst float64) {
+	b.tokens += now.Sub(b.last).Seconds() * rate
+	if b.tokens > burst {
+		b.tokens = burst
+	}
+	b.last = now
+}
+
+func (qs *quotaService) dropFullBuckets(now time.Time, rate float64, burst float64) {
+	for client, bucket := range qs.buckets {
+		bucket.refill(now, rate, burst)
+		if bucket.tokens >= burst {
+			delete(qs.buckets, client)
+		}
+	}
+}
+
+// Reserve verifies that the caller may queue count more jobs without exceeding the maximum of
+// queued jobs or the bytes per day, and reserves them until the returned function is called.
+// Callers release the reservation once the jobs are saved or could not be created. Bytes per day
+// include the bytes hashed today and the known sizes of the caller's queued and running jobs.
+func (qs *quotaService) Reserve(caller domain.Caller, count int) (func(), api_error.ApiErr) {
+	maxQueued := qs.cfg.QuotaMaxQueued
+	maxBytes := qs.cfg.QuotaMaxBytesDay
+	if maxQueued <= 0 && maxBytes <= 0 {
+		return func() {}, nil
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	now := qs.now().UTC()
+	queued, pendingBytes := qs.pendingJobs(caller.Name)
+	usage := qs.clientUsage(caller.Name, now)
+	if maxQueued > 0 && queued+qs.reserved[caller.Name]+count > maxQueued {
+		usage.rejected++
+		logger.Info(fmt.Sprintf("Queued jobs quota exceeded for %v", caller.Name))
+		return nil, NewTooManyRequestsError(domain.CodeQuotaExceeded, fmt.Sprintf("quota of %d queued jobs exceeded", maxQueued), qs.cfg.NoJobWaitTime)
+	}
+	if maxBytes > 0 && usage.bytesOn(now)+pendingBytes >= maxBytes {
+		usage.rejected++
+		logger.Info(fmt.Sprintf("Daily bytes quota exceeded for %v", caller.Name))
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return nil, NewTooManyRequestsError(domain.CodeQuotaExceeded, fmt.Sprintf("quota of %d bytes per day exceeded", maxBytes), tomorrow.Sub(now))
+	}
+	qs.reserved[caller.Name] += count
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			qs.mu.Lock()
+			defer qs.mu.Unlock()
+			if qs.reserved[caller.Name] -= count; qs.reserved[caller.Name] <= 0 {
+				delete(qs.reserved, caller.Name)
+			}
+		})
+	}
+	return release, nil
+}
+
+// ReserveBytes verifies that the user may hash size more bytes today, once the size of a source is known
+// and before it is read, and reserves them until the returned function is called. Callers add the bytes
+// actually hashed before releasing the reservation.
+func (qs *quotaService) ReserveBytes(user string, size int64) (func(), api_error.ApiErr) {
+	maxBytes := qs.cfg.QuotaMaxBytesDay
+	if maxBytes <= 0 {
+		return func() {}, nil
+	}
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	now := qs.now().UTC()
+	usage := qs.clientUsage(user, now)
+	if usage.bytesOn(now)+qs.reservedBytes[user]+size > maxBytes {
+		usage.rejected++
+		logger.Info(fmt.Sprintf("Daily bytes quota exceeded for %v", user))
+		tomorrow := time.Date(now.Year(), now.Month(), now.Day()+1, 0, 0, 0, 0, time.UTC)
+		return nil, NewTooManyRequestsError(domain.CodeQuotaExceeded, fmt.Sprintf("source of %d bytes exceeds the quota of %d bytes per day", size, maxBytes), tomorrow.Sub(now))
+	}
+	qs.reservedBytes[user] += size
+	var once sync.Once
+	release := func() {
+		once.Do(func() {
+			qs.mu.Lock()
+			defer qs.mu.Unlock()
+			if qs.reservedBytes[user] -= size; qs.reservedBytes[user] <= 0 {
+				delete(qs.reservedBytes, user)
+			}
+		})
+	}
+	return release, nil
+}
+
+// pendingJobs counts the queued jobs of the user and the bytes its queued and running jobs will hash, as far as known
+func (qs *quotaService) pendingJobs(user string) (int, int64) {
+	queued := 0
+	var bytes int64
+	jobs, err := qs.jobDao.GetAll()
+	if err != nil {
+		return 0, 0
+	}
+	for _, job := range *jobs {
+		if job.CreatedBy != user {
+			continue
+		}
+		switch job.Status {
+		case domain.JobStatusCreated:
+			queued++
+			bytes += job.FileSize
+		case domain.JobStatusRunning:
+			if job.BytesTotal > 0 {
+				bytes += job.BytesTotal
+			} else {
+				bytes += job.FileSize
+			}
+		}
+	}
+	return queued, bytes
+}
+
+// AddBytes counts bytes hashed for the user towards today's quota
+func (qs *quotaService) AddBytes(user string, bytes int64) {
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	now := qs.now().UTC()
+	usage := qs.clientUsage(user, now)
+	usage.bytesOn(now)
+	usage.bytes += bytes
+}
+
+// Report returns the configured limits and the counters of all known clients
+func (qs *quotaService) Report() domain.QuotaReport {
+	queued := qs.queuedJobs()
+	qs.mu.Lock()
+	defer qs.mu.Unlock()
+	now := qs.now().UTC()
+	qs.expireUsage(now)
+	for user := range queued {
+		qs.clientUsage(user, now)
+	}
+	report := domain.QuotaReport{
+		RateLimit:      qs.cfg.RateLimit,
+		RateBurst:      qs.cfg.RateBurst,
+		MaxQueuedJobs:  qs.cfg.QuotaMaxQueued,
+		MaxBytesPerDay: qs.cfg.QuotaMaxBytesDay,
+		Clients:        make([]domain.QuotaUsage, 0, len(qs.usage)),
+	}
+	for client, usage := range qs.usage {
+		report.Clients = append(report.Clients, domain.QuotaUsage{
+			Client:     client,
+			QueuedJobs: queued[client],
+			BytesToday: usage.bytesOn(now),
+			Throttled:  usage.throttled,
+			Rejected:   usage.rejected,
+		})
+	}
+	sort.Slice(report.Clients, func(i, j int) bool {
+		return report.Clients[i].Client < report.Clients[j].Client
+	})
+	return report
+}
+
+// clientUsage returns the counters of the client and marks it as active
+func (qs *quotaService) clientUsage(client string, now time.Time) *clientUsage {
+	usage, ok := qs.usage[client]
+	if !ok {
+		if len(qs.usage) >= maxIdleBuckets {
+			qs.expireUsage(now)
+		}
+		usage = &clientUsage{}
+		qs.usage[client] = usage
+	}
+	usage.last = now
+	return usage
+}
+
+// expireUsage drops the counters of clients that were not active within usageRetention
+func (qs *quotaService) expireUsage(now time.Time) {
+	for client, usage := range qs.usage {
+		if now.Sub(usage.last) > usageRetention {
+			delete(qs.usage, client)
+		}
+	}
+}
+
+// bytesOn returns the bytes hashed on the day of now, resetting the counter when the day changed
+func (u *clientUsage) bytesOn(now time.Time) int64 {
+	day := now.Format("2006-01-02")
+	if u.day != day {
+		u.day = day
+		u.bytes = 0
+	}
+	return u.bytes
+}
+
+func (qs *quotaService) queuedJobs() map[string]int {
+	queued := make(map[string]int)
+	jobs, err := qs.jobDao.GetAll()
+	if err != nil {
+		return queued
+	}
+	for _, job := range *jobs {
+		if job.Status == domain.JobStatusCreated {
+			queued[job.CreatedBy]++
+		}
+	}
+	return queued
+}
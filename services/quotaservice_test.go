@@ -0,0 +1,172 @@
+package services
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/johannes-kuhfuss/c4svc/config"
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestQuotaService(cfg *config.AppConfig, now time.Time) (*jobsDaoMock, *quotaService) {
+	m := &jobsDaoMock{
+		getAllFunction: func() (*domain.Jobs, api_error.ApiErr) {
+			return nil, domain.WithCode(domain.CodeJobNotFound, api_error.NewNotFoundError("no jobs in list"))
+		},
+	}
+	qs := NewQuotaService(cfg, m).(*quotaService)
+	qs.now = func() time.Time { return now }
+	return m, qs
+}
+
+func TestAllowNoLimit(t *testing.T) {
+	_, qs := newTestQuotaService(config.New(), time.Now())
+	for i := 0; i < 100; i++ {
+		assert.Nil(t, qs.Allow("client"))
+	}
+}
+
+func TestAllowTokenBucket(t *testing.T) {
+	cfg := config.New()
+	cfg.RateLimit = 60
+	cfg.RateBurst = 2
+	now := time.Date(2022, 5, 1, 12, 0, 0, 0, time.UTC)
+	_, qs := newTestQuotaService(cfg, now)
+	assert.Nil(t, qs.Allow("client"))
+	assert.Nil(t, qs.Allow("client"))
+	assert.Nil(t, qs.Allow("other"))
+	err := qs.Allow("client")
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusTooManyRequests, err.StatusCode())
+	assert.EqualValues(t, time.Second, err.(RetryAfterErr).RetryAfter())
+	qs.now = func() time.Time { return now.Add(time.Second) }
+	assert.Nil(t, qs.Allow("client"))
+	assert.NotNil(t, qs.Allow("client"))
+	assert.EqualValues(t, 2, qs.usage["client"].throttled)
+}
+
+func TestCheckMaxQueuedJobs(t *testing.T) {
+	cfg := config.New()
+	cfg.QuotaMaxQueued = 2
+	m, qs := newTestQuotaService(cfg, time.Now())
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+		return &domain.Jobs{
+			{CreatedBy: "user A", Status: domain.JobStatusCreated},
+			{CreatedBy: "user A", Status: domain.JobStatusFinished},
+			{CreatedBy: "user B", Status: domain.JobStatusCreated},
+		}, nil
+	}
+	release, err := qs.Reserve(domain.Caller{Name: "user A"}, 1)
+	assert.Nil(t, err)
+	release()
+	_, err = qs.Reserve(domain.Caller{Name: "user A"}, 2)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusTooManyRequests, err.StatusCode())
+	assert.EqualValues(t, "quota of 2 queued jobs exceeded", err.Message())
+	assert.EqualValues(t, 1, qs.usage["user A"].rejected)
+}
+
+func TestCheckMaxBytesPerDay(t *testing.T) {
+	cfg := config.New()
+	cfg.QuotaMaxBytesDay = 100
+	now := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	_, qs := newTestQuotaService(cfg, now)
+	qs.AddBytes("user A", 60)
+	_, err := qs.Reserve(domain.Caller{Name: "user A"}, 1)
+	assert.Nil(t, err)
+	qs.AddBytes("user A", 40)
+	_, err = qs.Reserve(domain.Caller{Name: "user A"}, 1)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, "quota of 100 bytes per day exceeded", err.Message())
+	assert.EqualValues(t, 6*time.Hour, err.(RetryAfterErr).RetryAfter())
+	_, err = qs.Reserve(domain.Caller{Name: "user B"}, 1)
+	assert.Nil(t, err)
+	qs.now = func() time.Time { return now.Add(6 * time.Hour) }
+	_, err = qs.Reserve(domain.Caller{Name: "user A"}, 1)
+	assert.Nil(t, err)
+}
+
+func TestReserveCountsReservedJobs(t *testing.T) {
+	cfg := config.New()
+	cfg.QuotaMaxQueued = 3
+	m, qs := newTestQuotaService(cfg, time.Now())
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+		return &domain.Jobs{{CreatedBy: "user A", Status: domain.JobStatusCreated}}, nil
+	}
+	release, err := qs.Reserve(domain.Caller{Name: "user A"}, 2)
+	assert.Nil(t, err)
+	_, err = qs.Reserve(domain.Caller{Name: "user A"}, 1)
+	assert.NotNil(t, err)
+	release()
+	release()
+	assert.EqualValues(t, 0, len(qs.reserved))
+	_, err = qs.Reserve(domain.Caller{Name: "user A"}, 2)
+	assert.Nil(t, err)
+}
+
+func TestReserveCountsPendingBytes(t *testing.T) {
+	cfg := config.New()
+	cfg.QuotaMaxBytesDay = 100
+	m, qs := newTestQuotaService(cfg, time.Now())
+	jobs := domain.Jobs{{CreatedBy: "user A", Status: domain.JobStatusRunning, JobProgress: domain.JobProgress{BytesTotal: 50, BytesProcessed: 10}}}
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+		return &jobs, nil
+	}
+	qs.AddBytes("user A", 40)
+	_, err := qs.Reserve(domain.Caller{Name: "user A"}, 1)
+	assert.Nil(t, err)
+	jobs = append(jobs, domain.Job{CreatedBy: "user A", Status: domain.JobStatusCreated, FileSize: 10})
+	_, err = qs.Reserve(domain.Caller{Name: "user A"}, 1)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, "quota of 100 bytes per day exceeded", err.Message())
+}
+
+func TestReserveBytesCountsReservedBytes(t *testing.T) {
+	cfg := config.New()
+	cfg.QuotaMaxBytesDay = 100
+	_, qs := newTestQuotaService(cfg, time.Now())
+	qs.AddBytes("user A", 30)
+	release, err := qs.ReserveBytes("user A", 50)
+	assert.Nil(t, err)
+	_, err = qs.ReserveBytes("user A", 30)
+	assert.NotNil(t, err)
+	assert.EqualValues(t, http.StatusTooManyRequests, err.StatusCode())
+	assert.EqualValues(t, domain.CodeQuotaExceeded, domain.ErrorCode(err))
+	_, err = qs.ReserveBytes("user B", 80)
+	assert.Nil(t, err)
+	qs.AddBytes("user A", 20)
+	release()
+	_, err = qs.ReserveBytes("user A", 50)
+	assert.Nil(t, err)
+}
+
+func TestReportExpiresIdleClients(t *testing.T) {
+	now := time.Date(2022, 5, 1, 18, 0, 0, 0, time.UTC)
+	_, qs := newTestQuotaService(config.New(), now)
+	qs.AddBytes("user A", 10)
+	qs.now = func() time.Time { return now.Add(usageRetention + time.Minute) }
+	qs.AddBytes("user B", 10)
+	report := qs.Report()
+	assert.EqualValues(t, 1, len(report.Clients))
+	assert.EqualValues(t, "user B", report.Clients[0].Client)
+}
+
+func TestQuotaReport(t *testing.T) {
+	cfg := config.New()
+	cfg.QuotaMaxQueued = 5
+	m, qs := newTestQuotaService(cfg, time.Now())
+	m.getAllFunction = func() (*domain.Jobs, api_error.ApiErr) {
+		return &domain.Jobs{{CreatedBy: "user B", Status: domain.JobStatusCreated}}, nil
+	}
+	qs.AddBytes("user A", 10)
+	report := qs.Report()
+	assert.EqualValues(t, 5, report.MaxQueuedJobs)
+	assert.EqualValues(t, 2, len(report.Clients))
+	assert.EqualValues(t, "user A", report.Clients[0].Client)
+	assert.EqualValues(t, 10, report.Clients[0].BytesToday)
+	assert.EqualValues(t, "user B", report.Clients[1].Client)
+	assert.EqualValues(t, 1, report.Clients[1].QueuedJobs)
+}
@@ -0,0 +1,38 @@
+package services
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+)
+
+// RetryAfterErr is an error for a request that may succeed when it is repeated after RetryAfter
+type RetryAfterErr interface {
+	domain.CodedErr
+	RetryAfter() time.Duration
+}
+
+type retryAfterErr struct {
+	domain.CodedErr
+	retryAfter time.Duration
+}
+
+func NewTooManyRequestsError(code string, msg string, retryAfter time.Duration) RetryAfterErr {
+	return retryAfterErr{
+		CodedErr:   domain.WithCode(code, api_error.NewError(msg, http.StatusTooManyRequests, nil)),
+		retryAfter: retryAfter,
+	}
+}
+
+func NewServiceUnavailableError(code string, msg string, retryAfter time.Duration) RetryAfterErr {
+	return retryAfterErr{
+		CodedErr:   domain.WithCode(code, api_error.NewError(msg, http.StatusServiceUnavailable, nil)),
+		retryAfter: retryAfter,
+	}
+}
+
+func (e retryAfterErr) RetryAfter() time.Duration {
+	return e.retryAfter
+}
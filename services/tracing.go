@@ -0,0 +1,19 @@
+package services
+
+import (
+	"github.com/johannes-kuhfuss/c4svc/domain"
+	"github.com/johannes-kuhfuss/services_utils/api_error"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// endSpan records err on the span, if any, and ends it
+func endSpan(span trace.Span, err api_error.ApiErr) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Message())
+		span.SetAttributes(attribute.String("error.code", domain.ErrorCode(err)))
+	}
+	span.End()
+}